@@ -0,0 +1,62 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// Deadline closes a channel when it expires.
+//
+// It is used to implement the deadlines of net.Conn and net.PacketConn.
+type Deadline struct {
+	lock   sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+// NewDeadline creates a deadline that does not expire.
+func NewDeadline() *Deadline {
+	return &Deadline{cancel: make(chan struct{})}
+}
+
+// Set sets the deadline. A zero time disables the deadline.
+func (d *Deadline) Set(t time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		// The timer has fired and closed the channel already.
+		<-d.cancel
+	}
+	d.timer = nil
+
+	expired := IsClosed(d.cancel)
+	if t.IsZero() || time.Until(t) > 0 {
+		if expired {
+			d.cancel = make(chan struct{})
+		}
+		if !t.IsZero() {
+			cancel := d.cancel
+			d.timer = time.AfterFunc(time.Until(t), func() { close(cancel) })
+		}
+	} else if !expired {
+		close(d.cancel)
+	}
+}
+
+// Wait returns a channel that is closed when the deadline expires.
+func (d *Deadline) Wait() <-chan struct{} {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.cancel
+}
+
+// IsClosed checks whether a channel is closed without blocking.
+func IsClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
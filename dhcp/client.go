@@ -0,0 +1,404 @@
+package dhcp
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/udp"
+)
+
+var (
+	// ErrTimeout is returned when no lease is obtained in time.
+	ErrTimeout = errors.New("no DHCP lease obtained")
+
+	// ErrStarted is returned when starting a client twice.
+	ErrStarted = errors.New("DHCP client already started")
+
+	// ErrStopped is returned when using a client that is stopped.
+	ErrStopped = errors.New("DHCP client stopped")
+)
+
+const (
+	// DefaultRetransmit is the time after which messages are sent again
+	// when no reply is received. The time doubles for every attempt, up
+	// to MaxRetransmit.
+	DefaultRetransmit = 4 * time.Second
+
+	// MaxRetransmit is the maximum time between retransmissions.
+	MaxRetransmit = 64 * time.Second
+)
+
+// Lease is an address lease.
+type Lease struct {
+	Address ipv4.Address
+	Netmask ipv4.Address
+
+	// Gateway is nil if the server did not offer a router.
+	Gateway *ipv4.Address
+	DNS     []ipv4.Address
+
+	// Server is the address of the server that granted the lease.
+	Server ipv4.Address
+
+	// Obtained is the time at which the lease was granted or last
+	// renewed. The lease expires after Duration, and is renewed and
+	// rebound after Renewal and Rebinding.
+	Obtained  time.Time
+	Duration  time.Duration
+	Renewal   time.Duration
+	Rebinding time.Duration
+}
+
+// Client is a DHCP client that configures the IPv4 layer, router and ARP
+// with the leased address.
+//
+// A client starts in the INIT state. It renews its lease with the server
+// that granted it, rebinds it with any server when the server does not
+// answer, and starts over when the lease expires or is refused.
+type Client interface {
+	// Start binds the DHCP client port and obtains a lease in the
+	// background. A client cannot be started again after it is stopped.
+	Start() error
+
+	// Stop releases the lease and the DHCP client port.
+	Stop() error
+
+	// Lease returns the current lease. False is returned if the client
+	// has no lease.
+	Lease() (Lease, bool)
+
+	// Wait waits until the client has a lease.
+	//
+	// See also ErrTimeout and ErrStopped.
+	Wait(timeout time.Duration) (Lease, error)
+}
+
+type client struct {
+	mac        ethernet.MAC
+	udp        udp.Layer
+	ip         ipv4.Layer
+	router     ipv4.Router
+	arp        ipv4.ARP
+	retransmit time.Duration
+
+	lock   sync.Mutex
+	lease  *Lease
+	bound  chan struct{}
+	socket udp.Socket
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewClient creates a DHCP client with the default configuration.
+func NewClient(mac ethernet.MAC, u udp.Layer, ip ipv4.Layer, router ipv4.Router, arp ipv4.ARP) Client {
+	return NewCustomClient(mac, u, ip, router, arp, DefaultRetransmit)
+}
+
+// NewCustomClient creates a DHCP client with a custom initial
+// retransmission time.
+func NewCustomClient(mac ethernet.MAC, u udp.Layer, ip ipv4.Layer, router ipv4.Router, arp ipv4.ARP, retransmit time.Duration) Client {
+	return &client{
+		mac:        mac,
+		udp:        u,
+		ip:         ip,
+		router:     router,
+		arp:        arp,
+		retransmit: retransmit,
+		bound:      make(chan struct{}),
+	}
+}
+
+func (c *client) Start() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.stop != nil {
+		return ErrStarted
+	}
+	s, err := c.udp.Bind(ipv4.Address{}, ClientPort)
+	if err != nil {
+		return err
+	}
+	c.socket = s
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	go c.run()
+	return nil
+}
+
+func (c *client) Stop() error {
+	c.lock.Lock()
+	if c.stop == nil || common.IsClosed(c.stop) {
+		c.lock.Unlock()
+		return ErrStopped
+	}
+	close(c.stop)
+	done := c.done
+	c.lock.Unlock()
+
+	<-done
+	return nil
+}
+
+func (c *client) Lease() (Lease, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.lease == nil {
+		return Lease{}, false
+	}
+	return *c.lease, true
+}
+
+func (c *client) Wait(timeout time.Duration) (Lease, error) {
+	c.lock.Lock()
+	bound, stop := c.bound, c.stop
+	c.lock.Unlock()
+
+	select {
+	case <-bound:
+		if lease, ok := c.Lease(); ok {
+			return lease, nil
+		}
+		return Lease{}, ErrStopped
+	case <-stop:
+		return Lease{}, ErrStopped
+	case <-time.After(timeout):
+		return Lease{}, ErrTimeout
+	}
+}
+
+func (c *client) run() {
+	defer close(c.done)
+	defer c.socket.Close()
+
+	for {
+		lease, err := c.acquire()
+		if err != nil {
+			return
+		}
+		c.configure(&lease)
+
+		lease, err = c.maintain(lease)
+		if err == ErrStopped {
+			c.release(lease)
+			c.configure(nil)
+			return
+		}
+		c.configure(nil)
+	}
+}
+
+// acquire obtains a new lease.
+func (c *client) acquire() (Lease, error) {
+	for {
+		xid := rand.Uint32()
+		discover := c.newMessage(Discover, xid)
+		offer, err := c.exchange(discover, ipv4.Broadcast, time.Time{}, Offer)
+		if err != nil {
+			return Lease{}, err
+		}
+
+		request := c.newMessage(Request, xid)
+		request.SetOption(OptionRequestedAddress, offer.YourAddress.Bytes())
+		if server, ok := offer.Option(OptionServerID); ok {
+			request.SetOption(OptionServerID, server)
+		}
+		ack, err := c.exchange(request, ipv4.Broadcast, time.Now().Add(MaxRetransmit), Ack, Nak)
+		if err == ErrStopped {
+			return Lease{}, err
+		} else if err == nil && ack.Type() == Ack {
+			return newLease(ack), nil
+		}
+	}
+}
+
+// maintain renews and rebinds a lease until it expires, or until the
+// server refuses it.
+func (c *client) maintain(lease Lease) (Lease, error) {
+	for lease.Duration != Infinity {
+		select {
+		case <-time.After(time.Until(lease.Obtained.Add(lease.Renewal))):
+		case <-c.stop:
+			return lease, ErrStopped
+		}
+
+		// Renew with the server that granted the lease, and rebind with
+		// any server if it does not answer.
+		xid := rand.Uint32()
+		request := c.newMessage(Request, xid)
+		request.Flags = 0
+		request.ClientAddress = lease.Address
+		ack, err := c.exchange(request, lease.Server, lease.Obtained.Add(lease.Rebinding), Ack, Nak)
+		if err == ErrTimeout {
+			ack, err = c.exchange(request, ipv4.Broadcast, lease.Obtained.Add(lease.Duration), Ack, Nak)
+		}
+		if err != nil {
+			return lease, err
+		} else if ack.Type() == Nak {
+			return lease, nil
+		}
+		lease = newLease(ack)
+		c.configure(&lease)
+	}
+
+	<-c.stop
+	return lease, ErrStopped
+}
+
+// release gives up a lease.
+func (c *client) release(lease Lease) {
+	m := c.newMessage(Release, rand.Uint32())
+	m.Flags = 0
+	m.ClientAddress = lease.Address
+	m.SetOption(OptionServerID, lease.Server.Bytes())
+	c.socket.Send(lease.Server, ServerPort, common.PacketToBytes(m))
+}
+
+// configure applies a lease to the IPv4 layer, router and ARP. A nil
+// lease removes the address.
+func (c *client) configure(lease *Lease) {
+	var address, netmask ipv4.Address
+	var gateway *ipv4.Address
+	if lease != nil {
+		address, netmask, gateway = lease.Address, lease.Netmask, lease.Gateway
+	}
+	c.ip.SetAddress(address)
+	c.arp.Configure(address, netmask)
+	c.router.Configure(address, netmask, gateway)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lease = nil
+	if lease != nil {
+		// The caller reuses its lease variable for the next lease.
+		l := *lease
+		c.lease = &l
+	}
+	if lease != nil && !common.IsClosed(c.bound) {
+		close(c.bound)
+	} else if lease == nil && common.IsClosed(c.bound) {
+		c.bound = make(chan struct{})
+	}
+}
+
+func (c *client) newMessage(t MessageType, xid uint32) Message {
+	m := NewClientMessage(t, xid, c.mac)
+	m.Flags = FlagBroadcast
+	m.SetOption(OptionParameterRequestList, []byte{
+		OptionSubnetMask, OptionRouter, OptionDNS,
+		OptionLeaseTime, OptionRenewalTime, OptionRebindingTime,
+	})
+	return m
+}
+
+// exchange sends a message until a reply of one of the types is received,
+// or until the deadline. A zero deadline never expires.
+func (c *client) exchange(m Message, destination ipv4.Address, deadline time.Time, types ...MessageType) (Message, error) {
+	payload := common.PacketToBytes(m)
+	interval := c.retransmit
+	for {
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return Message{}, ErrTimeout
+		}
+		c.socket.Send(destination, ServerPort, payload)
+
+		wait := interval + time.Duration(rand.Int63n(int64(interval)/4+1))
+		if !deadline.IsZero() && time.Until(deadline) < wait {
+			wait = time.Until(deadline)
+		}
+		if reply, ok := c.receive(m, wait, types); ok {
+			return reply, nil
+		} else if common.IsClosed(c.stop) {
+			return Message{}, ErrStopped
+		}
+
+		if interval *= 2; interval > MaxRetransmit {
+			interval = MaxRetransmit
+		}
+	}
+}
+
+// receive waits for a reply to a message.
+func (c *client) receive(m Message, wait time.Duration, types []MessageType) (Message, bool) {
+	timeout := time.After(wait)
+	for {
+		select {
+		case p, ok := <-c.socket.Packets():
+			if !ok {
+				return Message{}, false
+			}
+			reply, err := NewMessage(bytes.NewReader(p.Payload))
+			if err != nil || reply.Operation != BootReply || reply.TransactionID != m.TransactionID {
+				continue
+			} else if !bytes.Equal(reply.ClientHardware[:6], c.mac[:]) {
+				continue
+			}
+			for _, t := range types {
+				if reply.Type() == t {
+					return reply, true
+				}
+			}
+		case <-timeout:
+			return Message{}, false
+		case <-c.stop:
+			return Message{}, false
+		}
+	}
+}
+
+func newLease(ack Message) Lease {
+	lease := Lease{
+		Address:  ack.YourAddress,
+		DNS:      ack.Addresses(OptionDNS),
+		Server:   ack.ServerAddress,
+		Obtained: time.Now(),
+		Duration: Infinity,
+	}
+	if server, ok := ack.Address(OptionServerID); ok {
+		lease.Server = server
+	}
+
+	var ok bool
+	if lease.Netmask, ok = ack.Address(OptionSubnetMask); !ok {
+		lease.Netmask = defaultNetmask(lease.Address)
+	}
+	if gateway, ok := ack.Address(OptionRouter); ok {
+		lease.Gateway = &gateway
+	}
+
+	if d, ok := ack.Duration(OptionLeaseTime); ok {
+		lease.Duration = d
+	}
+	lease.Renewal = lease.Duration / 2
+	lease.Rebinding = lease.Duration / 8 * 7
+	if d, ok := ack.Duration(OptionRenewalTime); ok && d < lease.Duration {
+		lease.Renewal = d
+	}
+	if d, ok := ack.Duration(OptionRebindingTime); ok && d < lease.Duration {
+		lease.Rebinding = d
+	}
+	if lease.Rebinding < lease.Renewal {
+		lease.Rebinding = lease.Renewal
+	}
+	return lease
+}
+
+// defaultNetmask returns the netmask of the address class, which is used
+// when the server does not send one.
+func defaultNetmask(a ipv4.Address) ipv4.Address {
+	switch {
+	case a[0] < 128:
+		return ipv4.Address{255, 0, 0, 0}
+	case a[0] < 192:
+		return ipv4.Address{255, 255, 0, 0}
+	default:
+		return ipv4.Address{255, 255, 255, 0}
+	}
+}
@@ -0,0 +1,119 @@
+package dhcp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/stack"
+	"github.com/unigornel/go-tcpip/virtual"
+)
+
+// server is a DHCP server that leases 10.0.0.2 for two seconds.
+type server struct {
+	messages chan Message
+	nak      chan bool
+}
+
+func newServer(t *testing.T, s *stack.Stack) *server {
+	socket, err := s.UDP.Bind(ipv4.Address{}, ServerPort)
+	assert.Nil(t, err)
+	srv := &server{messages: make(chan Message, 16), nak: make(chan bool, 1)}
+
+	go func() {
+		for p := range socket.Packets() {
+			m, err := NewMessage(bytes.NewReader(p.Payload))
+			if err != nil {
+				continue
+			}
+			srv.messages <- m
+
+			reply := m
+			reply.Operation = BootReply
+			reply.Options = nil
+			switch m.Type() {
+			case Discover:
+				reply.SetOption(OptionMessageType, []byte{Offer})
+			case Request:
+				select {
+				case <-srv.nak:
+					reply.SetOption(OptionMessageType, []byte{Nak})
+				default:
+					reply.SetOption(OptionMessageType, []byte{Ack})
+				}
+			default:
+				continue
+			}
+			reply.YourAddress = ipv4.Address{10, 0, 0, 2}
+			reply.SetOption(OptionServerID, []byte{10, 0, 0, 1})
+			reply.SetOption(OptionSubnetMask, []byte{255, 255, 255, 0})
+			reply.SetOption(OptionRouter, []byte{10, 0, 0, 1})
+			reply.SetOption(OptionDNS, []byte{10, 0, 0, 53})
+			reply.SetOption(OptionLeaseTime, []byte{0, 0, 0, 2})
+			socket.Send(ipv4.Broadcast, ClientPort, common.PacketToBytes(reply))
+		}
+	}()
+	return srv
+}
+
+func (s *server) next(t *testing.T, expected MessageType) Message {
+	select {
+	case m := <-s.messages:
+		assert.Equal(t, expected, m.Type())
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No message of type %v received", expected)
+		return Message{}
+	}
+}
+
+func TestClient(t *testing.T) {
+	a, b := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	netmask := ipv4.Address{255, 255, 255, 0}
+	srv := newServer(t, stack.New(stack.Config{NIC: a, Address: ipv4.Address{10, 0, 0, 1}, Netmask: netmask}))
+	s := stack.New(stack.Config{NIC: b})
+	c := NewCustomClient(b.GetMAC(), s.UDP, s.IPv4, s.Router, s.ARP, 100*time.Millisecond)
+
+	_, ok := c.Lease()
+	assert.False(t, ok)
+	assert.Nil(t, c.Start())
+	assert.Equal(t, ErrStarted, c.Start())
+	srv.next(t, Discover)
+	request := srv.next(t, Request)
+	address, _ := request.Address(OptionRequestedAddress)
+	assert.Equal(t, ipv4.Address{10, 0, 0, 2}, address)
+
+	lease, err := c.Wait(5 * time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, ipv4.Address{10, 0, 0, 2}, lease.Address)
+	assert.Equal(t, netmask, lease.Netmask)
+	assert.Equal(t, ipv4.Address{10, 0, 0, 1}, *lease.Gateway)
+	assert.Equal(t, []ipv4.Address{{10, 0, 0, 53}}, lease.DNS)
+	assert.Equal(t, ipv4.Address{10, 0, 0, 1}, lease.Server)
+	assert.Equal(t, 2*time.Second, lease.Duration)
+	assert.Equal(t, time.Second, lease.Renewal)
+	assert.Equal(t, ipv4.Address{10, 0, 0, 2}, s.Address())
+
+	// The lease is renewed after a second. The server refuses the second
+	// renewal, and the client starts over.
+	request = srv.next(t, Request)
+	assert.Equal(t, ipv4.Address{10, 0, 0, 2}, request.ClientAddress)
+	srv.nak <- true
+	srv.next(t, Request)
+	srv.next(t, Discover)
+	srv.next(t, Request)
+	_, err = c.Wait(5 * time.Second)
+	assert.Nil(t, err)
+
+	// Stopping releases the lease.
+	assert.Nil(t, c.Stop())
+	assert.Equal(t, ErrStopped, c.Stop())
+	srv.next(t, Release)
+	_, ok = c.Lease()
+	assert.False(t, ok)
+	assert.Equal(t, ipv4.Address{}, s.Address())
+}
@@ -0,0 +1,267 @@
+package dhcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+const (
+	// ServerPort is the UDP port of DHCP servers.
+	ServerPort = 67
+
+	// ClientPort is the UDP port of DHCP clients.
+	ClientPort = 68
+)
+
+// Operation is the BOOTP operation of a message.
+type Operation uint8
+
+const (
+	// BootRequest is used for messages from clients.
+	BootRequest = 1
+	// BootReply is used for messages from servers.
+	BootReply = 2
+)
+
+// MessageType is the type of a DHCP message.
+type MessageType uint8
+
+const (
+	// Discover is broadcast by clients to locate servers.
+	Discover = 1
+	// Offer is sent by servers in response to Discover.
+	Offer = 2
+	// Request is sent by clients to request, renew or rebind a lease.
+	Request = 3
+	// Decline is sent by clients when the offered address is in use.
+	Decline = 4
+	// Ack is sent by servers to confirm a lease.
+	Ack = 5
+	// Nak is sent by servers to refuse a request.
+	Nak = 6
+	// Release is sent by clients to give up a lease.
+	Release = 7
+	// Inform is sent by clients that only need configuration parameters.
+	Inform = 8
+)
+
+// OptionCode is the code of a DHCP option.
+type OptionCode uint8
+
+const (
+	// OptionPad is used for alignment and has no length.
+	OptionPad = 0
+	// OptionSubnetMask contains the netmask of the network.
+	OptionSubnetMask = 1
+	// OptionRouter contains the addresses of the gateways.
+	OptionRouter = 3
+	// OptionDNS contains the addresses of the DNS servers.
+	OptionDNS = 6
+	// OptionHostName contains the name of the client.
+	OptionHostName = 12
+	// OptionRequestedAddress contains the address requested by a client.
+	OptionRequestedAddress = 50
+	// OptionLeaseTime contains the lease time in seconds.
+	OptionLeaseTime = 51
+	// OptionMessageType contains the type of the message.
+	OptionMessageType = 53
+	// OptionServerID contains the address of the server.
+	OptionServerID = 54
+	// OptionParameterRequestList contains the options requested by a
+	// client.
+	OptionParameterRequestList = 55
+	// OptionRenewalTime contains the time in seconds until the client
+	// renews the lease.
+	OptionRenewalTime = 58
+	// OptionRebindingTime contains the time in seconds until the client
+	// rebinds the lease.
+	OptionRebindingTime = 59
+	// OptionClientID contains the identifier of the client.
+	OptionClientID = 61
+	// OptionEnd marks the end of the options.
+	OptionEnd = 255
+)
+
+// FlagBroadcast asks servers to broadcast their replies.
+const FlagBroadcast = 0x8000
+
+// magicCookie precedes the options.
+var magicCookie = [4]byte{99, 130, 83, 99}
+
+var (
+	// ErrInvalidMessage is returned when reading a message without the
+	// DHCP magic cookie or with malformed options.
+	ErrInvalidMessage = errors.New("invalid DHCP message")
+)
+
+// Header is the fixed part of a DHCP message.
+type Header struct {
+	Operation      Operation
+	HardwareType   uint8
+	HardwareLength uint8
+	Hops           uint8
+	TransactionID  uint32
+	Seconds        uint16
+	Flags          uint16
+	ClientAddress  ipv4.Address
+	YourAddress    ipv4.Address
+	ServerAddress  ipv4.Address
+	GatewayAddress ipv4.Address
+	ClientHardware [16]byte
+	ServerName     [64]byte
+	BootFile       [128]byte
+	MagicCookie    [4]byte
+}
+
+// Option is a DHCP option.
+type Option struct {
+	Code OptionCode
+	Data []byte
+}
+
+// Message is a DHCP message.
+type Message struct {
+	Header
+	Options []Option
+}
+
+// NewClientMessage creates a message from a client.
+func NewClientMessage(t MessageType, xid uint32, mac ethernet.MAC) Message {
+	m := Message{
+		Header: Header{
+			Operation:      BootRequest,
+			HardwareType:   1,
+			HardwareLength: 6,
+			TransactionID:  xid,
+			MagicCookie:    magicCookie,
+		},
+	}
+	copy(m.ClientHardware[:], mac[:])
+	m.SetOption(OptionMessageType, []byte{byte(t)})
+	return m
+}
+
+// NewMessage reads a message from a reader.
+//
+// See also ErrInvalidMessage.
+func NewMessage(r io.Reader) (m Message, err error) {
+	if err = binary.Read(r, binary.BigEndian, &m.Header); err != nil {
+		return
+	}
+	if m.MagicCookie != magicCookie {
+		err = ErrInvalidMessage
+		return
+	}
+
+	options, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	for len(options) > 0 {
+		code := OptionCode(options[0])
+		if code == OptionEnd {
+			break
+		} else if code == OptionPad {
+			options = options[1:]
+			continue
+		}
+		if len(options) < 2 || len(options) < 2+int(options[1]) {
+			err = ErrInvalidMessage
+			return
+		}
+		data := options[2 : 2+options[1]]
+		m.Options = append(m.Options, Option{code, data})
+		options = options[2+len(data):]
+	}
+	return
+}
+
+// Write the message to a writer.
+func (m Message) Write(w io.Writer) error {
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.BigEndian, m.Header); err != nil {
+		return err
+	}
+	for _, o := range m.Options {
+		b.WriteByte(byte(o.Code))
+		b.WriteByte(byte(len(o.Data)))
+		b.Write(o.Data)
+	}
+	b.WriteByte(OptionEnd)
+
+	// Some servers drop messages smaller than a BOOTP message.
+	for b.Len() < 300 {
+		b.WriteByte(OptionPad)
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// Option returns the data of an option.
+func (m Message) Option(code OptionCode) ([]byte, bool) {
+	for _, o := range m.Options {
+		if o.Code == code {
+			return o.Data, true
+		}
+	}
+	return nil, false
+}
+
+// SetOption replaces or adds an option.
+func (m *Message) SetOption(code OptionCode, data []byte) {
+	for i, o := range m.Options {
+		if o.Code == code {
+			m.Options[i].Data = data
+			return
+		}
+	}
+	m.Options = append(m.Options, Option{code, data})
+}
+
+// Type returns the DHCP message type, or zero for BOOTP messages.
+func (m Message) Type() MessageType {
+	if data, ok := m.Option(OptionMessageType); ok && len(data) == 1 {
+		return MessageType(data[0])
+	}
+	return 0
+}
+
+// Address returns the first address of an option.
+func (m Message) Address(code OptionCode) (ipv4.Address, bool) {
+	addresses := m.Addresses(code)
+	if len(addresses) == 0 {
+		return ipv4.Address{}, false
+	}
+	return addresses[0], true
+}
+
+// Addresses returns the addresses of an option.
+func (m Message) Addresses(code OptionCode) []ipv4.Address {
+	data, _ := m.Option(code)
+	var addresses []ipv4.Address
+	for ; len(data) >= 4; data = data[4:] {
+		var a ipv4.Address
+		copy(a[:], data)
+		addresses = append(addresses, a)
+	}
+	return addresses
+}
+
+// Duration returns the number of seconds in an option.
+func (m Message) Duration(code OptionCode) (time.Duration, bool) {
+	data, ok := m.Option(code)
+	if !ok || len(data) != 4 {
+		return 0, false
+	}
+	return time.Duration(binary.BigEndian.Uint32(data)) * time.Second, true
+}
+
+// Infinity is the lease time of leases that do not expire.
+const Infinity = time.Duration(0xFFFFFFFF) * time.Second
@@ -0,0 +1,44 @@
+package dhcp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+func TestMessage(t *testing.T) {
+	m := NewClientMessage(Request, 0x12345678, ethernet.MAC{0x02, 0, 0, 0, 0, 1})
+	m.SetOption(OptionRequestedAddress, []byte{10, 0, 0, 2})
+	m.SetOption(OptionDNS, []byte{10, 0, 0, 53, 10, 0, 0, 54})
+	m.SetOption(OptionLeaseTime, []byte{0, 0, 0x0E, 0x10})
+	b := common.PacketToBytes(m)
+	assert.Equal(t, 300, len(b))
+	assert.Equal(t, []byte{99, 130, 83, 99}, b[236:240])
+
+	n, err := NewMessage(bytes.NewReader(b))
+	assert.Nil(t, err)
+	assert.Equal(t, m, n)
+	assert.Equal(t, MessageType(Request), n.Type())
+	address, ok := n.Address(OptionRequestedAddress)
+	assert.True(t, ok)
+	assert.Equal(t, ipv4.Address{10, 0, 0, 2}, address)
+	assert.Equal(t, []ipv4.Address{{10, 0, 0, 53}, {10, 0, 0, 54}}, n.Addresses(OptionDNS))
+	d, ok := n.Duration(OptionLeaseTime)
+	assert.True(t, ok)
+	assert.Equal(t, time.Hour, d)
+	_, ok = n.Option(OptionRouter)
+	assert.False(t, ok)
+
+	// Malformed options
+	b[241] = 200
+	_, err = NewMessage(bytes.NewReader(b))
+	assert.Equal(t, ErrInvalidMessage, err)
+	b[236] = 0
+	_, err = NewMessage(bytes.NewReader(b))
+	assert.Equal(t, ErrInvalidMessage, err)
+}
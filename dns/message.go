@@ -0,0 +1,504 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+// Port is the UDP port of DNS servers.
+const Port = 53
+
+// Type is the type of a resource record.
+type Type uint16
+
+const (
+	// TypeA is used for IPv4 addresses.
+	TypeA = 1
+	// TypeNS is used for name servers.
+	TypeNS = 2
+	// TypeCNAME is used for canonical names.
+	TypeCNAME = 5
+	// TypeSOA is used for the start of a zone of authority.
+	TypeSOA = 6
+	// TypePTR is used for domain name pointers.
+	TypePTR = 12
+	// TypeMX is used for mail exchanges.
+	TypeMX = 15
+	// TypeTXT is used for text strings.
+	TypeTXT = 16
+	// TypeAAAA is used for IPv6 addresses.
+	TypeAAAA = 28
+	// TypeSRV is used for service locations.
+	TypeSRV = 33
+)
+
+// Class is the class of a resource record.
+type Class uint16
+
+const (
+	// ClassINET is the Internet class.
+	ClassINET = 1
+)
+
+// RCode is the response code of a message.
+type RCode uint8
+
+const (
+	// RCodeSuccess is used when there was no error.
+	RCodeSuccess = 0
+	// RCodeFormatError is used when the server could not interpret the
+	// query.
+	RCodeFormatError = 1
+	// RCodeServerFailure is used when the server could not process the
+	// query.
+	RCodeServerFailure = 2
+	// RCodeNameError is used when the name does not exist.
+	RCodeNameError = 3
+	// RCodeNotImplemented is used when the server does not support the
+	// query.
+	RCodeNotImplemented = 4
+	// RCodeRefused is used when the server refuses the query.
+	RCodeRefused = 5
+)
+
+const (
+	// FlagResponse is set on responses.
+	FlagResponse = 0x8000
+	// FlagAuthoritative is set on responses from an authoritative server.
+	FlagAuthoritative = 0x0400
+	// FlagTruncated is set on responses that did not fit in the datagram.
+	FlagTruncated = 0x0200
+	// FlagRecursionDesired asks the server to resolve the query
+	// recursively.
+	FlagRecursionDesired = 0x0100
+	// FlagRecursionAvailable is set by servers that support recursion.
+	FlagRecursionAvailable = 0x0080
+)
+
+var (
+	// ErrInvalidMessage is returned when reading a malformed message.
+	ErrInvalidMessage = errors.New("invalid DNS message")
+
+	// ErrInvalidName is returned when writing a name with labels longer
+	// than 63 bytes, or that is longer than 255 bytes.
+	ErrInvalidName = errors.New("invalid domain name")
+)
+
+// Header is the header of a DNS message.
+type Header struct {
+	ID              uint16
+	Flags           uint16
+	QuestionCount   uint16
+	AnswerCount     uint16
+	AuthorityCount  uint16
+	AdditionalCount uint16
+}
+
+// RCode returns the response code of the message.
+func (h Header) RCode() RCode {
+	return RCode(h.Flags & 0x000F)
+}
+
+// Question is a question of a message.
+type Question struct {
+	Name  string
+	Type  Type
+	Class Class
+}
+
+// Resource is a resource record.
+type Resource struct {
+	Name  string
+	Type  Type
+	Class Class
+
+	// TTL is the number of seconds the record may be cached.
+	TTL  uint32
+	Data Data
+}
+
+// Data is the data of a resource record.
+type Data interface {
+	Write(w io.Writer) error
+}
+
+// Message is a DNS message.
+//
+// The counts in the header are ignored when writing a message.
+type Message struct {
+	Header
+	Questions   []Question
+	Answers     []Resource
+	Authorities []Resource
+	Additionals []Resource
+}
+
+// NewQuery creates a recursive query for a name.
+func NewQuery(id uint16, name string, t Type) Message {
+	return Message{
+		Header:    Header{ID: id, Flags: FlagRecursionDesired},
+		Questions: []Question{{Name: name, Type: t, Class: ClassINET}},
+	}
+}
+
+// NewMessage reads a message from a reader.
+//
+// See also ErrInvalidMessage.
+func NewMessage(r io.Reader) (m Message, err error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	p := &parser{msg: b}
+
+	if err = binary.Read(bytes.NewReader(b), binary.BigEndian, &m.Header); err != nil {
+		return m, ErrInvalidMessage
+	}
+	p.offset = 12
+
+	for i := 0; i < int(m.QuestionCount); i++ {
+		var q Question
+		q.Name = p.name()
+		q.Type = Type(p.uint16())
+		q.Class = Class(p.uint16())
+		m.Questions = append(m.Questions, q)
+	}
+	sections := []struct {
+		count     uint16
+		resources *[]Resource
+	}{
+		{m.AnswerCount, &m.Answers},
+		{m.AuthorityCount, &m.Authorities},
+		{m.AdditionalCount, &m.Additionals},
+	}
+	for _, s := range sections {
+		for i := 0; i < int(s.count); i++ {
+			*s.resources = append(*s.resources, p.resource())
+		}
+	}
+
+	if p.err != nil {
+		return m, p.err
+	}
+	return
+}
+
+// Write the message to a writer.
+func (m Message) Write(w io.Writer) error {
+	h := m.Header
+	h.QuestionCount = uint16(len(m.Questions))
+	h.AnswerCount = uint16(len(m.Answers))
+	h.AuthorityCount = uint16(len(m.Authorities))
+	h.AdditionalCount = uint16(len(m.Additionals))
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, h)
+	for _, q := range m.Questions {
+		if err := writeName(&b, q.Name); err != nil {
+			return err
+		}
+		binary.Write(&b, binary.BigEndian, []uint16{uint16(q.Type), uint16(q.Class)})
+	}
+	for _, section := range [][]Resource{m.Answers, m.Authorities, m.Additionals} {
+		for _, r := range section {
+			if err := r.write(&b); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func (r Resource) write(b *bytes.Buffer) error {
+	if err := writeName(b, r.Name); err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	if r.Data != nil {
+		if err := r.Data.Write(&data); err != nil {
+			return err
+		}
+	}
+	binary.Write(b, binary.BigEndian, []uint16{uint16(r.Type), uint16(r.Class)})
+	binary.Write(b, binary.BigEndian, r.TTL)
+	binary.Write(b, binary.BigEndian, uint16(data.Len()))
+	b.Write(data.Bytes())
+	return nil
+}
+
+// CanonicalName returns a name in lower case with a trailing dot.
+func CanonicalName(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+func writeName(w io.Writer, name string) error {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) > 253 {
+		return ErrInvalidName
+	}
+	var b []byte
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return ErrInvalidName
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	b = append(b, 0)
+	_, err := w.Write(b)
+	return err
+}
+
+// parser reads the parts of a message. The first error is kept, and the
+// results after an error are zero values.
+type parser struct {
+	msg    []byte
+	offset int
+	err    error
+}
+
+func (p *parser) bytes(n int) []byte {
+	if p.err != nil || n < 0 || p.offset+n > len(p.msg) {
+		p.err = ErrInvalidMessage
+		return make([]byte, n&0xFFFF)
+	}
+	b := p.msg[p.offset : p.offset+n]
+	p.offset += n
+	return b
+}
+
+func (p *parser) uint16() uint16 {
+	return binary.BigEndian.Uint16(p.bytes(2))
+}
+
+func (p *parser) uint32() uint32 {
+	return binary.BigEndian.Uint32(p.bytes(4))
+}
+
+// name reads a name, which may be compressed, with a trailing dot.
+func (p *parser) name() string {
+	var labels []string
+	offset := p.offset
+	jumped := false
+	for jumps := 0; p.err == nil; {
+		if offset >= len(p.msg) {
+			p.err = ErrInvalidMessage
+			break
+		}
+
+		length := int(p.msg[offset])
+		switch {
+		case length == 0:
+			if !jumped {
+				p.offset = offset + 1
+			}
+			return strings.Join(labels, ".") + "."
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(p.msg) || jumps > 64 {
+				p.err = ErrInvalidMessage
+				break
+			}
+			if !jumped {
+				p.offset = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(p.msg[offset:]) & 0x3FFF)
+			jumped = true
+			jumps++
+		case length&0xC0 != 0 || offset+1+length > len(p.msg):
+			p.err = ErrInvalidMessage
+		default:
+			labels = append(labels, string(p.msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+	return ""
+}
+
+func (p *parser) resource() Resource {
+	var r Resource
+	r.Name = p.name()
+	r.Type = Type(p.uint16())
+	r.Class = Class(p.uint16())
+	r.TTL = p.uint32()
+	length := int(p.uint16())
+	if p.err != nil {
+		return r
+	}
+
+	end := p.offset + length
+	if end > len(p.msg) {
+		p.err = ErrInvalidMessage
+		return r
+	}
+	switch r.Type {
+	case TypeA:
+		var d A
+		copy(d.Address[:], p.bytes(4))
+		r.Data = d
+	case TypeAAAA:
+		var d AAAA
+		copy(d.Address[:], p.bytes(16))
+		r.Data = d
+	case TypeCNAME:
+		r.Data = CNAME{Target: p.name()}
+	case TypeNS:
+		r.Data = NS{Host: p.name()}
+	case TypePTR:
+		r.Data = PTR{Target: p.name()}
+	case TypeMX:
+		var d MX
+		d.Preference = p.uint16()
+		d.Exchange = p.name()
+		r.Data = d
+	case TypeTXT:
+		var d TXT
+		for p.err == nil && p.offset < end {
+			n := int(p.bytes(1)[0])
+			d.Strings = append(d.Strings, string(p.bytes(n)))
+		}
+		r.Data = d
+	case TypeSRV:
+		var d SRV
+		d.Priority = p.uint16()
+		d.Weight = p.uint16()
+		d.Port = p.uint16()
+		d.Target = p.name()
+		r.Data = d
+	default:
+		r.Data = Raw{Data: append([]byte(nil), p.bytes(length)...)}
+	}
+	if p.err == nil && p.offset != end {
+		p.err = ErrInvalidMessage
+	}
+	return r
+}
+
+// A is the data of an IPv4 address record.
+type A struct {
+	Address ipv4.Address
+}
+
+// Write the data to a writer.
+func (d A) Write(w io.Writer) error {
+	_, err := w.Write(d.Address[:])
+	return err
+}
+
+// AAAA is the data of an IPv6 address record.
+type AAAA struct {
+	Address [16]byte
+}
+
+// Write the data to a writer.
+func (d AAAA) Write(w io.Writer) error {
+	_, err := w.Write(d.Address[:])
+	return err
+}
+
+// CNAME is the data of a canonical name record.
+type CNAME struct {
+	Target string
+}
+
+// Write the data to a writer.
+func (d CNAME) Write(w io.Writer) error {
+	return writeName(w, d.Target)
+}
+
+// NS is the data of a name server record.
+type NS struct {
+	Host string
+}
+
+// Write the data to a writer.
+func (d NS) Write(w io.Writer) error {
+	return writeName(w, d.Host)
+}
+
+// PTR is the data of a domain name pointer record.
+type PTR struct {
+	Target string
+}
+
+// Write the data to a writer.
+func (d PTR) Write(w io.Writer) error {
+	return writeName(w, d.Target)
+}
+
+// MX is the data of a mail exchange record.
+type MX struct {
+	Preference uint16
+	Exchange   string
+}
+
+// Write the data to a writer.
+func (d MX) Write(w io.Writer) error {
+	if err := binary.Write(w, binary.BigEndian, d.Preference); err != nil {
+		return err
+	}
+	return writeName(w, d.Exchange)
+}
+
+// TXT is the data of a text record.
+type TXT struct {
+	Strings []string
+}
+
+// Write the data to a writer. Strings longer than 255 bytes are split.
+func (d TXT) Write(w io.Writer) error {
+	for _, s := range d.Strings {
+		for {
+			n := len(s)
+			if n > 255 {
+				n = 255
+			}
+			if _, err := w.Write(append([]byte{byte(n)}, s[:n]...)); err != nil {
+				return err
+			}
+			if s = s[n:]; len(s) == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// SRV is the data of a service location record.
+type SRV struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+// Write the data to a writer.
+func (d SRV) Write(w io.Writer) error {
+	if err := binary.Write(w, binary.BigEndian, []uint16{d.Priority, d.Weight, d.Port}); err != nil {
+		return err
+	}
+	return writeName(w, d.Target)
+}
+
+// Raw is the data of a record with an unsupported type.
+type Raw struct {
+	Data []byte
+}
+
+// Write the data to a writer.
+func (d Raw) Write(w io.Writer) error {
+	_, err := w.Write(d.Data)
+	return err
+}
@@ -0,0 +1,67 @@
+package dns
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+func TestMessage(t *testing.T) {
+	m := NewQuery(0x1234, "example.com.", TypeA)
+	m.Flags |= FlagResponse | FlagRecursionAvailable
+	m.Answers = []Resource{
+		{"example.com.", TypeCNAME, ClassINET, 60, CNAME{"www.example.com."}},
+		{"www.example.com.", TypeA, ClassINET, 300, A{ipv4.Address{10, 0, 0, 1}}},
+		{"www.example.com.", TypeAAAA, ClassINET, 300, AAAA{[16]byte{0x20, 0x01, 15: 1}}},
+	}
+	m.Authorities = []Resource{
+		{"example.com.", TypeNS, ClassINET, 3600, NS{"ns.example.com."}},
+		{"example.com.", TypeSOA, ClassINET, 3600, Raw{[]byte{1, 2, 3}}},
+	}
+	m.Additionals = []Resource{
+		{"example.com.", TypeMX, ClassINET, 60, MX{10, "mail.example.com."}},
+		{"example.com.", TypeTXT, ClassINET, 60, TXT{[]string{"v=spf1", ""}}},
+		{"_sip._udp.example.com.", TypeSRV, ClassINET, 60, SRV{1, 2, 5060, "sip.example.com."}},
+		{"1.0.0.10.in-addr.arpa.", TypePTR, ClassINET, 60, PTR{"www.example.com."}},
+	}
+
+	n, err := NewMessage(bytes.NewReader(common.PacketToBytes(m)))
+	assert.Nil(t, err)
+	assert.Equal(t, Header{0x1234, m.Flags, 1, 3, 2, 4}, n.Header)
+	assert.Equal(t, RCode(RCodeSuccess), n.RCode())
+	assert.Equal(t, m.Questions, n.Questions)
+	assert.Equal(t, m.Answers, n.Answers)
+	assert.Equal(t, m.Authorities, n.Authorities)
+	assert.Equal(t, m.Additionals, n.Additionals)
+
+	// Names longer than 63 bytes per label are rejected.
+	m = NewQuery(1, string(make([]byte, 64)), TypeA)
+	assert.Equal(t, ErrInvalidName, m.Write(&bytes.Buffer{}))
+}
+
+func TestMessageCompression(t *testing.T) {
+	b := []byte{
+		0x00, 0x01, 0x81, 0x83, 0, 1, 0, 1, 0, 0, 0, 0,
+		// Question: a.example.
+		1, 'a', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0, 0, 1, 0, 1,
+		// Answer: pointer to the question, CNAME b.<pointer to example>
+		0xC0, 12, 0, 5, 0, 1, 0, 0, 0, 10, 0, 4, 1, 'b', 0xC0, 14,
+	}
+	m, err := NewMessage(bytes.NewReader(b))
+	assert.Nil(t, err)
+	assert.Equal(t, RCode(RCodeNameError), m.RCode())
+	assert.Equal(t, []Question{{"a.example.", TypeA, ClassINET}}, m.Questions)
+	assert.Equal(t, []Resource{{"a.example.", TypeCNAME, ClassINET, 10, CNAME{"b.example."}}}, m.Answers)
+
+	// Pointer loop
+	b[len(b)-1] = byte(len(b) - 2)
+	_, err = NewMessage(bytes.NewReader(b))
+	assert.Equal(t, ErrInvalidMessage, err)
+
+	// Truncated message
+	_, err = NewMessage(bytes.NewReader(b[:20]))
+	assert.Equal(t, ErrInvalidMessage, err)
+}
@@ -0,0 +1,342 @@
+package dns
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/udp"
+)
+
+var (
+	// ErrNoServers is returned when no DNS servers are configured.
+	ErrNoServers = errors.New("no DNS servers")
+
+	// ErrTimeout is returned when no server answered a query.
+	ErrTimeout = errors.New("DNS query timed out")
+
+	// ErrNotFound is returned when the name does not exist.
+	ErrNotFound = errors.New("no such host")
+
+	// ErrServerFailure is returned when the server could not answer the
+	// query.
+	ErrServerFailure = errors.New("DNS server failure")
+
+	// ErrTruncated is returned with the records of a response that did not
+	// fit in a datagram. The records may be incomplete.
+	ErrTruncated = errors.New("DNS response truncated")
+)
+
+const (
+	// DefaultTimeout is the time to wait for a response.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultAttempts is the number of times a query is sent to each
+	// server.
+	DefaultAttempts = 2
+
+	// maxCNAMEs is the maximum length of a chain of canonical names.
+	maxCNAMEs = 8
+)
+
+// Resolver is a stub resolver that sends recursive queries to DNS servers.
+//
+// Answers are cached for the time to live of the records.
+type Resolver interface {
+	// Query returns the answers to a query, including the canonical
+	// names of the name.
+	//
+	// See also ErrNotFound, ErrServerFailure, ErrTimeout and
+	// ErrTruncated.
+	Query(name string, t Type) ([]Resource, error)
+
+	// LookupHost returns the IPv4 addresses of a host.
+	LookupHost(host string) ([]ipv4.Address, error)
+
+	// LookupCNAME returns the canonical name of a host.
+	LookupCNAME(host string) (string, error)
+
+	// LookupAddr returns the names of an IPv4 address.
+	LookupAddr(address ipv4.Address) ([]string, error)
+
+	// LookupMX returns the mail exchanges of a domain, sorted by
+	// preference.
+	LookupMX(name string) ([]MX, error)
+
+	// LookupTXT returns the text records of a name.
+	LookupTXT(name string) ([]string, error)
+
+	// LookupSRV returns the records of a service, sorted by priority.
+	// The name that is looked up is _service._proto.name.
+	LookupSRV(service, proto, name string) ([]SRV, error)
+
+	// SetServers changes the DNS servers.
+	SetServers(servers []ipv4.Address)
+}
+
+type cacheKey struct {
+	name string
+	t    Type
+}
+
+type cacheEntry struct {
+	answers []Resource
+	expires time.Time
+}
+
+type resolver struct {
+	udp      udp.Layer
+	timeout  time.Duration
+	attempts int
+
+	lock    sync.Mutex
+	servers []ipv4.Address
+	cache   map[cacheKey]cacheEntry
+}
+
+// NewResolver creates a resolver with the default configuration.
+func NewResolver(u udp.Layer, servers []ipv4.Address) Resolver {
+	return NewCustomResolver(u, servers, DefaultTimeout, DefaultAttempts)
+}
+
+// NewCustomResolver creates a resolver that waits timeout for a response,
+// and sends a query at most attempts times to each server.
+func NewCustomResolver(u udp.Layer, servers []ipv4.Address, timeout time.Duration, attempts int) Resolver {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &resolver{
+		udp:      u,
+		timeout:  timeout,
+		attempts: attempts,
+		servers:  servers,
+		cache:    make(map[cacheKey]cacheEntry),
+	}
+}
+
+func (r *resolver) SetServers(servers []ipv4.Address) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.servers = servers
+}
+
+func (r *resolver) Query(name string, t Type) ([]Resource, error) {
+	name = CanonicalName(name)
+	key := cacheKey{name, t}
+
+	r.lock.Lock()
+	entry, ok := r.cache[key]
+	servers := r.servers
+	r.lock.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.answers, nil
+	}
+
+	response, err := r.exchange(servers, name, t)
+	if err != nil {
+		return nil, err
+	}
+	switch response.RCode() {
+	case RCodeSuccess:
+	case RCodeNameError:
+		return nil, ErrNotFound
+	default:
+		return nil, ErrServerFailure
+	}
+
+	answers := relevant(response.Answers, name, t)
+	if response.Flags&FlagTruncated != 0 {
+		return answers, ErrTruncated
+	}
+	if len(answers) > 0 {
+		ttl := answers[0].TTL
+		for _, a := range answers {
+			if a.TTL < ttl {
+				ttl = a.TTL
+			}
+		}
+		r.lock.Lock()
+		r.cache[key] = cacheEntry{answers, time.Now().Add(time.Duration(ttl) * time.Second)}
+		r.lock.Unlock()
+	}
+	return answers, nil
+}
+
+// relevant returns the answers for a name and its canonical names.
+func relevant(answers []Resource, name string, t Type) []Resource {
+	var result []Resource
+	for i := 0; i < maxCNAMEs; i++ {
+		next := ""
+		for _, a := range answers {
+			if CanonicalName(a.Name) != name {
+				continue
+			}
+			if a.Type == t {
+				result = append(result, a)
+			} else if cname, ok := a.Data.(CNAME); ok {
+				result = append(result, a)
+				next = CanonicalName(cname.Target)
+			}
+		}
+		if next == "" || t == TypeCNAME {
+			break
+		}
+		name = next
+	}
+	return result
+}
+
+// exchange sends a query to the servers until one of them responds. Servers
+// that cannot be reached or that fail are skipped.
+func (r *resolver) exchange(servers []ipv4.Address, name string, t Type) (Message, error) {
+	if len(servers) == 0 {
+		return Message{}, ErrNoServers
+	}
+
+	var failure *Message
+	for i := 0; i < r.attempts; i++ {
+		for _, server := range servers {
+			response, err := r.send(server, NewQuery(uint16(rand.Intn(0x10000)), name, t))
+			if err != nil {
+				continue
+			} else if rcode := response.RCode(); rcode == RCodeServerFailure || rcode == RCodeRefused {
+				failure = &response
+				continue
+			}
+			return response, nil
+		}
+	}
+	if failure != nil {
+		return *failure, nil
+	}
+	return Message{}, ErrTimeout
+}
+
+// send sends a query from a random port and waits for the response.
+func (r *resolver) send(server ipv4.Address, query Message) (Message, error) {
+	var buf bytes.Buffer
+	if err := query.Write(&buf); err != nil {
+		return Message{}, err
+	}
+
+	s, err := r.udp.Bind(ipv4.Address{}, 0)
+	if err != nil {
+		return Message{}, err
+	}
+	defer s.Close()
+	if err := s.Send(server, Port, buf.Bytes()); err != nil {
+		return Message{}, err
+	}
+
+	timeout := time.After(r.timeout)
+	for {
+		select {
+		case p := <-s.Packets():
+			if !p.Address.Equals(server) || p.SourcePort != Port {
+				continue
+			}
+			m, err := NewMessage(bytes.NewReader(p.Payload))
+			if err != nil || m.ID != query.ID || m.Flags&FlagResponse == 0 || !sameQuestion(m, query) {
+				continue
+			}
+			return m, nil
+		case <-s.Errors():
+			// The server is unreachable.
+			return Message{}, ErrTimeout
+		case <-timeout:
+			return Message{}, ErrTimeout
+		}
+	}
+}
+
+func sameQuestion(m, query Message) bool {
+	if len(m.Questions) != 1 {
+		return false
+	}
+	q := m.Questions[0]
+	return CanonicalName(q.Name) == CanonicalName(query.Questions[0].Name) && q.Type == query.Questions[0].Type
+}
+
+func (r *resolver) LookupHost(host string) ([]ipv4.Address, error) {
+	if a, ok := ipv4.NewAddress(host); ok {
+		return []ipv4.Address{a}, nil
+	}
+	answers, err := r.Query(host, TypeA)
+	var addresses []ipv4.Address
+	for _, a := range answers {
+		if d, ok := a.Data.(A); ok {
+			addresses = append(addresses, d.Address)
+		}
+	}
+	if err == nil && len(addresses) == 0 {
+		err = ErrNotFound
+	}
+	return addresses, err
+}
+
+func (r *resolver) LookupCNAME(host string) (string, error) {
+	answers, err := r.Query(host, TypeA)
+	if err != nil && err != ErrTruncated {
+		return "", err
+	}
+	name := CanonicalName(host)
+	for _, a := range answers {
+		if d, ok := a.Data.(CNAME); ok {
+			name = CanonicalName(d.Target)
+		}
+	}
+	return name, nil
+}
+
+func (r *resolver) LookupAddr(address ipv4.Address) ([]string, error) {
+	name := fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", address[3], address[2], address[1], address[0])
+	answers, err := r.Query(name, TypePTR)
+	var names []string
+	for _, a := range answers {
+		if d, ok := a.Data.(PTR); ok {
+			names = append(names, d.Target)
+		}
+	}
+	return names, err
+}
+
+func (r *resolver) LookupMX(name string) ([]MX, error) {
+	answers, err := r.Query(name, TypeMX)
+	var mx []MX
+	for _, a := range answers {
+		if d, ok := a.Data.(MX); ok {
+			mx = append(mx, d)
+		}
+	}
+	sort.SliceStable(mx, func(i, j int) bool { return mx[i].Preference < mx[j].Preference })
+	return mx, err
+}
+
+func (r *resolver) LookupTXT(name string) ([]string, error) {
+	answers, err := r.Query(name, TypeTXT)
+	var txt []string
+	for _, a := range answers {
+		if d, ok := a.Data.(TXT); ok {
+			txt = append(txt, strings.Join(d.Strings, ""))
+		}
+	}
+	return txt, err
+}
+
+func (r *resolver) LookupSRV(service, proto, name string) ([]SRV, error) {
+	answers, err := r.Query(fmt.Sprintf("_%v._%v.%v", service, proto, name), TypeSRV)
+	var srv []SRV
+	for _, a := range answers {
+		if d, ok := a.Data.(SRV); ok {
+			srv = append(srv, d)
+		}
+	}
+	sort.SliceStable(srv, func(i, j int) bool { return srv[i].Priority < srv[j].Priority })
+	return srv, err
+}
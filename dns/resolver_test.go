@@ -0,0 +1,148 @@
+package dns
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/stack"
+	"github.com/unigornel/go-tcpip/virtual"
+)
+
+// server answers queries for example.com. It drops the first query for
+// slow.example, and truncates responses for big.example.
+func newServer(t *testing.T, s *stack.Stack) chan Message {
+	socket, err := s.UDP.Bind(ipv4.Address{}, Port)
+	assert.Nil(t, err)
+	queries := make(chan Message, 16)
+	dropped := false
+
+	go func() {
+		for p := range socket.Packets() {
+			m, err := NewMessage(bytes.NewReader(p.Payload))
+			if err != nil {
+				continue
+			}
+			queries <- m
+
+			reply := m
+			reply.Flags |= FlagResponse | FlagRecursionAvailable
+			q := m.Questions[0]
+			switch CanonicalName(q.Name) {
+			case "example.com.":
+				reply.Answers = []Resource{
+					{"example.com.", TypeA, ClassINET, 1, A{ipv4.Address{10, 0, 0, 80}}},
+					{"example.com.", TypeA, ClassINET, 60, A{ipv4.Address{10, 0, 0, 81}}},
+				}
+			case "www.example.com.":
+				reply.Answers = []Resource{
+					{"www.example.com.", TypeCNAME, ClassINET, 60, CNAME{"Example.COM."}},
+					{"example.com.", TypeA, ClassINET, 60, A{ipv4.Address{10, 0, 0, 80}}},
+					{"other.example.", TypeA, ClassINET, 60, A{ipv4.Address{10, 0, 0, 99}}},
+				}
+			case "slow.example.":
+				if !dropped {
+					dropped = true
+					continue
+				}
+				reply.Answers = []Resource{{q.Name, TypeA, ClassINET, 60, A{ipv4.Address{10, 0, 0, 1}}}}
+			case "big.example.":
+				reply.Flags |= FlagTruncated
+				reply.Answers = []Resource{{q.Name, TypeA, ClassINET, 60, A{ipv4.Address{10, 0, 0, 2}}}}
+			case "_sip._udp.example.com.":
+				reply.Answers = []Resource{
+					{q.Name, TypeSRV, ClassINET, 60, SRV{20, 0, 5060, "b.example.com."}},
+					{q.Name, TypeSRV, ClassINET, 60, SRV{10, 0, 5060, "a.example.com."}},
+				}
+			case "80.0.0.10.in-addr.arpa.":
+				reply.Answers = []Resource{{q.Name, TypePTR, ClassINET, 60, PTR{"example.com."}}}
+			default:
+				reply.Flags |= RCodeNameError
+			}
+			socket.Send(p.Address, p.SourcePort, common.PacketToBytes(reply))
+		}
+	}()
+	return queries
+}
+
+func TestResolver(t *testing.T) {
+	a, b := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	netmask := ipv4.Address{255, 255, 255, 0}
+	queries := newServer(t, stack.New(stack.Config{NIC: a, Address: ipv4.Address{10, 0, 0, 53}, Netmask: netmask}))
+	s := stack.New(stack.Config{NIC: b, Address: ipv4.Address{10, 0, 0, 2}, Netmask: netmask})
+	r := NewCustomResolver(s.UDP, []ipv4.Address{{10, 0, 0, 53}}, 500*time.Millisecond, 2)
+
+	received := func() int {
+		n := len(queries)
+		for i := 0; i < n; i++ {
+			<-queries
+		}
+		return n
+	}
+
+	addresses, err := r.LookupHost("example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, []ipv4.Address{{10, 0, 0, 80}, {10, 0, 0, 81}}, addresses)
+	assert.Equal(t, 1, received())
+
+	// Cached until the smallest TTL expires.
+	_, err = r.LookupHost("EXAMPLE.com.")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, received())
+	time.Sleep(1100 * time.Millisecond)
+	_, err = r.LookupHost("example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, received())
+
+	// Canonical names are followed, and unrelated records are ignored.
+	addresses, err = r.LookupHost("www.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, []ipv4.Address{{10, 0, 0, 80}}, addresses)
+	name, err := r.LookupCNAME("www.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com.", name)
+
+	// Literals are not looked up.
+	received()
+	addresses, err = r.LookupHost("192.168.1.1")
+	assert.Nil(t, err)
+	assert.Equal(t, []ipv4.Address{{192, 168, 1, 1}}, addresses)
+	assert.Equal(t, 0, received())
+
+	_, err = r.LookupHost("none.example")
+	assert.Equal(t, ErrNotFound, err)
+
+	// Lost queries are retried.
+	addresses, err = r.LookupHost("slow.example")
+	assert.Nil(t, err)
+	assert.Equal(t, []ipv4.Address{{10, 0, 0, 1}}, addresses)
+
+	// Truncated responses are returned, but not cached.
+	received()
+	for i := 0; i < 2; i++ {
+		addresses, err = r.LookupHost("big.example")
+		assert.Equal(t, ErrTruncated, err)
+		assert.Equal(t, []ipv4.Address{{10, 0, 0, 2}}, addresses)
+	}
+	assert.Equal(t, 2, received())
+
+	srv, err := r.LookupSRV("sip", "udp", "example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, []SRV{{10, 0, 5060, "a.example.com."}, {20, 0, 5060, "b.example.com."}}, srv)
+
+	names, err := r.LookupAddr(ipv4.Address{10, 0, 0, 80})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"example.com."}, names)
+
+	// Unreachable servers time out.
+	r.SetServers([]ipv4.Address{{10, 0, 0, 54}})
+	_, err = r.LookupHost("other.example")
+	assert.Equal(t, ErrTimeout, err)
+	r.SetServers(nil)
+	_, err = r.LookupHost("other.example")
+	assert.Equal(t, ErrNoServers, err)
+}
@@ -1,29 +1,57 @@
 package ethernet
 
+import "sync"
+
 // Layer is the ethernet receive layer
 type Layer interface {
 	Packets(t EtherType) <-chan Packet
 	Send(t Packet) error
 }
 
-// NewLayer will receive packets from a NIC.
+// MulticastLayer is an ethernet layer that filters the frames to multicast
+// addresses. Layers that do not implement it receive all frames.
+type MulticastLayer interface {
+	Layer
+
+	// JoinMulticast makes the layer receive the frames to a multicast
+	// address. Frames to multicast addresses that were not joined are
+	// dropped. An address that is joined several times is left after as
+	// many calls to LeaveMulticast.
+	JoinMulticast(mac MAC)
+
+	// LeaveMulticast stops receiving the frames to a multicast address.
+	LeaveMulticast(mac MAC)
+}
+
+// NewLayer will receive packets from a NIC. The layer is a MulticastLayer.
+//
+// The channels of the layer are closed when the NIC stops receiving.
 func NewLayer(nic NIC) Layer {
 	l := &layer{
-		mac:      nic.GetMAC(),
-		nic:      nic,
-		channels: make(map[EtherType]chan Packet),
+		mac:       nic.GetMAC(),
+		nic:       nic,
+		channels:  make(map[EtherType]chan Packet),
+		multicast: make(map[MAC]int),
 	}
 	go l.run()
 	return l
 }
 
 type layer struct {
-	nic      NIC
-	mac      MAC
-	channels map[EtherType]chan Packet
+	nic NIC
+	mac MAC
+
+	channelsLock sync.RWMutex
+	channels     map[EtherType]chan Packet
+
+	multicastLock sync.RWMutex
+	multicast     map[MAC]int
 }
 
 func (layer *layer) Packets(t EtherType) <-chan Packet {
+	layer.channelsLock.Lock()
+	defer layer.channelsLock.Unlock()
+
 	c, ok := layer.channels[t]
 	if !ok {
 		c = make(chan Packet)
@@ -38,12 +66,48 @@ func (layer *layer) Send(packet Packet) error {
 	return nil
 }
 
+func (layer *layer) JoinMulticast(mac MAC) {
+	layer.multicastLock.Lock()
+	defer layer.multicastLock.Unlock()
+	layer.multicast[mac]++
+}
+
+func (layer *layer) LeaveMulticast(mac MAC) {
+	layer.multicastLock.Lock()
+	defer layer.multicastLock.Unlock()
+	if layer.multicast[mac]--; layer.multicast[mac] <= 0 {
+		delete(layer.multicast, mac)
+	}
+}
+
+// accepts determines whether a frame to a destination is received.
+func (layer *layer) accepts(destination MAC) bool {
+	if destination[0]&1 == 0 || destination == Broadcast {
+		return true
+	}
+	layer.multicastLock.RLock()
+	defer layer.multicastLock.RUnlock()
+	return layer.multicast[destination] > 0
+}
+
 func (layer *layer) run() {
 	for p := range layer.nic.Receive() {
+		if !layer.accepts(p.Destination) {
+			continue
+		}
+		layer.channelsLock.RLock()
 		c := layer.channels[p.EtherType]
+		layer.channelsLock.RUnlock()
 
 		if c != nil {
 			c <- p
 		}
 	}
+
+	// The NIC stopped receiving.
+	layer.channelsLock.Lock()
+	defer layer.channelsLock.Unlock()
+	for _, c := range layer.channels {
+		close(c)
+	}
 }
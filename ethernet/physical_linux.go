@@ -1,6 +1,191 @@
 package ethernet
 
-// NewNIC should create a linux based NIC
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// DefaultTAPName is the name of the TAP device used by NewNIC.
+const DefaultTAPName = "tap0"
+
+var (
+	// ErrInvalidInterfaceName is returned when an interface name is empty or
+	// too long.
+	ErrInvalidInterfaceName = errors.New("invalid interface name")
+)
+
+// linuxDevice is a Linux file descriptor that reads and writes whole
+// Ethernet frames.
+type linuxDevice interface {
+	ReadFrame(b []byte) (int, error)
+	WriteFrame(b []byte) error
+	Close() error
+}
+
+type linuxNIC struct {
+	dev  linuxDevice
+	mac  MAC
+	tx   chan Packet
+	rx   chan Packet
+	done chan struct{}
+}
+
+func newLinuxNIC(dev linuxDevice, mac MAC) *linuxNIC {
+	return &linuxNIC{
+		dev:  dev,
+		mac:  mac,
+		tx:   make(chan Packet),
+		rx:   make(chan Packet),
+		done: make(chan struct{}),
+	}
+}
+
+// NewNIC creates a new NIC linked to the TAP device DefaultTAPName.
+//
+// See NewTAPNIC for details. NewNIC panics if the device cannot be opened.
 func NewNIC() NIC {
-	panic("not implemented")
+	nic, err := NewTAPNIC(DefaultTAPName)
+	if err != nil {
+		panic(err)
+	}
+	return nic
+}
+
+// NewTAPNIC creates a new NIC linked to a Linux TAP device.
+//
+// The device is created if it does not exist yet, which requires the
+// CAP_NET_ADMIN capability. Bringing the interface up and configuring the
+// host side is left to the caller, e.g. with `ip link set <name> up`.
+//
+// The kernel is the other end of the TAP device, so the NIC uses a random
+// locally administered MAC address that differs from the address of the
+// host interface.
+func NewTAPNIC(name string) (NIC, error) {
+	var req struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [24 - 2]byte
+	}
+	if len(name) == 0 || len(name) >= syscall.IFNAMSIZ {
+		return nil, ErrInvalidInterfaceName
+	}
+	copy(req.name[:], name)
+	req.flags = syscall.IFF_TAP | syscall.IFF_NO_PI
+
+	// The device must be attached before the file is registered with the
+	// runtime poller, otherwise the poller never sees it becoming readable.
+	fd, err := syscall.Open("/dev/net/tun", syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("open", err)
+	}
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL, uintptr(fd),
+		uintptr(syscall.TUNSETIFF), uintptr(unsafe.Pointer(&req)),
+	)
+	if errno != 0 {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("ioctl", errno)
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("setnonblock", err)
+	}
+	file := os.NewFile(uintptr(fd), "tap:"+name)
+
+	mac, err := randomMAC()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return newLinuxNIC(tapDevice{file}, mac), nil
+}
+
+type tapDevice struct {
+	file *os.File
+}
+
+func (dev tapDevice) ReadFrame(b []byte) (int, error) {
+	return dev.file.Read(b)
+}
+
+func (dev tapDevice) WriteFrame(b []byte) error {
+	_, err := dev.file.Write(b)
+	return err
+}
+
+func (dev tapDevice) Close() error {
+	return dev.file.Close()
+}
+
+func (nic *linuxNIC) Start() {
+	go nic.sendAll()
+	go nic.receiveAll()
+}
+
+func (nic *linuxNIC) Close() {
+	close(nic.tx)
+	close(nic.done)
+	nic.dev.Close()
+}
+
+func (nic *linuxNIC) Send() chan<- Packet {
+	return nic.tx
+}
+
+func (nic *linuxNIC) Receive() <-chan Packet {
+	return nic.rx
+}
+
+func (nic *linuxNIC) GetMAC() MAC {
+	return nic.mac
+}
+
+func (nic *linuxNIC) sendAll() {
+	for p := range nic.tx {
+		// Frames that cannot be written, e.g. because the interface is
+		// down, are dropped like on a physical link.
+		nic.dev.WriteFrame(p.Bytes())
+	}
+}
+
+func (nic *linuxNIC) receiveAll() {
+	defer close(nic.rx)
+
+	for {
+		data := make([]byte, MaxPacketSize)
+
+		i, err := nic.dev.ReadFrame(data)
+		if errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) {
+			continue
+		} else if err != nil {
+			// The NIC was closed or the device was removed. The receive
+			// channel is closed, which stops the layers above.
+			return
+		}
+
+		packet, err := PacketFromBytes(data[:i])
+		if err != nil {
+			continue
+		}
+
+		select {
+		case nic.rx <- packet:
+		case <-nic.done:
+			return
+		}
+	}
+}
+
+// randomMAC generates a random unicast, locally administered MAC address.
+func randomMAC() (MAC, error) {
+	var mac MAC
+	if _, err := rand.Read(mac[:]); err != nil {
+		return mac, err
+	}
+	mac[0] = (mac[0] | 0x02) &^ 0x01
+	return mac, nil
 }
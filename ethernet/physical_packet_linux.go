@@ -0,0 +1,112 @@
+package ethernet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+var (
+	// ErrNotEthernetInterface is returned when an interface does not have
+	// an Ethernet MAC address.
+	ErrNotEthernetInterface = errors.New("not an Ethernet interface")
+)
+
+// NewPacketSocketNIC creates a new NIC linked to an existing Linux interface
+// using an AF_PACKET socket.
+//
+// The NIC uses the MAC address of the interface. The interface should not
+// have IPv4 addresses of its own, otherwise the kernel will answer the
+// same packets as the stack. Opening the socket requires the CAP_NET_RAW
+// capability.
+//
+// When attaching to a veth pair, disable checksum offloading on the peer
+// (`ethtool -K <peer> tx off`), as the kernel will otherwise hand out
+// packets with incomplete checksums.
+func NewPacketSocketNIC(name string) (NIC, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(iface.HardwareAddr) != MACLength {
+		return nil, ErrNotEthernetInterface
+	}
+	var mac MAC
+	copy(mac[:], iface.HardwareAddr)
+
+	// The socket receives no frames until it is bound to the interface,
+	// otherwise frames from other interfaces would be queued before the
+	// bind.
+	fd, err := syscall.Socket(
+		syscall.AF_PACKET,
+		syscall.SOCK_RAW|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC,
+		0,
+	)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	addr := &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: iface.Index}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	file := os.NewFile(uintptr(fd), "packet:"+name)
+	conn, err := file.SyscallConn()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return newLinuxNIC(packetDevice{file, conn}, mac), nil
+}
+
+type packetDevice struct {
+	file *os.File
+	conn syscall.RawConn
+}
+
+func (dev packetDevice) ReadFrame(b []byte) (n int, err error) {
+	for {
+		var from syscall.Sockaddr
+		var rerr error
+		err = dev.conn.Read(func(fd uintptr) bool {
+			n, from, rerr = syscall.Recvfrom(int(fd), b, 0)
+			return rerr != syscall.EAGAIN
+		})
+		if err == nil {
+			err = rerr
+		}
+		if err != nil {
+			return
+		}
+
+		// The socket also sees the frames we send ourselves.
+		ll, ok := from.(*syscall.SockaddrLinklayer)
+		if !ok || ll.Pkttype != syscall.PACKET_OUTGOING {
+			return
+		}
+	}
+}
+
+func (dev packetDevice) WriteFrame(b []byte) error {
+	var werr error
+	err := dev.conn.Write(func(fd uintptr) bool {
+		_, werr = syscall.Write(int(fd), b)
+		return werr != syscall.EAGAIN
+	})
+	if err == nil {
+		err = werr
+	}
+	return err
+}
+
+func (dev packetDevice) Close() error {
+	return dev.file.Close()
+}
+
+func htons(i uint16) uint16 {
+	return i<<8 | i>>8
+}
@@ -0,0 +1,235 @@
+package icmp
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+// NewDestinationUnreachableMessage creates a destination unreachable
+// message about a packet that could not be delivered. The message is
+// addressed to the source of the packet.
+func NewDestinationUnreachableMessage(code Code, original ipv4.Packet) Packet {
+	return newErrorMessage(DestinationUnreachableType, code, DestinationUnreachable{Original: quote(original)}, original)
+}
+
+// NewRedirectMessage creates a redirect message that tells the source of a
+// packet to use another gateway.
+func NewRedirectMessage(code Code, gateway ipv4.Address, original ipv4.Packet) Packet {
+	return newErrorMessage(RedirectType, code, Redirect{Gateway: gateway, Original: quote(original)}, original)
+}
+
+// NewTimeExceededMessage creates a time exceeded message about a packet
+// that was dropped. The message is addressed to the source of the packet.
+func NewTimeExceededMessage(code Code, original ipv4.Packet) Packet {
+	return newErrorMessage(TimeExceededType, code, TimeExceeded{Original: quote(original)}, original)
+}
+
+// NewParameterProblemMessage creates a parameter problem message about a
+// packet with an invalid header. The pointer is the offset of the invalid
+// byte in the header.
+func NewParameterProblemMessage(pointer uint8, original ipv4.Packet) Packet {
+	return newErrorMessage(ParameterProblemType, 0, ParameterProblem{Pointer: pointer, Original: quote(original)}, original)
+}
+
+func newErrorMessage(t Type, code Code, data Data, original ipv4.Packet) Packet {
+	p := Packet{
+		Header:  Header{Type: t, Code: code},
+		Data:    data,
+		Address: original.Source,
+	}
+	p.Header.Checksum = common.PacketChecksum(p)
+	return p
+}
+
+// Original returns the packet included in an error message.
+//
+// If the packet is not an error message, false is returned.
+func (p Packet) Original() (ipv4.Packet, bool) {
+	switch data := p.Data.(type) {
+	case DestinationUnreachable:
+		return data.Original, true
+	case SourceQuench:
+		return data.Original, true
+	case Redirect:
+		return data.Original, true
+	case TimeExceeded:
+		return data.Original, true
+	case ParameterProblem:
+		return data.Original, true
+	}
+	return ipv4.Packet{}, false
+}
+
+// DestinationUnreachable is the data for destination unreachable packets.
+type DestinationUnreachable struct {
+	Unused uint16
+
+	// NextHopMTU is the MTU of the next hop for FragmentationNeededCode, as
+	// described in RFC 1191.
+	NextHopMTU uint16
+
+	// Original contains the header and the first bytes of the payload of
+	// the packet that could not be delivered.
+	Original ipv4.Packet
+}
+
+// NewDestinationUnreachable reads destination unreachable data from a
+// reader.
+func NewDestinationUnreachable(r io.Reader) (data DestinationUnreachable, err error) {
+	if err = binary.Read(r, binary.BigEndian, &data.Unused); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &data.NextHopMTU); err != nil {
+		return
+	}
+	data.Original, err = newOriginal(r)
+	return
+}
+
+// Write the destination unreachable data to the writer.
+func (d DestinationUnreachable) Write(w io.Writer) error {
+	if err := binary.Write(w, binary.BigEndian, d.Unused); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, d.NextHopMTU); err != nil {
+		return err
+	}
+	return d.Original.Write(w)
+}
+
+// quote returns the part of a packet that is included in error messages:
+// the header and the first eight bytes of the payload.
+func quote(p ipv4.Packet) ipv4.Packet {
+	if len(p.Payload) > 8 {
+		p.Payload = p.Payload[:8]
+	}
+	return p
+}
+
+// newOriginal reads the packet included in an error message. The payload
+// of the packet is usually incomplete.
+func newOriginal(r io.Reader) (p ipv4.Packet, err error) {
+	if p.Header, err = ipv4.NewHeader(r); err != nil {
+		return
+	}
+	p.Payload, err = ioutil.ReadAll(r)
+	return
+}
+
+// SourceQuench is the data for source quench packets.
+type SourceQuench struct {
+	Unused uint32
+
+	// Original contains the header and the first bytes of the payload of
+	// the packet that was dropped.
+	Original ipv4.Packet
+}
+
+// NewSourceQuench reads source quench data from a reader.
+func NewSourceQuench(r io.Reader) (data SourceQuench, err error) {
+	if err = binary.Read(r, binary.BigEndian, &data.Unused); err != nil {
+		return
+	}
+	data.Original, err = newOriginal(r)
+	return
+}
+
+// Write the source quench data to the writer.
+func (d SourceQuench) Write(w io.Writer) error {
+	if err := binary.Write(w, binary.BigEndian, d.Unused); err != nil {
+		return err
+	}
+	return d.Original.Write(w)
+}
+
+// Redirect is the data for redirect packets.
+type Redirect struct {
+	// Gateway is the gateway to which the packets should be sent.
+	Gateway ipv4.Address
+
+	// Original contains the header and the first bytes of the payload of
+	// the packet that caused the redirect.
+	Original ipv4.Packet
+}
+
+// NewRedirect reads redirect data from a reader.
+func NewRedirect(r io.Reader) (data Redirect, err error) {
+	if err = binary.Read(r, binary.BigEndian, &data.Gateway); err != nil {
+		return
+	}
+	data.Original, err = newOriginal(r)
+	return
+}
+
+// Write the redirect data to the writer.
+func (d Redirect) Write(w io.Writer) error {
+	if err := binary.Write(w, binary.BigEndian, d.Gateway); err != nil {
+		return err
+	}
+	return d.Original.Write(w)
+}
+
+// TimeExceeded is the data for time exceeded packets.
+type TimeExceeded struct {
+	Unused uint32
+
+	// Original contains the header and the first bytes of the payload of
+	// the packet that was dropped.
+	Original ipv4.Packet
+}
+
+// NewTimeExceeded reads time exceeded data from a reader.
+func NewTimeExceeded(r io.Reader) (data TimeExceeded, err error) {
+	if err = binary.Read(r, binary.BigEndian, &data.Unused); err != nil {
+		return
+	}
+	data.Original, err = newOriginal(r)
+	return
+}
+
+// Write the time exceeded data to the writer.
+func (d TimeExceeded) Write(w io.Writer) error {
+	if err := binary.Write(w, binary.BigEndian, d.Unused); err != nil {
+		return err
+	}
+	return d.Original.Write(w)
+}
+
+// ParameterProblem is the data for parameter problem packets.
+type ParameterProblem struct {
+	// Pointer is the offset of the byte in the header of the original
+	// packet where the problem was detected.
+	Pointer uint8
+	Unused  [3]uint8
+
+	// Original contains the header and the first bytes of the payload of
+	// the packet that was dropped.
+	Original ipv4.Packet
+}
+
+// NewParameterProblem reads parameter problem data from a reader.
+func NewParameterProblem(r io.Reader) (data ParameterProblem, err error) {
+	if err = binary.Read(r, binary.BigEndian, &data.Pointer); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &data.Unused); err != nil {
+		return
+	}
+	data.Original, err = newOriginal(r)
+	return
+}
+
+// Write the parameter problem data to the writer.
+func (d ParameterProblem) Write(w io.Writer) error {
+	if err := binary.Write(w, binary.BigEndian, d.Pointer); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, d.Unused); err != nil {
+		return err
+	}
+	return d.Original.Write(w)
+}
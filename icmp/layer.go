@@ -2,20 +2,88 @@ package icmp
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"sync"
 
 	"github.com/unigornel/go-tcpip/common"
 	"github.com/unigornel/go-tcpip/ipv4"
 )
 
 // Layer is the ICMP layer.
+//
+// The channels of Packets, AllPackets and Errors queue DefaultQueueLength
+// messages. Messages are dropped when a channel is full, so that a reader
+// that falls behind does not stall the layer.
 type Layer interface {
 	Packets(p Type) <-chan Packet
 	Send(p Packet) error
+
+	// SendError sends an error message with ipv4.Layer.SendICMPError, so
+	// that it shares the rate limit of the errors of the IPv4 layer.
+	SendError(p Packet)
+
+	// AllPackets returns the messages of all types except echo requests,
+	// which are answered by the layer. They are delivered in addition to
+	// the channels of Packets.
+	AllPackets() <-chan Packet
+
+	// Errors returns the error messages, such as destination unreachable
+	// messages, about packets of a protocol.
+	Errors(p ipv4.Protocol) <-chan Packet
+
+	// BindEcho returns the echo replies with an identifier. They are no
+	// longer delivered by Packets until UnbindEcho is called. Replies are
+	// dropped when the channel is full.
+	//
+	// The error messages about echo requests with the identifier are
+	// delivered on the channel as well.
+	//
+	// See also ErrIdentifierInUse.
+	BindEcho(ident uint16) (<-chan Packet, error)
+
+	// UnbindEcho releases an identifier and closes its channel.
+	UnbindEcho(ident uint16)
+}
+
+// BindAnyEcho binds an echo identifier that is not in use, starting from
+// a random identifier, and returns it with the channel of BindEcho.
+//
+// ErrIdentifierInUse is returned if all identifiers are in use.
+func BindAnyEcho(layer Layer) (uint16, <-chan Packet, error) {
+	start := rand.Intn(0x10000)
+	for i := 0; i < 0x10000; i++ {
+		ident := uint16(start + i)
+		replies, err := layer.BindEcho(ident)
+		if err == ErrIdentifierInUse {
+			continue
+		}
+		return ident, replies, err
+	}
+	return 0, nil, ErrIdentifierInUse
 }
 
+var (
+	// ErrIdentifierInUse is returned when binding an echo identifier that
+	// is in use.
+	ErrIdentifierInUse = errors.New("echo identifier already in use")
+)
+
+// DefaultQueueLength is the number of messages that are queued on the
+// channels of the layer.
+const DefaultQueueLength = 64
+
 type layer struct {
-	ip       ipv4.Layer
-	channels map[Type]chan Packet
+	ip ipv4.Layer
+
+	channelsLock sync.RWMutex
+	channels     map[Type]chan Packet
+	all          chan Packet
+	errors       map[ipv4.Protocol]chan Packet
+
+	lock sync.Mutex
+	echo map[uint16]chan Packet
 }
 
 // NewLayer creates a new instance of the default ICMP layer.
@@ -23,53 +91,183 @@ func NewLayer(ip ipv4.Layer) Layer {
 	l := &layer{
 		ip:       ip,
 		channels: make(map[Type]chan Packet),
+		errors:   make(map[ipv4.Protocol]chan Packet),
+		echo:     make(map[uint16]chan Packet),
 	}
-	go l.run()
+	go l.run(ip.Packets(ipv4.ProtocolICMP))
 	return l
 }
 
 func (layer *layer) Packets(t Type) <-chan Packet {
+	layer.channelsLock.Lock()
+	defer layer.channelsLock.Unlock()
+
 	c, ok := layer.channels[t]
 	if !ok {
-		c = make(chan Packet)
+		c = make(chan Packet, DefaultQueueLength)
 		layer.channels[t] = c
 	}
 	return c
 }
 
+func (layer *layer) AllPackets() <-chan Packet {
+	layer.channelsLock.Lock()
+	defer layer.channelsLock.Unlock()
+
+	if layer.all == nil {
+		layer.all = make(chan Packet, DefaultQueueLength)
+	}
+	return layer.all
+}
+
+func (layer *layer) Errors(p ipv4.Protocol) <-chan Packet {
+	layer.channelsLock.Lock()
+	defer layer.channelsLock.Unlock()
+
+	c, ok := layer.errors[p]
+	if !ok {
+		c = make(chan Packet, DefaultQueueLength)
+		layer.errors[p] = c
+	}
+	return c
+}
+
+func (layer *layer) BindEcho(ident uint16) (<-chan Packet, error) {
+	layer.lock.Lock()
+	defer layer.lock.Unlock()
+
+	if _, ok := layer.echo[ident]; ok {
+		return nil, ErrIdentifierInUse
+	}
+	c := make(chan Packet, DefaultQueueLength)
+	layer.echo[ident] = c
+	return c, nil
+}
+
+func (layer *layer) UnbindEcho(ident uint16) {
+	layer.lock.Lock()
+	defer layer.lock.Unlock()
+
+	if c, ok := layer.echo[ident]; ok {
+		close(c)
+		delete(layer.echo, ident)
+	}
+}
+
+// deliverEcho queues an echo reply, or an error message about an echo
+// request, on the channel of its identifier. False is returned if the
+// identifier is not bound.
+func (layer *layer) deliverEcho(p Packet) bool {
+	layer.lock.Lock()
+	defer layer.lock.Unlock()
+
+	var ident uint16
+	if data, ok := p.Data.(Echo); ok {
+		ident = data.Header.Identifier
+	} else if original, ok := p.Original(); ok && original.Protocol == ipv4.ProtocolICMP {
+		q := original.Payload
+		if len(q) < 8 || q[0] != EchoRequestType {
+			return false
+		}
+		ident = binary.BigEndian.Uint16(q[4:6])
+	} else {
+		return false
+	}
+	c, ok := layer.echo[ident]
+	if !ok {
+		return false
+	}
+	queue(c, p)
+	return true
+}
+
 func (layer *layer) Send(p Packet) error {
+	return layer.ip.Send(ipPacket(p))
+}
+
+func (layer *layer) SendError(p Packet) {
+	layer.ip.SendICMPError(ipPacket(p))
+}
+
+// ipPacket creates the IPv4 packet of a message.
+func ipPacket(p Packet) ipv4.Packet {
 	packet := ipv4.NewPacketTo(p.Address, ipv4.ProtocolICMP, common.PacketToBytes(p))
-	return layer.ip.Send(packet)
+	packet.Source = p.Local
+	if p.TTL != 0 {
+		packet.TTL = p.TTL
+	}
+	return packet
 }
 
-func (layer *layer) run() {
-	for packet := range layer.ip.Packets(ipv4.ProtocolICMP) {
+func (layer *layer) run(packets <-chan ipv4.Packet) {
+	for packet := range packets {
 		p, err := NewPacket(bytes.NewReader(packet.Payload))
 		if err != nil {
 			continue
 		}
 
 		p.Address = packet.Source
+		p.Local = packet.Destination
+		p.TTL = packet.TTL
 
 		switch p.Header.Type {
 		case EchoRequestType:
 			go layer.handleEchoRequest(p)
-		default:
-			c := layer.channels[p.Header.Type]
-			if c != nil {
-				c <- p
+		case EchoReplyType:
+			if !layer.deliverEcho(p) {
+				layer.deliver(p)
 			}
+		default:
+			layer.deliver(p)
+		}
+
+		if original, ok := p.Original(); ok {
+			layer.deliverEcho(p)
+			layer.channelsLock.RLock()
+			queue(layer.errors[original.Protocol], p)
+			layer.channelsLock.RUnlock()
 		}
 	}
 
+	layer.channelsLock.Lock()
+	defer layer.channelsLock.Unlock()
 	for _, c := range layer.channels {
 		close(c)
 	}
+	if layer.all != nil {
+		close(layer.all)
+	}
+	for _, c := range layer.errors {
+		close(c)
+	}
+}
+
+// deliver sends a message to the channel of its type and to the channel of
+// all types.
+func (layer *layer) deliver(p Packet) {
+	layer.channelsLock.RLock()
+	defer layer.channelsLock.RUnlock()
+	queue(layer.channels[p.Header.Type], p)
+	queue(layer.all, p)
+}
+
+// queue sends a message on a channel, unless the channel is nil or full.
+func queue(c chan Packet, p Packet) {
+	select {
+	case c <- p:
+	default:
+	}
 }
 
 func (layer *layer) handleEchoRequest(packet Packet) {
-	data := packet.Data.(Echo)
+	data, ok := packet.Data.(Echo)
+	if !ok {
+		return
+	}
 	reply := NewEchoReply(data.Header.Identifier, data.Header.SequenceNumber, data.Payload)
 	reply.Address = packet.Address
+	if layer.ip.HasAddress(packet.Local) {
+		reply.Local = packet.Local
+	}
 	layer.Send(reply)
 }
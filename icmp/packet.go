@@ -16,8 +16,24 @@ type Type uint8
 const (
 	// EchoReplyType is the ICMP type for an echo reply.
 	EchoReplyType = 0
+	// DestinationUnreachableType is the ICMP type for a destination
+	// unreachable message.
+	DestinationUnreachableType = 3
+	// SourceQuenchType is the ICMP type for a source quench message.
+	SourceQuenchType = 4
+	// RedirectType is the ICMP type for a redirect message.
+	RedirectType = 5
 	// EchoRequestType is the ICMP type for an echo request.
 	EchoRequestType = 8
+	// TimeExceededType is the ICMP type for a time exceeded message.
+	TimeExceededType = 11
+	// ParameterProblemType is the ICMP type for a parameter problem
+	// message.
+	ParameterProblemType = 12
+	// TimestampType is the ICMP type for a timestamp request.
+	TimestampType = 13
+	// TimestampReplyType is the ICMP type for a timestamp reply.
+	TimestampReplyType = 14
 )
 
 // Code is the code of the ICMP packet.
@@ -28,6 +44,51 @@ const (
 	EchoReplyCode = 0
 	// EchoRequestCode is the ICMP code for an echo request.
 	EchoRequestCode = 0
+	// TimestampCode is the ICMP code for a timestamp request.
+	TimestampCode = 0
+	// TimestampReplyCode is the ICMP code for a timestamp reply.
+	TimestampReplyCode = 0
+)
+
+const (
+	// NetworkUnreachableCode is the destination unreachable code used when
+	// there is no route to the network of the destination.
+	NetworkUnreachableCode = 0
+	// HostUnreachableCode is the destination unreachable code used when the
+	// destination host cannot be reached.
+	HostUnreachableCode = 1
+	// ProtocolUnreachableCode is the destination unreachable code used when
+	// the destination does not support the protocol.
+	ProtocolUnreachableCode = 2
+	// PortUnreachableCode is the destination unreachable code used when no
+	// application is listening on the destination port.
+	PortUnreachableCode = 3
+	// FragmentationNeededCode is the destination unreachable code used when
+	// a packet with the Don't Fragment flag is too large.
+	FragmentationNeededCode = 4
+)
+
+const (
+	// RedirectNetworkCode redirects the packets for the network of the
+	// destination.
+	RedirectNetworkCode = 0
+	// RedirectHostCode redirects the packets for the destination host.
+	RedirectHostCode = 1
+	// RedirectTOSNetworkCode redirects the packets for the network and
+	// type of service of the destination.
+	RedirectTOSNetworkCode = 2
+	// RedirectTOSHostCode redirects the packets for the host and type of
+	// service of the destination.
+	RedirectTOSHostCode = 3
+)
+
+const (
+	// TTLExceededCode is the time exceeded code used when the time to live
+	// of a packet reached zero in transit.
+	TTLExceededCode = 0
+	// ReassemblyTimeExceededCode is the time exceeded code used when the
+	// fragments of a datagram were not received in time.
+	ReassemblyTimeExceededCode = 1
 )
 
 // Header is the common ICMP header.
@@ -39,6 +100,8 @@ type Header struct {
 
 var (
 	// ErrUnsupportedICMPPacket is used for unsupported ICMP packet types.
+	//
+	// Deprecated: packets with an unsupported type are read as Raw data.
 	ErrUnsupportedICMPPacket = errors.New("unsupported ICMP packet")
 )
 
@@ -54,20 +117,46 @@ type Packet struct {
 
 	// Address is either the destination or source address.
 	Address ipv4.Address
+
+	// Local is the local address: the destination of received packets,
+	// and the source of sent packets. When sending, the unspecified
+	// address selects the source address of the route.
+	Local ipv4.Address
+
+	// TTL is the time to live of the IPv4 packet. When sending, zero
+	// selects the default.
+	TTL uint8
 }
 
 // NewPacket will read a packet from a reader.
+//
+// Packets with an unknown type or code are read as Raw data.
 func NewPacket(r io.Reader) (packet Packet, err error) {
 	if err = binary.Read(r, binary.BigEndian, &packet.Header); err != nil {
 		return
 	}
 
-	if packet.Header.Type == EchoRequestType && packet.Header.Code == EchoRequestCode {
+	switch h := packet.Header; {
+	case h.Type == EchoRequestType && h.Code == EchoRequestCode:
 		packet.Data, err = NewEcho(r)
-	} else if packet.Header.Type == EchoReplyType && packet.Header.Code == EchoReplyType {
+	case h.Type == EchoReplyType && h.Code == EchoReplyCode:
 		packet.Data, err = NewEcho(r)
-	} else {
-		err = ErrUnsupportedICMPPacket
+	case h.Type == DestinationUnreachableType:
+		packet.Data, err = NewDestinationUnreachable(r)
+	case h.Type == SourceQuenchType:
+		packet.Data, err = NewSourceQuench(r)
+	case h.Type == RedirectType:
+		packet.Data, err = NewRedirect(r)
+	case h.Type == TimeExceededType:
+		packet.Data, err = NewTimeExceeded(r)
+	case h.Type == ParameterProblemType:
+		packet.Data, err = NewParameterProblem(r)
+	case h.Type == TimestampType && h.Code == TimestampCode:
+		packet.Data, err = NewTimestamp(r)
+	case h.Type == TimestampReplyType && h.Code == TimestampReplyCode:
+		packet.Data, err = NewTimestamp(r)
+	default:
+		packet.Data, err = NewRaw(r)
 	}
 
 	return
@@ -105,6 +194,35 @@ func NewEchoReply(ident, seq uint16, payload []byte) Packet {
 	return p
 }
 
+// NewTimestampRequest creates a new timestamp request packet. The times
+// are in milliseconds since midnight UT.
+func NewTimestampRequest(ident, seq uint16, originate uint32) Packet {
+	p := Packet{
+		Header: Header{Type: TimestampType, Code: TimestampCode},
+		Data: Timestamp{
+			Header: EchoHeader{
+				Identifier:     ident,
+				SequenceNumber: seq,
+			},
+			Originate: originate,
+		},
+	}
+	p.Header.Checksum = common.PacketChecksum(p)
+	return p
+}
+
+// NewTimestampReply creates a new timestamp reply packet to a request.
+func NewTimestampReply(request Timestamp, receive, transmit uint32) Packet {
+	request.Receive = receive
+	request.Transmit = transmit
+	p := Packet{
+		Header: Header{Type: TimestampReplyType, Code: TimestampReplyCode},
+		Data:   request,
+	}
+	p.Header.Checksum = common.PacketChecksum(p)
+	return p
+}
+
 // Write will write a packet to a writer.
 func (p Packet) Write(w io.Writer) error {
 	if err := binary.Write(w, binary.BigEndian, p.Header); err != nil {
@@ -142,3 +260,44 @@ func (d Echo) Write(w io.Writer) error {
 	_, err := w.Write(d.Payload)
 	return err
 }
+
+// Timestamp is the data for timestamp request/reply packets.
+type Timestamp struct {
+	Header EchoHeader
+
+	// Originate, Receive and Transmit are the times in milliseconds since
+	// midnight UT at which the request was sent, the request was
+	// received and the reply was sent.
+	Originate uint32
+	Receive   uint32
+	Transmit  uint32
+}
+
+// NewTimestamp reads timestamp request/reply data from a reader.
+func NewTimestamp(r io.Reader) (data Timestamp, err error) {
+	err = binary.Read(r, binary.BigEndian, &data)
+	return
+}
+
+// Write the timestamp request/reply data to the writer.
+func (d Timestamp) Write(w io.Writer) error {
+	return binary.Write(w, binary.BigEndian, &d)
+}
+
+// Raw is the data for packets with an unsupported type or code.
+type Raw struct {
+	// Payload is the rest of the packet after the header.
+	Payload []byte
+}
+
+// NewRaw reads raw data from a reader.
+func NewRaw(r io.Reader) (data Raw, err error) {
+	data.Payload, err = ioutil.ReadAll(r)
+	return
+}
+
+// Write the raw data to the writer.
+func (d Raw) Write(w io.Writer) error {
+	_, err := w.Write(d.Payload)
+	return err
+}
@@ -0,0 +1,86 @@
+package icmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+func TestDestinationUnreachable(t *testing.T) {
+	original := ipv4.NewPacketTo(ipv4.Address{10, 0, 0, 2}, ipv4.ProtocolUDP, []byte("0123456789abcdef"))
+	original.Source = ipv4.Address{10, 0, 0, 1}
+	original.Checksum = original.CalculateChecksum()
+
+	p := NewDestinationUnreachableMessage(PortUnreachableCode, original)
+	assert.Equal(t, original.Source, p.Address)
+	b := common.PacketToBytes(p)
+	assert.Equal(t, 8+20+8, len(b))
+	assert.Equal(t, uint16(0xFFFF), common.Checksum(b))
+
+	q, err := NewPacket(bytes.NewReader(b))
+	assert.Nil(t, err)
+	assert.Equal(t, Type(DestinationUnreachableType), q.Header.Type)
+	assert.Equal(t, Code(PortUnreachableCode), q.Header.Code)
+	quoted, ok := q.Original()
+	assert.True(t, ok)
+	assert.Equal(t, original.Header, quoted.Header)
+	assert.Equal(t, []byte("01234567"), quoted.Payload)
+
+	_, ok = NewEchoRequest(1, 2, nil).Original()
+	assert.False(t, ok)
+}
+
+func TestRoundTrip(t *testing.T) {
+	original := ipv4.NewPacketTo(ipv4.Address{10, 0, 0, 2}, ipv4.ProtocolUDP, []byte("0123456789abcdef"))
+	original.Source = ipv4.Address{10, 0, 0, 1}
+	original.Checksum = original.CalculateChecksum()
+	quoted := quote(original)
+
+	tests := []struct {
+		name   string
+		packet Packet
+		error  bool
+	}{
+		{"echo request", NewEchoRequest(1, 2, []byte("ping")), false},
+		{"echo reply", NewEchoReply(1, 2, []byte("ping")), false},
+		{"destination unreachable", NewDestinationUnreachableMessage(HostUnreachableCode, original), true},
+		{"fragmentation needed", Packet{
+			Header: Header{Type: DestinationUnreachableType, Code: FragmentationNeededCode},
+			Data:   DestinationUnreachable{NextHopMTU: 1400, Original: quoted},
+		}, true},
+		{"source quench", Packet{
+			Header: Header{Type: SourceQuenchType},
+			Data:   SourceQuench{Original: quoted},
+		}, true},
+		{"redirect", NewRedirectMessage(RedirectHostCode, ipv4.Address{10, 0, 0, 254}, original), true},
+		{"time exceeded", NewTimeExceededMessage(TTLExceededCode, original), true},
+		{"parameter problem", NewParameterProblemMessage(12, original), true},
+		{"timestamp", NewTimestampRequest(1, 2, 1000), false},
+		{"timestamp reply", NewTimestampReply(Timestamp{Header: EchoHeader{1, 2}, Originate: 1000}, 2000, 3000), false},
+		{"unknown type", Packet{Header: Header{Type: 42, Code: 1}, Data: Raw{[]byte{1, 2, 3, 4, 5}}}, false},
+		{"unknown code", Packet{Header: Header{Type: EchoRequestType, Code: 1}, Data: Raw{[]byte{1, 2, 3, 4}}}, false},
+	}
+
+	for _, test := range tests {
+		p := test.packet
+		p.Address = ipv4.Address{}
+		p.Header.Checksum = 0
+		p.Header.Checksum = common.PacketChecksum(p)
+		b := common.PacketToBytes(p)
+		assert.Equal(t, uint16(0xFFFF), common.Checksum(b), test.name)
+
+		q, err := NewPacket(bytes.NewReader(b))
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, p, q, test.name)
+		assert.Equal(t, b, common.PacketToBytes(q), test.name)
+
+		o, ok := q.Original()
+		assert.Equal(t, test.error, ok, test.name)
+		if ok {
+			assert.Equal(t, quoted, o, test.name)
+		}
+	}
+}
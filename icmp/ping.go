@@ -0,0 +1,218 @@
+package icmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+var (
+	// ErrTimeout is returned when no echo reply is received in time.
+	ErrTimeout = errors.New("echo request timed out")
+
+	// ErrPingerClosed is returned when using a closed pinger.
+	ErrPingerClosed = errors.New("pinger closed")
+
+	// ErrUnreachable is returned when an echo request is answered with a
+	// destination unreachable message.
+	ErrUnreachable = errors.New("destination unreachable")
+
+	// ErrTimeExceeded is returned when an echo request is answered with a
+	// time exceeded message.
+	ErrTimeExceeded = errors.New("time to live exceeded")
+)
+
+// DefaultPingSize is the default payload size of echo requests, which is
+// the default of the ping utility.
+const DefaultPingSize = 56
+
+// Statistics summarizes the probes of a pinger.
+type Statistics struct {
+	Sent     int
+	Received int
+
+	// Min, Avg, Max and Mdev are the minimum, average, maximum and mean
+	// deviation of the round-trip times of the received replies.
+	Min  time.Duration
+	Avg  time.Duration
+	Max  time.Duration
+	Mdev time.Duration
+}
+
+// Loss returns the fraction of probes that were lost.
+func (s Statistics) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Sent-s.Received) / float64(s.Sent)
+}
+
+func (s Statistics) String() string {
+	r := fmt.Sprintf("%d packets transmitted, %d received, %g%% packet loss", s.Sent, s.Received, s.Loss()*100)
+	if s.Received > 0 {
+		r += fmt.Sprintf("\nrtt min/avg/max/mdev = %v/%v/%v/%v", s.Min, s.Avg, s.Max, s.Mdev)
+	}
+	return r
+}
+
+// Pinger sends echo requests to a host and matches the replies.
+//
+// The echo requests use an identifier that is unique in the ICMP layer,
+// and consecutive sequence numbers.
+type Pinger struct {
+	layer   Layer
+	address ipv4.Address
+	ident   uint16
+	replies <-chan Packet
+
+	// Size is the payload size of the echo requests. The payload contains
+	// the time at which the request was sent if it is at least eight
+	// bytes.
+	Size int
+
+	lock     sync.Mutex
+	sequence uint16
+
+	statsLock sync.Mutex
+	stats     Statistics
+	sum       float64
+	sum2      float64
+
+	closeOnce sync.Once
+}
+
+// NewPinger creates a pinger for a host.
+//
+// See also ErrIdentifierInUse.
+func NewPinger(layer Layer, address ipv4.Address) (*Pinger, error) {
+	ident, replies, err := BindAnyEcho(layer)
+	if err != nil {
+		return nil, err
+	}
+	return &Pinger{
+		layer:   layer,
+		address: address,
+		ident:   ident,
+		replies: replies,
+		Size:    DefaultPingSize,
+	}, nil
+}
+
+// Identifier returns the identifier of the echo requests.
+func (p *Pinger) Identifier() uint16 {
+	return p.ident
+}
+
+// Ping sends an echo request and waits for the reply. The round-trip time
+// is returned.
+//
+// Replies to earlier requests that timed out are ignored. Concurrent
+// calls are sent one after the other.
+//
+// See also ErrTimeout, ErrUnreachable and ErrTimeExceeded.
+func (p *Pinger) Ping(timeout time.Duration) (time.Duration, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	seq := p.sequence
+	p.sequence++
+
+	payload := make([]byte, p.Size)
+	sent := time.Now()
+	if len(payload) >= 8 {
+		binary.BigEndian.PutUint64(payload, uint64(sent.UnixNano()))
+	}
+	request := NewEchoRequest(p.ident, seq, payload)
+	request.Address = p.address
+	if err := p.layer.Send(request); err != nil {
+		return 0, err
+	}
+	p.statsLock.Lock()
+	p.stats.Sent++
+	p.statsLock.Unlock()
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case reply, ok := <-p.replies:
+			if !ok {
+				return 0, ErrPingerClosed
+			}
+			if original, ok := reply.Original(); ok {
+				if err := p.requestError(reply, original, seq); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			data, ok := reply.Data.(Echo)
+			if !ok || !reply.Address.Equals(p.address) || data.Header.SequenceNumber != seq {
+				continue
+			}
+			rtt := time.Since(sent)
+			p.record(rtt)
+			return rtt, nil
+		case <-deadline:
+			return 0, ErrTimeout
+		}
+	}
+}
+
+// requestError returns the error reported by an ICMP error message about
+// the echo request with a sequence number, or nil if the message is about
+// another packet.
+func (p *Pinger) requestError(m Packet, original ipv4.Packet, seq uint16) error {
+	if !original.Destination.Equals(p.address) || len(original.Payload) < 8 ||
+		binary.BigEndian.Uint16(original.Payload[6:8]) != seq {
+		return nil
+	}
+	switch m.Header.Type {
+	case DestinationUnreachableType:
+		return ErrUnreachable
+	case TimeExceededType:
+		return ErrTimeExceeded
+	}
+	return nil
+}
+
+// record adds the round-trip time of a reply to the statistics.
+func (p *Pinger) record(rtt time.Duration) {
+	p.statsLock.Lock()
+	defer p.statsLock.Unlock()
+
+	s := &p.stats
+	if s.Received == 0 || rtt < s.Min {
+		s.Min = rtt
+	}
+	if rtt > s.Max {
+		s.Max = rtt
+	}
+	s.Received++
+
+	p.sum += float64(rtt)
+	p.sum2 += float64(rtt) * float64(rtt)
+	avg := p.sum / float64(s.Received)
+	s.Avg = time.Duration(avg)
+	s.Mdev = time.Duration(math.Sqrt(math.Max(0, p.sum2/float64(s.Received)-avg*avg)))
+}
+
+// Statistics returns the statistics of the probes sent so far.
+func (p *Pinger) Statistics() Statistics {
+	p.statsLock.Lock()
+	defer p.statsLock.Unlock()
+	return p.stats
+}
+
+// Close releases the identifier of the pinger.
+func (p *Pinger) Close() error {
+	err := ErrPingerClosed
+	p.closeOnce.Do(func() {
+		p.layer.UnbindEcho(p.ident)
+		err = nil
+	})
+	return err
+}
@@ -0,0 +1,111 @@
+package icmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/virtual"
+)
+
+func newIPv4Layer(nic ethernet.NIC, address ipv4.Address) ipv4.Layer {
+	netmask, _ := ipv4.NewAddress("255.255.255.0")
+	eth := ethernet.NewLayer(nic)
+	arp := ipv4.NewARP(nic.GetMAC(), address, eth)
+	return ipv4.NewLayer(address, ipv4.NewRouter(arp, address, netmask, nil), eth)
+}
+
+// fullLayer is an ICMP layer whose echo identifiers are all in use.
+type fullLayer struct {
+	Layer
+}
+
+func (fullLayer) BindEcho(ident uint16) (<-chan Packet, error) {
+	return nil, ErrIdentifierInUse
+}
+
+func TestPinger(t *testing.T) {
+	nicA, nicB := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	nicC, nicD := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 3}, ethernet.MAC{0x02, 0, 0, 0, 0, 4})
+	a := NewLayer(newIPv4Layer(nicA, ipv4.Address{10, 0, 0, 1}))
+	NewLayer(newIPv4Layer(nicB, ipv4.Address{10, 0, 0, 2}))
+	c := NewLayer(newIPv4Layer(nicC, ipv4.Address{10, 0, 0, 3}))
+	d := newIPv4Layer(nicD, ipv4.Address{10, 0, 0, 4})
+	for _, nic := range []ethernet.NIC{nicA, nicB, nicC, nicD} {
+		nic.Start()
+	}
+
+	// Replies
+	p, err := NewPinger(a, ipv4.Address{10, 0, 0, 2})
+	assert.Nil(t, err)
+	_, err = a.BindEcho(p.Identifier())
+	assert.Equal(t, ErrIdentifierInUse, err)
+	for i := 0; i < 3; i++ {
+		rtt, err := p.Ping(5 * time.Second)
+		assert.Nil(t, err)
+		assert.True(t, rtt > 0)
+	}
+	s := p.Statistics()
+	assert.Equal(t, 3, s.Sent)
+	assert.Equal(t, 3, s.Received)
+	assert.Equal(t, 0.0, s.Loss())
+	assert.True(t, s.Min <= s.Avg && s.Avg <= s.Max)
+	assert.True(t, s.Mdev <= s.Max-s.Min)
+	assert.Nil(t, p.Close())
+	assert.Equal(t, ErrPingerClosed, p.Close())
+
+	_, err = NewPinger(fullLayer{a}, ipv4.Address{10, 0, 0, 2})
+	assert.Equal(t, ErrIdentifierInUse, err)
+
+	// Errors, as the host has no ICMP layer
+	p, err = NewPinger(c, ipv4.Address{10, 0, 0, 4})
+	assert.Nil(t, err)
+	defer p.Close()
+	p.Size = 4
+	_, err = p.Ping(5 * time.Second)
+	assert.Equal(t, ErrUnreachable, err)
+
+	// Timeouts, as the host drops ICMP messages
+	dropped := d.Packets(ipv4.ProtocolICMP)
+	go func() {
+		for range dropped {
+		}
+	}()
+	_, err = p.Ping(100 * time.Millisecond)
+	assert.Equal(t, ErrTimeout, err)
+	s = p.Statistics()
+	assert.Equal(t, 2, s.Sent)
+	assert.Equal(t, 0, s.Received)
+	assert.Equal(t, 1.0, s.Loss())
+	assert.Equal(t, "2 packets transmitted, 0 received, 100% packet loss", s.String())
+}
+
+func TestSlowReader(t *testing.T) {
+	nicA, nicB := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	a := NewLayer(newIPv4Layer(nicA, ipv4.Address{10, 0, 0, 1}))
+	NewLayer(newIPv4Layer(nicB, ipv4.Address{10, 0, 0, 2}))
+	nicA.Start()
+	nicB.Start()
+
+	// The replies to unbound identifiers fill a channel that is not read.
+	replies, all := a.Packets(EchoReplyType), a.AllPackets()
+	for i := 0; i <= DefaultQueueLength; i++ {
+		request := NewEchoRequest(1, uint16(i), nil)
+		request.Address = ipv4.Address{10, 0, 0, 2}
+		assert.Nil(t, a.Send(request))
+		select {
+		case <-all:
+		case <-time.After(5 * time.Second):
+			t.Fatal("No echo reply received")
+		}
+	}
+
+	p, err := NewPinger(a, ipv4.Address{10, 0, 0, 2})
+	assert.Nil(t, err)
+	defer p.Close()
+	_, err = p.Ping(5 * time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultQueueLength, len(replies))
+}
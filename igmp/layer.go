@@ -0,0 +1,438 @@
+package igmp
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+const (
+	// DefaultUnsolicitedReportInterval is the maximum time between the
+	// reports that are sent when a group is joined or left.
+	DefaultUnsolicitedReportInterval = time.Second
+
+	// robustness is the number of times the reports are sent when a group
+	// is joined or left.
+	robustness = 2
+
+	// olderQuerierTimeout is the time during which the version of a query
+	// of an older version is used, with the default timers of RFC 3376.
+	olderQuerierTimeout = 260 * time.Second
+)
+
+// routerAlert is the IPv4 option that is added to all IGMP messages.
+var routerAlert = []byte{0x94, 0x04, 0x00, 0x00}
+
+// Layer manages the multicast group memberships of the interfaces of an
+// IPv4 layer, as a host of RFC 3376.
+//
+// Reports are sent with the version of the layer, or with the version of
+// the queries of an older querier on the interface.
+type Layer interface {
+	// Join joins a multicast group on an interface, and reports the
+	// membership to the routers. A nil interface is the first interface
+	// of the IPv4 layer. A group that is joined several times is left
+	// after as many calls to Leave.
+	//
+	// See also ipv4.ErrNotMulticast.
+	Join(i ipv4.Interface, group ipv4.Address) error
+
+	// Leave leaves a multicast group on an interface. The routers are
+	// told when the last membership is left.
+	//
+	// See also ipv4.ErrGroupNotJoined.
+	Leave(i ipv4.Interface, group ipv4.Address) error
+
+	// Groups returns the groups that were joined on an interface.
+	Groups(i ipv4.Interface) []ipv4.Address
+}
+
+// pending is a report that is sent in response to a query.
+type pending struct {
+	timer    *time.Timer
+	deadline time.Time
+}
+
+// schedule arranges to call f after a delay, unless the pending report is
+// due before that.
+func (p *pending) schedule(delay time.Duration, f func()) {
+	deadline := time.Now().Add(delay)
+	if p.timer != nil {
+		if p.deadline.Before(deadline) {
+			return
+		}
+		p.timer.Stop()
+	}
+	p.timer = time.AfterFunc(delay, f)
+	p.deadline = deadline
+}
+
+func (p *pending) cancel() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
+
+type group struct {
+	count  int
+	report pending
+
+	// sources are the sources of the group-and-source specific queries
+	// of the pending report, which are reported if all is not set.
+	sources []ipv4.Address
+	all     bool
+}
+
+type membership struct {
+	groups  map[ipv4.Address]*group
+	general pending
+
+	// older is the version of an older querier, which is present until
+	// olderExpires.
+	older        Version
+	olderExpires time.Time
+}
+
+type layer struct {
+	ip       ipv4.Layer
+	version  Version
+	interval time.Duration
+
+	lock       sync.Mutex
+	interfaces map[ipv4.Interface]*membership
+}
+
+// NewLayer creates an IGMPv3 layer.
+func NewLayer(ip ipv4.Layer) Layer {
+	return NewCustomLayer(ip, Version3, DefaultUnsolicitedReportInterval)
+}
+
+// NewCustomLayer creates an IGMP layer that sends reports of a version,
+// and repeats the reports of joined and left groups within interval.
+func NewCustomLayer(ip ipv4.Layer, version Version, interval time.Duration) Layer {
+	l := &layer{
+		ip:         ip,
+		version:    version,
+		interval:   interval,
+		interfaces: make(map[ipv4.Interface]*membership),
+	}
+	go l.run(ip.Packets(ipv4.ProtocolIGMP))
+	return l
+}
+
+// membership returns the membership of an interface. The layer must be
+// locked.
+func (l *layer) membership(i ipv4.Interface) *membership {
+	m, ok := l.interfaces[i]
+	if !ok {
+		m = &membership{groups: make(map[ipv4.Address]*group)}
+		l.interfaces[i] = m
+	}
+	return m
+}
+
+// compatibility returns the version of the reports of an interface. The
+// layer must be locked.
+func (l *layer) compatibility(m *membership) Version {
+	if m.older != 0 && m.older < l.version && time.Now().Before(m.olderExpires) {
+		return m.older
+	}
+	return l.version
+}
+
+// resolve replaces a nil interface with the first interface of the IPv4
+// layer.
+func (l *layer) resolve(i ipv4.Interface) (ipv4.Interface, error) {
+	if i != nil {
+		return i, nil
+	}
+	interfaces := l.ip.Interfaces()
+	if len(interfaces) == 0 {
+		return nil, ipv4.ErrNoRouteToDestinationAddress
+	}
+	return interfaces[0], nil
+}
+
+func (l *layer) Join(i ipv4.Interface, g ipv4.Address) error {
+	if !g.IsMulticast() {
+		return ipv4.ErrNotMulticast
+	}
+	i, err := l.resolve(i)
+	if err != nil {
+		return err
+	}
+
+	// The interface joins and leaves groups with the layer locked, so that
+	// its groups match the memberships.
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	m := l.membership(i)
+	if joined, ok := m.groups[g]; ok {
+		joined.count++
+		return nil
+	}
+	if err := i.JoinGroup(g); err != nil {
+		return err
+	}
+	m.groups[g] = &group{count: 1}
+	go l.announce(i, g, true)
+	return nil
+}
+
+func (l *layer) Leave(i ipv4.Interface, g ipv4.Address) error {
+	i, err := l.resolve(i)
+	if err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	m := l.membership(i)
+	joined, ok := m.groups[g]
+	if !ok {
+		return ipv4.ErrGroupNotJoined
+	}
+	if joined.count--; joined.count > 0 {
+		return nil
+	}
+	joined.report.cancel()
+	delete(m.groups, g)
+	i.LeaveGroup(g)
+	go l.announce(i, g, false)
+	return nil
+}
+
+func (l *layer) Groups(i ipv4.Interface) []ipv4.Address {
+	i, err := l.resolve(i)
+	if err != nil {
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var groups []ipv4.Address
+	for g := range l.membership(i).groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(a, b int) bool { return bytes.Compare(groups[a][:], groups[b][:]) < 0 })
+	return groups
+}
+
+// announce sends the unsolicited reports of a group that was joined or
+// left. They are repeated at random times within the interval, as long as
+// the membership does not change. The all hosts group is not reported.
+func (l *layer) announce(i ipv4.Interface, g ipv4.Address, join bool) {
+	if g.Equals(ipv4.AllHosts) {
+		return
+	}
+	for n := 0; n < robustness; n++ {
+		if n > 0 {
+			time.Sleep(randomDelay(l.interval))
+		}
+
+		l.lock.Lock()
+		_, joined := l.membership(i).groups[g]
+		version := l.compatibility(l.membership(i))
+		l.lock.Unlock()
+		if joined != join {
+			return
+		}
+
+		switch {
+		case version == Version3:
+			t := RecordType(ChangeToExcludeMode)
+			if !join {
+				t = ChangeToIncludeMode
+			}
+			l.sendRecords(i, []Record{{Type: t, Group: g}})
+		case join:
+			l.sendReport(i, g, version)
+		case version == Version2:
+			// IGMPv2 hosts send a single leave message, and IGMPv1 hosts
+			// leave silently.
+			l.send(i, AllRouters, Message{Type: LeaveGroup, Group: g})
+			return
+		default:
+			return
+		}
+	}
+}
+
+// sendReport sends an IGMPv1 or IGMPv2 report of a group.
+func (l *layer) sendReport(i ipv4.Interface, g ipv4.Address, version Version) {
+	t := Type(V2MembershipReport)
+	if version == Version1 {
+		t = V1MembershipReport
+	}
+	l.send(i, g, Message{Type: t, Group: g})
+}
+
+func (l *layer) sendRecords(i ipv4.Interface, records []Record) {
+	if len(records) > 0 {
+		l.send(i, AllV3Routers, Message{Type: V3MembershipReport, Records: records})
+	}
+}
+
+// send sends a message on an interface. IGMP messages have a time to live
+// of one and the Router Alert option.
+func (l *layer) send(i ipv4.Interface, destination ipv4.Address, m Message) error {
+	p := ipv4.NewPacketTo(destination, ipv4.ProtocolIGMP, common.PacketToBytes(m))
+	p.TTL = 1
+	p.Options = routerAlert
+	p.IHL += uint8(len(routerAlert) / 4)
+	p.TotalLength += uint16(len(routerAlert))
+	if addresses := i.Addresses(); len(addresses) > 0 {
+		p.Source = addresses[0].Address
+	}
+	p.Checksum = p.CalculateChecksum()
+
+	return i.Ethernet().Send(ethernet.Packet{
+		Destination: ipv4.MulticastMAC(destination),
+		EtherType:   ethernet.EtherTypeIPv4,
+		Payload:     common.PacketToBytes(p),
+	})
+}
+
+func (l *layer) run(packets <-chan ipv4.Packet) {
+	for p := range packets {
+		m, err := NewMessage(bytes.NewReader(p.Payload))
+		if err != nil || p.Interface == nil {
+			continue
+		}
+		switch m.Type {
+		case MembershipQuery:
+			l.handleQuery(p.Interface, m)
+		case V1MembershipReport, V2MembershipReport:
+			l.handleReport(p.Interface, m)
+		}
+	}
+}
+
+// handleQuery schedules the reports that answer a query at a random time
+// within its maximum response time.
+func (l *layer) handleQuery(i ipv4.Interface, q Message) {
+	if !q.Group.Equals(ipv4.Address{}) && !q.Group.IsMulticast() {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	m := l.membership(i)
+	if v := q.QueryVersion(); v < Version3 {
+		if m.older == 0 || v <= m.older || !time.Now().Before(m.olderExpires) {
+			m.older = v
+		}
+		m.olderExpires = time.Now().Add(olderQuerierTimeout)
+	}
+
+	delay := randomDelay(q.MaxResponseTime())
+	version := l.compatibility(m)
+	if version == Version3 {
+		if m.general.timer != nil && m.general.deadline.Before(time.Now().Add(delay)) {
+			return
+		}
+		if q.Group.Equals(ipv4.Address{}) {
+			m.general.schedule(delay, func() { l.reportGeneral(i) })
+			return
+		}
+	}
+
+	for address, g := range m.groups {
+		if address.Equals(ipv4.AllHosts) || (!q.Group.Equals(ipv4.Address{}) && !q.Group.Equals(address)) {
+			continue
+		}
+		if version == Version3 {
+			if len(q.Sources) == 0 {
+				g.all = true
+			} else if !g.all {
+				g.sources = append(g.sources, q.Sources...)
+			}
+		}
+		address := address
+		g.report.schedule(delay, func() { l.reportGroup(i, address) })
+	}
+}
+
+// handleReport cancels the pending IGMPv1 and IGMPv2 report of a group
+// that another host reported.
+func (l *layer) handleReport(i ipv4.Interface, r Message) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	m := l.membership(i)
+	if g, ok := m.groups[r.Group]; ok && l.compatibility(m) < Version3 {
+		g.report.cancel()
+	}
+}
+
+// reportGeneral answers an IGMPv3 general query with the groups of an
+// interface.
+func (l *layer) reportGeneral(i ipv4.Interface) {
+	l.lock.Lock()
+	m := l.membership(i)
+	m.general.timer = nil
+	var records []Record
+	for address := range m.groups {
+		if !address.Equals(ipv4.AllHosts) {
+			records = append(records, Record{Type: ModeIsExclude, Group: address})
+		}
+	}
+	l.lock.Unlock()
+
+	sort.Slice(records, func(a, b int) bool {
+		return bytes.Compare(records[a].Group[:], records[b].Group[:]) < 0
+	})
+	l.sendRecords(i, records)
+}
+
+// reportGroup answers the queries about a group.
+func (l *layer) reportGroup(i ipv4.Interface, address ipv4.Address) {
+	l.lock.Lock()
+	m := l.membership(i)
+	g, ok := m.groups[address]
+	if !ok {
+		l.lock.Unlock()
+		return
+	}
+	g.report.timer = nil
+	version := l.compatibility(m)
+	record := Record{Type: ModeIsExclude, Group: address}
+	if !g.all && len(g.sources) > 0 {
+		// All sources are received, so the queried ones are included.
+		record = Record{Type: ModeIsInclude, Group: address, Sources: unique(g.sources)}
+	}
+	g.sources, g.all = nil, false
+	l.lock.Unlock()
+
+	if version == Version3 {
+		l.sendRecords(i, []Record{record})
+	} else {
+		l.sendReport(i, address, version)
+	}
+}
+
+func unique(addresses []ipv4.Address) []ipv4.Address {
+	seen := make(map[ipv4.Address]bool)
+	var result []ipv4.Address
+	for _, a := range addresses {
+		if !seen[a] {
+			seen[a] = true
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// randomDelay returns a random duration shorter than max.
+func randomDelay(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
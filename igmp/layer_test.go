@@ -0,0 +1,168 @@
+package igmp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/virtual"
+)
+
+func TestLayer(t *testing.T) {
+	host, router := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	i := ipv4.NewInterface("eth0", ethernet.NewLayer(host), nil, ipv4.DefaultMTU)
+	i.AddAddress(ipv4.Address{10, 0, 0, 1}, ipv4.Address{255, 255, 255, 0})
+	ip := ipv4.NewInterfaceLayer(
+		ipv4.NewInterfaceRouter([]ipv4.Interface{i}, nil), []ipv4.Interface{i},
+		ipv4.DefaultReassemblyTimeout, ipv4.DefaultReassemblyMemory,
+	)
+	l := NewCustomLayer(ip, Version3, 10*time.Millisecond)
+
+	group := ipv4.Address{239, 1, 2, 3}
+	eth := ethernet.NewLayer(router).(ethernet.MulticastLayer)
+	for _, g := range []ipv4.Address{AllRouters, AllV3Routers, group} {
+		eth.JoinMulticast(ipv4.MulticastMAC(g))
+	}
+	frames := eth.Packets(ethernet.EtherTypeIPv4)
+	host.Start()
+	router.Start()
+	defer host.Close()
+	defer router.Close()
+
+	receive := func(destination ipv4.Address) Message {
+		select {
+		case frame := <-frames:
+			p, err := ipv4.NewPacket(bytes.NewReader(frame.Payload))
+			assert.Nil(t, err)
+			assert.Equal(t, ipv4.MulticastMAC(destination), frame.Destination)
+			assert.Equal(t, destination, p.Destination)
+			assert.Equal(t, ipv4.Address{10, 0, 0, 1}, p.Source)
+			assert.Equal(t, uint8(1), p.TTL)
+			assert.Equal(t, routerAlert, p.Options)
+			m, err := NewMessage(bytes.NewReader(p.Payload))
+			assert.Nil(t, err)
+			return m
+		case <-time.After(time.Second):
+			t.Fatal("No message sent")
+			return Message{}
+		}
+	}
+	query := func(destination ipv4.Address, m Message) {
+		p := ipv4.NewPacketTo(destination, ipv4.ProtocolIGMP, common.PacketToBytes(m))
+		p.Source = ipv4.Address{10, 0, 0, 254}
+		p.TTL = 1
+		p.Checksum = p.CalculateChecksum()
+		eth.Send(ethernet.Packet{
+			Destination: ipv4.MulticastMAC(destination),
+			EtherType:   ethernet.EtherTypeIPv4,
+			Payload:     common.PacketToBytes(p),
+		})
+	}
+	report := func(records ...Record) Message {
+		return Message{Type: V3MembershipReport, Records: records}
+	}
+
+	assert.Equal(t, ipv4.ErrNotMulticast, l.Join(nil, ipv4.Address{10, 0, 0, 2}))
+	assert.Equal(t, ipv4.ErrGroupNotJoined, l.Leave(nil, group))
+
+	// Joining sends state change reports.
+	assert.Nil(t, l.Join(nil, group))
+	assert.Nil(t, l.Join(i, group))
+	assert.Equal(t, []ipv4.Address{group}, l.Groups(i))
+	assert.Equal(t, []ipv4.Address{group}, i.Groups())
+	for n := 0; n < robustness; n++ {
+		assert.Equal(t, report(Record{Type: ChangeToExcludeMode, Group: group}), receive(AllV3Routers))
+	}
+
+	// Queries
+	query(ipv4.AllHosts, Message{Type: MembershipQuery, MaxResponseCode: 1, Extended: true})
+	assert.Equal(t, report(Record{Type: ModeIsExclude, Group: group}), receive(AllV3Routers))
+	query(group, Message{Type: MembershipQuery, MaxResponseCode: 1, Extended: true, Group: group})
+	assert.Equal(t, report(Record{Type: ModeIsExclude, Group: group}), receive(AllV3Routers))
+	sources := []ipv4.Address{{10, 0, 0, 9}}
+	query(group, Message{Type: MembershipQuery, MaxResponseCode: 1, Extended: true, Group: group, Sources: sources})
+	assert.Equal(t, report(Record{Type: ModeIsInclude, Group: group, Sources: sources}), receive(AllV3Routers))
+
+	// The group is left after the last membership.
+	assert.Nil(t, l.Leave(nil, group))
+	assert.Nil(t, l.Leave(i, group))
+	assert.Equal(t, ipv4.ErrGroupNotJoined, l.Leave(i, group))
+	assert.Equal(t, 0, len(i.Groups()))
+	for n := 0; n < robustness; n++ {
+		assert.Equal(t, report(Record{Type: ChangeToIncludeMode, Group: group}), receive(AllV3Routers))
+	}
+
+	// An IGMPv2 querier makes the layer use IGMPv2.
+	assert.Nil(t, l.Join(i, group))
+	for n := 0; n < robustness; n++ {
+		receive(AllV3Routers)
+	}
+	query(ipv4.AllHosts, Message{Type: MembershipQuery, MaxResponseCode: 1})
+	assert.Equal(t, Message{Type: V2MembershipReport, Group: group}, receive(group))
+	assert.Nil(t, l.Leave(i, group))
+	assert.Equal(t, Message{Type: LeaveGroup, Group: group}, receive(AllRouters))
+	assert.Nil(t, l.Join(i, group))
+	for n := 0; n < robustness; n++ {
+		assert.Equal(t, Message{Type: V2MembershipReport, Group: group}, receive(group))
+	}
+}
+
+type failingInterface struct {
+	ipv4.Interface
+	err error
+}
+
+func (i *failingInterface) JoinGroup(g ipv4.Address) error {
+	if i.err != nil {
+		return i.err
+	}
+	return i.Interface.JoinGroup(g)
+}
+
+func TestJoinError(t *testing.T) {
+	host, router := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	eth := ethernet.NewLayer(router).(ethernet.MulticastLayer)
+	eth.JoinMulticast(ipv4.MulticastMAC(AllV3Routers))
+	frames := eth.Packets(ethernet.EtherTypeIPv4)
+	host.Start()
+	router.Start()
+	defer host.Close()
+	defer router.Close()
+
+	i := ipv4.NewInterface("eth0", ethernet.NewLayer(host), nil, ipv4.DefaultMTU)
+	i.AddAddress(ipv4.Address{10, 0, 0, 1}, ipv4.Address{255, 255, 255, 0})
+	ip := ipv4.NewInterfaceLayer(
+		ipv4.NewInterfaceRouter([]ipv4.Interface{i}, nil), []ipv4.Interface{i},
+		ipv4.DefaultReassemblyTimeout, ipv4.DefaultReassemblyMemory,
+	)
+	l := NewCustomLayer(ip, Version3, 10*time.Millisecond)
+
+	wait := func() {
+		for n := 0; n < robustness; n++ {
+			select {
+			case <-frames:
+			case <-time.After(time.Second):
+				t.Fatal("timeout")
+			}
+		}
+	}
+
+	// A failed join records no membership.
+	group := ipv4.Address{239, 1, 2, 3}
+	f := &failingInterface{Interface: i, err: errors.New("join failed")}
+	assert.Equal(t, f.err, l.Join(f, group))
+	assert.Equal(t, ipv4.ErrGroupNotJoined, l.Leave(f, group))
+
+	f.err = nil
+	assert.Nil(t, l.Join(f, group))
+	assert.Equal(t, []ipv4.Address{group}, i.Groups())
+	wait()
+	assert.Nil(t, l.Leave(f, group))
+	assert.Equal(t, 0, len(i.Groups()))
+	wait()
+}
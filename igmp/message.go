@@ -0,0 +1,237 @@
+package igmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+var (
+	// AllRouters is the multicast group of all routers, to which IGMPv2
+	// leave messages are sent.
+	AllRouters = ipv4.Address{224, 0, 0, 2}
+
+	// AllV3Routers is the multicast group of all IGMPv3 routers, to which
+	// IGMPv3 reports are sent.
+	AllV3Routers = ipv4.Address{224, 0, 0, 22}
+)
+
+var (
+	// ErrInvalidMessage is returned when reading a message that is too
+	// short or that has an incorrect checksum.
+	ErrInvalidMessage = errors.New("invalid IGMP message")
+)
+
+// Version is a version of IGMP.
+type Version int
+
+const (
+	// Version1 is IGMPv1, as described in RFC 1112.
+	Version1 = 1
+	// Version2 is IGMPv2, as described in RFC 2236.
+	Version2 = 2
+	// Version3 is IGMPv3, as described in RFC 3376.
+	Version3 = 3
+)
+
+// Type is the type of an IGMP message.
+type Type uint8
+
+const (
+	// MembershipQuery is sent by routers to learn the groups of a network.
+	MembershipQuery = 0x11
+	// V1MembershipReport is sent by IGMPv1 hosts.
+	V1MembershipReport = 0x12
+	// V2MembershipReport is sent by IGMPv2 hosts to the group.
+	V2MembershipReport = 0x16
+	// LeaveGroup is sent by IGMPv2 hosts that leave a group.
+	LeaveGroup = 0x17
+	// V3MembershipReport is sent by IGMPv3 hosts to AllV3Routers.
+	V3MembershipReport = 0x22
+)
+
+// RecordType is the type of a group record of an IGMPv3 report.
+type RecordType uint8
+
+const (
+	// ModeIsInclude reports that packets from the sources are received.
+	ModeIsInclude = 1
+	// ModeIsExclude reports that packets from all but the sources are
+	// received.
+	ModeIsExclude = 2
+	// ChangeToIncludeMode reports a change to include mode. Without
+	// sources, the group was left.
+	ChangeToIncludeMode = 3
+	// ChangeToExcludeMode reports a change to exclude mode. Without
+	// sources, the group was joined.
+	ChangeToExcludeMode = 4
+	// AllowNewSources reports sources that were added.
+	AllowNewSources = 5
+	// BlockOldSources reports sources that were removed.
+	BlockOldSources = 6
+)
+
+const (
+	// flagSuppress is the flag of IGMPv3 queries that tells routers not
+	// to update their timers.
+	flagSuppress = 0x08
+
+	// defaultMaxResponseTime is the maximum response time of IGMPv1
+	// queries, which do not have one.
+	defaultMaxResponseTime = 10 * time.Second
+)
+
+// Record is a group record of an IGMPv3 report.
+type Record struct {
+	Type    RecordType
+	Group   ipv4.Address
+	Sources []ipv4.Address
+}
+
+// Message is an IGMP message.
+//
+// IGMPv3 queries are extended with the fields from Flags to Sources, and
+// IGMPv3 reports have records instead of a group.
+type Message struct {
+	Type            Type
+	MaxResponseCode uint8
+	Group           ipv4.Address
+
+	// Extended is set for IGMPv3 queries.
+	Extended bool
+
+	// Flags are the suppress flag and the robustness variable of the
+	// querier.
+	Flags             uint8
+	QueryIntervalCode uint8
+	Sources           []ipv4.Address
+
+	Records []Record
+}
+
+// NewMessage reads a message from a reader.
+//
+// See also ErrInvalidMessage.
+func NewMessage(r io.Reader) (Message, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Message{}, err
+	}
+	if len(b) < 8 || common.Checksum(b) != 0xFFFF {
+		return Message{}, ErrInvalidMessage
+	}
+
+	m := Message{Type: Type(b[0]), MaxResponseCode: b[1]}
+	switch {
+	case m.Type == V3MembershipReport:
+		n := int(binary.BigEndian.Uint16(b[6:]))
+		b = b[8:]
+		for i := 0; i < n; i++ {
+			if len(b) < 8 {
+				return Message{}, ErrInvalidMessage
+			}
+			record := Record{Type: RecordType(b[0])}
+			aux, sources := int(b[1])*4, int(binary.BigEndian.Uint16(b[2:]))
+			copy(record.Group[:], b[4:8])
+			if record.Sources, b, err = addresses(b[8:], sources); err != nil {
+				return Message{}, err
+			} else if len(b) < aux {
+				return Message{}, ErrInvalidMessage
+			}
+			b = b[aux:]
+			m.Records = append(m.Records, record)
+		}
+	case m.Type == MembershipQuery && len(b) >= 12:
+		copy(m.Group[:], b[4:8])
+		m.Extended = true
+		m.Flags = b[8] & 0x0F
+		m.QueryIntervalCode = b[9]
+		if m.Sources, _, err = addresses(b[12:], int(binary.BigEndian.Uint16(b[10:]))); err != nil {
+			return Message{}, err
+		}
+	default:
+		copy(m.Group[:], b[4:8])
+	}
+	return m, nil
+}
+
+// addresses reads n addresses from b, and returns the remaining bytes.
+func addresses(b []byte, n int) ([]ipv4.Address, []byte, error) {
+	if len(b) < 4*n {
+		return nil, nil, ErrInvalidMessage
+	}
+	var result []ipv4.Address
+	for i := 0; i < n; i++ {
+		var a ipv4.Address
+		copy(a[:], b[4*i:])
+		result = append(result, a)
+	}
+	return result, b[4*n:], nil
+}
+
+// Write the message to a writer. The checksum is calculated.
+func (m Message) Write(w io.Writer) error {
+	var b bytes.Buffer
+	switch {
+	case m.Type == V3MembershipReport:
+		b.Write([]byte{byte(m.Type), 0, 0, 0, 0, 0})
+		binary.Write(&b, binary.BigEndian, uint16(len(m.Records)))
+		for _, record := range m.Records {
+			b.Write([]byte{byte(record.Type), 0})
+			binary.Write(&b, binary.BigEndian, uint16(len(record.Sources)))
+			b.Write(record.Group[:])
+			for _, s := range record.Sources {
+				b.Write(s[:])
+			}
+		}
+	case m.Type == MembershipQuery && m.Extended:
+		b.Write([]byte{byte(m.Type), m.MaxResponseCode, 0, 0})
+		b.Write(m.Group[:])
+		b.Write([]byte{m.Flags & 0x0F, m.QueryIntervalCode})
+		binary.Write(&b, binary.BigEndian, uint16(len(m.Sources)))
+		for _, s := range m.Sources {
+			b.Write(s[:])
+		}
+	default:
+		b.Write([]byte{byte(m.Type), m.MaxResponseCode, 0, 0})
+		b.Write(m.Group[:])
+	}
+
+	message := b.Bytes()
+	binary.BigEndian.PutUint16(message[2:], common.Checksum(message))
+	_, err := w.Write(message)
+	return err
+}
+
+// QueryVersion returns the version of IGMP of a query. IGMPv1 queries have
+// no maximum response time.
+func (m Message) QueryVersion() Version {
+	switch {
+	case m.Extended:
+		return Version3
+	case m.MaxResponseCode == 0:
+		return Version1
+	default:
+		return Version2
+	}
+}
+
+// MaxResponseTime returns the time within which a query must be answered.
+func (m Message) MaxResponseTime() time.Duration {
+	code := int(m.MaxResponseCode)
+	switch {
+	case code == 0 && !m.Extended:
+		return defaultMaxResponseTime
+	case code >= 128 && m.Extended:
+		// The code is a floating point number with a 3 bit exponent and a
+		// 4 bit mantissa.
+		code = (code&0x0F | 0x10) << uint((code>>4)&0x07+3)
+	}
+	return time.Duration(code) * time.Second / 10
+}
@@ -0,0 +1,60 @@
+package igmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+func TestMessage(t *testing.T) {
+	group := ipv4.Address{239, 1, 2, 3}
+	tests := []struct {
+		message Message
+		length  int
+		version Version
+		max     time.Duration
+	}{
+		{Message{Type: MembershipQuery}, 8, Version1, 10 * time.Second},
+		{Message{Type: MembershipQuery, MaxResponseCode: 100, Group: group}, 8, Version2, 10 * time.Second},
+		{Message{Type: V2MembershipReport, Group: group}, 8, Version2, 0},
+		{Message{
+			Type: MembershipQuery, MaxResponseCode: 0x8F, Extended: true, Flags: flagSuppress | 2,
+			QueryIntervalCode: 125, Group: group, Sources: []ipv4.Address{{10, 0, 0, 1}, {10, 0, 0, 2}},
+		}, 20, Version3, 24800 * time.Millisecond},
+		{Message{Type: V3MembershipReport, Records: []Record{
+			{Type: ModeIsExclude, Group: group},
+			{Type: ModeIsInclude, Group: ipv4.Address{239, 1, 2, 4}, Sources: []ipv4.Address{{10, 0, 0, 1}}},
+		}}, 28, Version1, 0},
+	}
+	for i, test := range tests {
+		b := common.PacketToBytes(test.message)
+		assert.Equal(t, test.length, len(b), "Test %d", i)
+		assert.Equal(t, uint16(0xFFFF), common.Checksum(b), "Test %d", i)
+
+		m, err := NewMessage(bytes.NewReader(b))
+		assert.Nil(t, err, "Test %d", i)
+		assert.Equal(t, test.message, m, "Test %d", i)
+		if m.Type == MembershipQuery {
+			assert.Equal(t, test.version, m.QueryVersion(), "Test %d", i)
+			assert.Equal(t, test.max, m.MaxResponseTime(), "Test %d", i)
+		}
+	}
+
+	b := common.PacketToBytes(Message{Type: V2MembershipReport, Group: group})
+	b[4]++
+	_, err := NewMessage(bytes.NewReader(b))
+	assert.Equal(t, ErrInvalidMessage, err)
+
+	// Truncated records
+	b = common.PacketToBytes(tests[4].message)
+	b = b[:len(b)-4]
+	binary.BigEndian.PutUint16(b[2:], 0)
+	binary.BigEndian.PutUint16(b[2:], common.Checksum(b))
+	_, err = NewMessage(bytes.NewReader(b))
+	assert.Equal(t, ErrInvalidMessage, err)
+}
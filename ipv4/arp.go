@@ -5,10 +5,10 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"sort"
 	"sync"
 	"time"
 
-	"github.com/patrickmn/go-cache"
 	"github.com/unigornel/go-tcpip/common"
 	"github.com/unigornel/go-tcpip/ethernet"
 )
@@ -17,6 +17,75 @@ import (
 // addresses.
 type ARP interface {
 	Resolve(address Address) (ethernet.MAC, error)
+
+	// Configure replaces the local addresses with a single address and
+	// network. The entries for addresses outside the network are removed,
+	// and a gratuitous ARP request announces a new address. A zero address
+	// removes all entries that are not permanent.
+	Configure(address, netmask Address)
+
+	// AddAddress adds a local address, or changes the netmask of an
+	// existing one. A gratuitous ARP request announces a new address.
+	AddAddress(address, netmask Address)
+
+	// RemoveAddress removes a local address. The entries for addresses
+	// that are no longer in a local network are removed.
+	RemoveAddress(address Address)
+
+	// Entries returns the entries of the cache, including the addresses
+	// that are being resolved, sorted by address.
+	Entries() []ARPEntry
+
+	// AddEntry adds an entry to the cache, or replaces one. An entry with
+	// a zero expiration is permanent: it does not expire, it is kept when
+	// the local addresses change and it is not updated by ARP packets.
+	AddEntry(address Address, mac ethernet.MAC, expiration time.Duration)
+
+	// DeleteEntry removes an entry from the cache.
+	//
+	// See also ErrARPEntryNotFound.
+	DeleteEntry(address Address) error
+
+	// Flush removes the entries that are not permanent.
+	Flush()
+}
+
+// ARPEntryState is the state of an ARP entry.
+type ARPEntryState int
+
+const (
+	// ARPIncomplete is the state of addresses that are being resolved.
+	ARPIncomplete ARPEntryState = iota
+	// ARPReachable is the state of resolved addresses, which expire.
+	ARPReachable
+	// ARPPermanent is the state of entries that do not expire.
+	ARPPermanent
+)
+
+func (s ARPEntryState) String() string {
+	switch s {
+	case ARPIncomplete:
+		return "INCOMPLETE"
+	case ARPReachable:
+		return "REACHABLE"
+	case ARPPermanent:
+		return "PERMANENT"
+	}
+	return "UNKNOWN"
+}
+
+// ARPEntry is an entry of an ARP cache.
+type ARPEntry struct {
+	Address Address
+	MAC     ethernet.MAC
+	State   ARPEntryState
+
+	// Age is the time since the entry was added or last updated, or since
+	// the resolution of an incomplete entry started.
+	Age time.Duration
+
+	// Expires is the time at which a reachable entry expires.
+	Expires time.Time
 }
 
 // ARPOperation is a type of ARP packet.
@@ -102,16 +171,41 @@ func (p ARPPacket) Write(w io.Writer) error {
 type pendingARPRequest struct {
 	gotReply chan struct{}
 	timeout  chan struct{}
+	started  time.Time
+
+	// replied closes gotReply once.
+	replied sync.Once
+}
+
+// arpEntry is a resolved address of the cache.
+type arpEntry struct {
+	mac     ethernet.MAC
+	updated time.Time
+
+	// expires is zero for permanent entries.
+	expires time.Time
+}
+
+func (e arpEntry) permanent() bool {
+	return e.expires.IsZero()
+}
+
+func (e arpEntry) expired(now time.Time) bool {
+	return !e.permanent() && !now.Before(e.expires)
 }
 
 type defaultARP struct {
 	sourceMAC     ethernet.MAC
-	sourceIP      Address
+	addressesLock sync.RWMutex
+	addresses     []InterfaceAddress
 	eth           ethernet.Layer
-	cache         *cache.Cache
+	expiration    time.Duration
 	queryInterval time.Duration
 	timeout       int
 
+	entriesLock sync.RWMutex
+	entries     map[Address]arpEntry
+
 	requestsLock sync.RWMutex
 	requests     map[Address]*pendingARPRequest
 }
@@ -120,7 +214,8 @@ const (
 	// DefaultARPExpiration is the default expiration for entries in the ARP table.
 	DefaultARPExpiration = 4 * time.Hour
 
-	// DefaultARPCleanupInterval is the cleanup interval for the cache.
+	// DefaultARPCleanupInterval is the interval at which expired entries
+	// are removed from the cache.
 	DefaultARPCleanupInterval = DefaultARPExpiration
 
 	// DefaultARPQueryInterval is the default query interval to use when sending
@@ -135,6 +230,10 @@ const (
 var (
 	// ErrARPTimeout occurs when no ARP reply is received for an ARP request.
 	ErrARPTimeout = errors.New("ARP request timeout")
+
+	// ErrARPEntryNotFound is returned when deleting an address that is not
+	// in the ARP cache.
+	ErrARPEntryNotFound = errors.New("ARP entry not found")
 )
 
 // NewARP will create a default ARP interface with the default configuration.
@@ -149,27 +248,65 @@ func NewARP(mac ethernet.MAC, ip Address, eth ethernet.Layer) ARP {
 }
 
 // NewCustomARP will create a default ARP interface with a custom configuration.
+//
+// Resolved addresses expire after expiration, and expired entries are
+// removed every cleanupInterval. With a zero cleanupInterval, expired entries
+// are ignored but never removed.
 func NewCustomARP(mac ethernet.MAC, ip Address, eth ethernet.Layer, expiration, cleanupInterval, queryInterval time.Duration, timeout int) ARP {
 	l := &defaultARP{
 		sourceMAC:     mac,
-		sourceIP:      ip,
 		eth:           eth,
-		cache:         cache.New(expiration, cleanupInterval),
+		expiration:    expiration,
 		queryInterval: queryInterval,
 		timeout:       timeout,
+		entries:       make(map[Address]arpEntry),
 		requests:      make(map[Address]*pendingARPRequest),
 	}
-	go l.run()
+	if !ip.Equals(Address{}) {
+		l.addresses = []InterfaceAddress{{Address: ip}}
+	}
+	done := make(chan struct{})
+	go l.run(eth.Packets(ethernet.EtherTypeARP), done)
+	if cleanupInterval > 0 {
+		go l.cleanup(cleanupInterval, done)
+	}
 	return l
 }
 
-func (arp *defaultARP) run() {
-	for frame := range arp.eth.Packets(ethernet.EtherTypeARP) {
+// cleanup removes the expired entries every interval, until done is
+// closed.
+func (arp *defaultARP) cleanup(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		now := time.Now()
+		arp.entriesLock.Lock()
+		for address, e := range arp.entries {
+			if e.expired(now) {
+				delete(arp.entries, address)
+			}
+		}
+		arp.entriesLock.Unlock()
+	}
+}
+
+// run handles the received ARP packets, and closes done when the Ethernet
+// layer stops.
+func (arp *defaultARP) run(frames <-chan ethernet.Packet, done chan<- struct{}) {
+	defer close(done)
+	for frame := range frames {
 		p, err := NewARPPacket(bytes.NewReader(frame.Payload))
 		if err != nil {
 			continue
 		}
 
+		arp.update(p.SenderProtocolAddress, p.SenderHardwareAddress)
 		switch p.Operation {
 		case ARPRequest:
 			go arp.handleRequest(p)
@@ -179,10 +316,222 @@ func (arp *defaultARP) run() {
 	}
 }
 
+func (arp *defaultARP) Configure(address, netmask Address) {
+	arp.addressesLock.Lock()
+	changed := len(arp.addresses) != 1 || !arp.addresses[0].Address.Equals(address)
+	arp.addresses = nil
+	if !address.Equals(Address{}) {
+		arp.addresses = []InterfaceAddress{{address, netmask}}
+	}
+	arp.addressesLock.Unlock()
+
+	// Without an address, no network is local.
+	local := InterfaceAddress{address, netmask}
+	arp.removeEntries(func(a Address) bool {
+		return address.Equals(Address{}) || !local.Contains(a)
+	})
+
+	if changed && !address.Equals(Address{}) {
+		arp.announce(address)
+	}
+}
+
+func (arp *defaultARP) AddAddress(address, netmask Address) {
+	arp.addressesLock.Lock()
+	for i, a := range arp.addresses {
+		if a.Address.Equals(address) {
+			arp.addresses[i].Netmask = netmask
+			arp.addressesLock.Unlock()
+			return
+		}
+	}
+	arp.addresses = append(arp.addresses, InterfaceAddress{address, netmask})
+	arp.addressesLock.Unlock()
+
+	arp.announce(address)
+}
+
+func (arp *defaultARP) RemoveAddress(address Address) {
+	arp.addressesLock.Lock()
+	defer arp.addressesLock.Unlock()
+
+	var removed *InterfaceAddress
+	for i, a := range arp.addresses {
+		if a.Address.Equals(address) {
+			removed = &a
+			arp.addresses = append(arp.addresses[:i], arp.addresses[i+1:]...)
+			break
+		}
+	}
+	if removed == nil {
+		return
+	}
+
+	arp.removeEntries(func(a Address) bool {
+		if !removed.Contains(a) {
+			return false
+		}
+		for _, local := range arp.addresses {
+			if local.Contains(a) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// removeEntries removes the entries that are not permanent for the
+// addresses that match a function.
+func (arp *defaultARP) removeEntries(match func(Address) bool) {
+	arp.entriesLock.Lock()
+	defer arp.entriesLock.Unlock()
+	for address, e := range arp.entries {
+		if !e.permanent() && match(address) {
+			delete(arp.entries, address)
+		}
+	}
+}
+
+func (arp *defaultARP) Entries() []ARPEntry {
+	now := time.Now()
+	var entries []ARPEntry
+
+	arp.entriesLock.RLock()
+	for address, e := range arp.entries {
+		if e.expired(now) {
+			continue
+		}
+		entry := ARPEntry{address, e.mac, ARPReachable, now.Sub(e.updated), e.expires}
+		if e.permanent() {
+			entry.State = ARPPermanent
+		}
+		entries = append(entries, entry)
+	}
+	arp.entriesLock.RUnlock()
+
+	arp.requestsLock.RLock()
+	for address, pending := range arp.requests {
+		entries = append(entries, ARPEntry{Address: address, State: ARPIncomplete, Age: now.Sub(pending.started)})
+	}
+	arp.requestsLock.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Address[:], entries[j].Address[:]) < 0
+	})
+	return entries
+}
+
+func (arp *defaultARP) AddEntry(address Address, mac ethernet.MAC, expiration time.Duration) {
+	arp.entriesLock.Lock()
+	defer arp.entriesLock.Unlock()
+
+	now := time.Now()
+	e := arpEntry{mac: mac, updated: now}
+	if expiration != 0 {
+		e.expires = now.Add(expiration)
+	}
+	arp.entries[address] = e
+}
+
+func (arp *defaultARP) DeleteEntry(address Address) error {
+	arp.entriesLock.Lock()
+	defer arp.entriesLock.Unlock()
+
+	if _, ok := arp.entries[address]; !ok {
+		return ErrARPEntryNotFound
+	}
+	delete(arp.entries, address)
+	return nil
+}
+
+func (arp *defaultARP) Flush() {
+	arp.removeEntries(func(Address) bool { return true })
+}
+
+// lookup returns the MAC address of an entry that has not expired.
+func (arp *defaultARP) lookup(address Address) (ethernet.MAC, bool) {
+	arp.entriesLock.RLock()
+	defer arp.entriesLock.RUnlock()
+
+	e, ok := arp.entries[address]
+	if !ok || e.expired(time.Now()) {
+		return ethernet.MAC{}, false
+	}
+	return e.mac, true
+}
+
+// learn adds a resolved address to the cache, unless it has a permanent
+// entry.
+func (arp *defaultARP) learn(address Address, mac ethernet.MAC) {
+	arp.entriesLock.Lock()
+	defer arp.entriesLock.Unlock()
+
+	if e, ok := arp.entries[address]; ok && e.permanent() {
+		return
+	}
+	now := time.Now()
+	arp.entries[address] = arpEntry{mac: mac, updated: now, expires: now.Add(arp.expiration)}
+}
+
+// update refreshes the entry of the sender of an ARP packet, if the sender
+// is in the cache, as described in RFC 826.
+func (arp *defaultARP) update(address Address, mac ethernet.MAC) {
+	arp.entriesLock.RLock()
+	e, ok := arp.entries[address]
+	arp.entriesLock.RUnlock()
+	if ok && !e.permanent() {
+		arp.learn(address, mac)
+	}
+}
+
+// announce sends a gratuitous ARP request for an address, which updates
+// the caches of the other hosts.
+func (arp *defaultARP) announce(address Address) {
+	req := NewARPRequest(arp.sourceMAC, address, address)
+	arp.eth.Send(ethernet.Packet{
+		Destination: ethernet.Broadcast,
+		EtherType:   ethernet.EtherTypeARP,
+		Payload:     common.PacketToBytes(req),
+	})
+}
+
+// source returns the local address used in requests for a target: the
+// address with the most specific network that contains the target, or the
+// primary address.
+func (arp *defaultARP) source(target Address) Address {
+	arp.addressesLock.RLock()
+	defer arp.addressesLock.RUnlock()
+
+	var source *InterfaceAddress
+	for i, a := range arp.addresses {
+		if a.Contains(target) && (source == nil || moreSpecific(a.Netmask, source.Netmask)) {
+			source = &arp.addresses[i]
+		}
+	}
+	if source != nil {
+		return source.Address
+	} else if len(arp.addresses) > 0 {
+		return arp.addresses[0].Address
+	}
+	return Address{}
+}
+
+// isLocal determines whether an address is a local address.
+func (arp *defaultARP) isLocal(address Address) bool {
+	arp.addressesLock.RLock()
+	defer arp.addressesLock.RUnlock()
+
+	for _, a := range arp.addresses {
+		if a.Address.Equals(address) {
+			return true
+		}
+	}
+	return false
+}
+
 func (arp *defaultARP) Resolve(address Address) (ethernet.MAC, error) {
-	item, _ := arp.cache.Get(address.String())
-	if item != nil {
-		return item.(ethernet.MAC), nil
+	if mac, ok := arp.lookup(address); ok {
+		return mac, nil
 	}
 	return arp.arpResolve(address)
 }
@@ -199,6 +548,7 @@ func (arp *defaultARP) arpResolve(address Address) (mac ethernet.MAC, err error)
 			pending = &pendingARPRequest{
 				gotReply: make(chan struct{}),
 				timeout:  make(chan struct{}),
+				started:  time.Now(),
 			}
 			arp.requests[address] = pending
 			go arp.sendARPRequestAndNotify(pending, address)
@@ -217,16 +567,15 @@ func (arp *defaultARP) arpResolve(address Address) (mac ethernet.MAC, err error)
 		arp.requestsLock.Unlock()
 	}
 
-	item, _ := arp.cache.Get(address.String())
-	if item == nil {
+	mac, ok = arp.lookup(address)
+	if !ok {
 		err = ErrARPTimeout
 	}
-	mac = item.(ethernet.MAC)
 	return
 }
 
 func (arp *defaultARP) sendARPRequestAndNotify(pending *pendingARPRequest, address Address) {
-	req := NewARPRequest(arp.sourceMAC, arp.sourceIP, address)
+	req := NewARPRequest(arp.sourceMAC, arp.source(address), address)
 	p := ethernet.Packet{
 		Destination: ethernet.Broadcast,
 		EtherType:   ethernet.EtherTypeARP,
@@ -252,11 +601,11 @@ send:
 }
 
 func (arp *defaultARP) handleRequest(request ARPPacket) {
-	if bytes.Equal(request.TargetProtocolAddress.Bytes(), arp.sourceIP.Bytes()) {
+	if arp.isLocal(request.TargetProtocolAddress) {
 		reply := NewARPReply(
 			arp.sourceMAC,
 			request.SenderHardwareAddress,
-			arp.sourceIP,
+			request.TargetProtocolAddress,
 			request.SenderProtocolAddress,
 		)
 		p := ethernet.Packet{
@@ -276,10 +625,7 @@ func (arp *defaultARP) handleReply(request ARPPacket) {
 	mac := request.SenderHardwareAddress
 	pending, ok := arp.requests[ip]
 	if ok {
-		arp.cache.Set(ip.String(), mac, cache.DefaultExpiration)
-		if pending.gotReply != nil {
-			close(pending.gotReply)
-			pending.gotReply = nil
-		}
+		arp.learn(ip, mac)
+		pending.replied.Do(func() { close(pending.gotReply) })
 	}
 }
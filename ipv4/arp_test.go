@@ -0,0 +1,184 @@
+package ipv4
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ethernet"
+)
+
+func TestARPConfigure(t *testing.T) {
+	eth := newTestEthernet()
+	mac := ethernet.MAC{0x02, 0, 0, 0, 0, 1}
+	arp := NewARP(mac, Address{10, 0, 0, 1}, eth)
+
+	next := func() ARPPacket {
+		select {
+		case frame := <-eth.out:
+			p, err := NewARPPacket(bytes.NewReader(frame.Payload))
+			assert.Nil(t, err)
+			assert.Equal(t, ethernet.Broadcast, frame.Destination)
+			return p
+		case <-time.After(5 * time.Second):
+			t.Fatal("No ARP packet sent")
+			return ARPPacket{}
+		}
+	}
+
+	// Resolve two hosts
+	for _, host := range []Address{{10, 0, 0, 2}, {10, 0, 1, 2}} {
+		resolved := make(chan ethernet.MAC)
+		go func() {
+			m, _ := arp.Resolve(host)
+			resolved <- m
+		}()
+		request := next()
+		assert.Equal(t, host, request.TargetProtocolAddress)
+		reply := NewARPReply(ethernet.MAC{0x02, 0, 0, 0, 0, host[2] + 2}, mac, host, Address{10, 0, 0, 1})
+		eth.in <- ethernet.Packet{EtherType: ethernet.EtherTypeARP, Payload: common.PacketToBytes(reply)}
+		assert.Equal(t, reply.SenderHardwareAddress, <-resolved)
+	}
+	assert.Equal(t, 2, len(arp.Entries()))
+
+	// The new address is announced, and the entry outside the new network
+	// is removed.
+	arp.Configure(Address{10, 0, 1, 1}, Address{255, 255, 255, 0})
+	announcement := next()
+	assert.Equal(t, Address{10, 0, 1, 1}, announcement.SenderProtocolAddress)
+	assert.Equal(t, Address{10, 0, 1, 1}, announcement.TargetProtocolAddress)
+	entries := arp.Entries()
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, Address{10, 0, 1, 2}, entries[0].Address)
+
+	// No announcement without a change
+	arp.Configure(Address{10, 0, 1, 1}, Address{255, 255, 0, 0})
+	select {
+	case <-eth.out:
+		t.Fatal("Unexpected ARP packet")
+	default:
+	}
+
+	// All entries are removed when the address is cleared.
+	arp.Configure(Address{}, Address{})
+	assert.Equal(t, 0, len(arp.Entries()))
+}
+
+func TestARPAddresses(t *testing.T) {
+	eth := newTestEthernet()
+	mac := ethernet.MAC{0x02, 0, 0, 0, 0, 1}
+	arp := NewARP(mac, Address{10, 0, 0, 1}, eth)
+	arp.AddAddress(Address{10, 0, 1, 1}, Address{255, 255, 255, 0})
+
+	next := func() (ethernet.Packet, ARPPacket) {
+		select {
+		case frame := <-eth.out:
+			p, err := NewARPPacket(bytes.NewReader(frame.Payload))
+			assert.Nil(t, err)
+			return frame, p
+		case <-time.After(5 * time.Second):
+			t.Fatal("No ARP packet sent")
+			return ethernet.Packet{}, ARPPacket{}
+		}
+	}
+
+	// The secondary address is announced.
+	_, announcement := next()
+	assert.Equal(t, Address{10, 0, 1, 1}, announcement.TargetProtocolAddress)
+
+	// Requests for both addresses are answered.
+	remote := ethernet.MAC{0x02, 0, 0, 0, 0, 2}
+	for _, local := range []Address{{10, 0, 0, 1}, {10, 0, 1, 1}} {
+		request := NewARPRequest(remote, Address{10, 0, 1, 2}, local)
+		eth.in <- ethernet.Packet{EtherType: ethernet.EtherTypeARP, Payload: common.PacketToBytes(request)}
+		frame, reply := next()
+		assert.Equal(t, remote, frame.Destination)
+		assert.Equal(t, ARPOperation(ARPReply), reply.Operation)
+		assert.Equal(t, local, reply.SenderProtocolAddress)
+	}
+
+	// Requests are sent from the address in the network of the target.
+	go arp.Resolve(Address{10, 0, 1, 3})
+	_, request := next()
+	assert.Equal(t, Address{10, 0, 1, 1}, request.SenderProtocolAddress)
+
+	// Requests for removed addresses are not answered.
+	arp.RemoveAddress(Address{10, 0, 1, 1})
+	for len(eth.out) > 0 {
+		<-eth.out
+	}
+	request = NewARPRequest(remote, Address{10, 0, 1, 2}, Address{10, 0, 1, 1})
+	eth.in <- ethernet.Packet{EtherType: ethernet.EtherTypeARP, Payload: common.PacketToBytes(request)}
+	select {
+	case frame := <-eth.out:
+		p, _ := NewARPPacket(bytes.NewReader(frame.Payload))
+		assert.NotEqual(t, ARPOperation(ARPReply), p.Operation)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestARPEntries(t *testing.T) {
+	eth := newTestEthernet()
+	mac := ethernet.MAC{0x02, 0, 0, 0, 0, 1}
+	arp := NewCustomARP(mac, Address{}, eth, time.Hour, 0, 200*time.Millisecond, 1)
+	arp.Configure(Address{10, 0, 0, 1}, Address{255, 255, 255, 0})
+	<-eth.out
+
+	gateway, host := Address{10, 0, 0, 254}, Address{10, 0, 0, 2}
+	gatewayMAC, hostMAC := ethernet.MAC{0x02, 0, 0, 0, 0, 0xFE}, ethernet.MAC{0x02, 0, 0, 0, 0, 2}
+	arp.AddEntry(gateway, gatewayMAC, 0)
+	arp.AddEntry(host, hostMAC, time.Hour)
+	assert.Equal(t, ErrARPEntryNotFound, arp.DeleteEntry(Address{10, 0, 0, 3}))
+
+	// Pending requests are incomplete entries.
+	failed := make(chan error)
+	go func() {
+		_, err := arp.Resolve(Address{10, 0, 0, 3})
+		failed <- err
+	}()
+	<-eth.out
+	entries := arp.Entries()
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, host, entries[0].Address)
+	assert.Equal(t, hostMAC, entries[0].MAC)
+	assert.Equal(t, ARPReachable, entries[0].State)
+	assert.True(t, entries[0].Expires.After(time.Now()))
+	assert.Equal(t, Address{10, 0, 0, 3}, entries[1].Address)
+	assert.Equal(t, ARPIncomplete, entries[1].State)
+	assert.Equal(t, gateway, entries[2].Address)
+	assert.Equal(t, ARPPermanent, entries[2].State)
+	assert.Equal(t, ErrARPTimeout, <-failed)
+
+	// Received ARP packets update the entries that are not permanent.
+	other := ethernet.MAC{0x02, 0, 0, 0, 0, 3}
+	for _, sender := range []Address{gateway, host} {
+		request := NewARPRequest(other, sender, Address{10, 0, 0, 1})
+		eth.in <- ethernet.Packet{EtherType: ethernet.EtherTypeARP, Payload: common.PacketToBytes(request)}
+		<-eth.out
+	}
+	resolved, err := arp.Resolve(gateway)
+	assert.Nil(t, err)
+	assert.Equal(t, gatewayMAC, resolved)
+	resolved, err = arp.Resolve(host)
+	assert.Nil(t, err)
+	assert.Equal(t, other, resolved)
+
+	// Permanent entries are kept when flushing and when the address
+	// changes.
+	arp.Flush()
+	arp.Configure(Address{10, 1, 0, 1}, Address{255, 255, 255, 0})
+	<-eth.out
+	entries = arp.Entries()
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, gateway, entries[0].Address)
+	assert.Nil(t, arp.DeleteEntry(gateway))
+	assert.Equal(t, 0, len(arp.Entries()))
+
+	// Entries expire.
+	arp.AddEntry(host, hostMAC, 50*time.Millisecond)
+	assert.Equal(t, 1, len(arp.Entries()))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, len(arp.Entries()))
+}
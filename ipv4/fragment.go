@@ -0,0 +1,100 @@
+package ipv4
+
+import "errors"
+
+const (
+	// FlagMoreFragments is set on all fragments except the last one.
+	FlagMoreFragments = 0x01
+
+	// FlagDontFragment forbids fragmenting the packet.
+	FlagDontFragment = 0x02
+)
+
+const (
+	// DefaultMTU is the default maximum transmission unit, which is the
+	// maximum payload size of an Ethernet frame.
+	DefaultMTU = 1500
+
+	// MinMTU is the smallest MTU every IPv4 link must support.
+	MinMTU = 68
+)
+
+var (
+	// ErrFragmentationNeeded is returned when sending a packet that is
+	// larger than the MTU and has the Don't Fragment flag set.
+	ErrFragmentationNeeded = errors.New("fragmentation needed and Don't Fragment flag set")
+)
+
+// Fragment splits a packet in fragments that fit in the MTU.
+//
+// The packet is returned as is if it fits. Fragments of a packet that
+// was already fragmented keep their offset relative to the original
+// packet. Only options with the copied flag are repeated in the
+// fragments after the first one.
+//
+// The checksums of the fragments are not calculated.
+//
+// See also ErrFragmentationNeeded.
+func (packet Packet) Fragment(mtu int) ([]Packet, error) {
+	headerLength := 20 + len(packet.Options)
+	if headerLength+len(packet.Payload) <= mtu {
+		return []Packet{packet}, nil
+	} else if packet.Flags&FlagDontFragment != 0 {
+		return nil, ErrFragmentationNeeded
+	}
+
+	var fragments []Packet
+	options := packet.Options
+	offset := int(packet.FragmentOffset) * 8
+	for payload := packet.Payload; len(payload) > 0; {
+		size := (mtu - 20 - len(options)) &^ 7
+		if size <= 0 {
+			return nil, ErrFragmentationNeeded
+		}
+
+		f := packet
+		f.Options = options
+		f.IHL = uint8(5 + len(options)/4)
+		f.FragmentOffset = uint16(offset / 8)
+		if size < len(payload) {
+			f.Flags |= FlagMoreFragments
+		} else {
+			size = len(payload)
+		}
+		f.Payload = payload[:size]
+		f.TotalLength = uint16(20 + len(options) + size)
+		fragments = append(fragments, f)
+
+		payload = payload[size:]
+		offset += size
+		options = copiedOptions(packet.Options)
+	}
+	return fragments, nil
+}
+
+// copiedOptions returns the options with the copied flag, padded to a
+// multiple of four bytes.
+func copiedOptions(options []byte) []byte {
+	var copied []byte
+	for o := options; len(o) > 0; {
+		switch o[0] {
+		case 0: // End of Option List
+			o = nil
+			continue
+		case 1: // No Operation
+			o = o[1:]
+			continue
+		}
+		if len(o) < 2 || int(o[1]) < 2 || int(o[1]) > len(o) {
+			break
+		}
+		if o[0]&0x80 != 0 {
+			copied = append(copied, o[:o[1]]...)
+		}
+		o = o[o[1]:]
+	}
+	for len(copied)%4 != 0 {
+		copied = append(copied, 0)
+	}
+	return copied
+}
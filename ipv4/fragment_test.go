@@ -0,0 +1,130 @@
+package ipv4
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/ethernet"
+)
+
+func TestFragment(t *testing.T) {
+	payload := make([]byte, 3000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	tests := []struct {
+		flags   uint8
+		offset  uint16
+		options []byte
+		mtu     int
+		sizes   []int
+		offsets []uint16
+		more    []bool
+		err     error
+	}{
+		{0, 0, nil, 3020, []int{3000}, []uint16{0}, []bool{false}, nil},
+		{0, 0, nil, 1500, []int{1480, 1480, 40}, []uint16{0, 185, 370}, []bool{true, true, false}, nil},
+		{0, 0, nil, 1000, []int{976, 976, 976, 72}, []uint16{0, 122, 244, 366}, []bool{true, true, true, false}, nil},
+		{FlagDontFragment, 0, nil, 1500, nil, nil, nil, ErrFragmentationNeeded},
+		{FlagMoreFragments, 100, nil, 1500, []int{1480, 1480, 40}, []uint16{100, 285, 470}, []bool{true, true, true}, nil},
+		// The copied security option is repeated, the timestamp option is not.
+		{0, 0, []byte{0x82, 4, 0, 0, 0x44, 4, 5, 0}, 1500, []int{1472, 1472, 56}, []uint16{0, 184, 368}, []bool{true, true, false}, nil},
+	}
+	for i, test := range tests {
+		p := NewPacketTo(Address{10, 0, 0, 2}, ProtocolUDP, payload)
+		p.Flags = test.flags
+		p.FragmentOffset = test.offset
+		p.Options = test.options
+		p.IHL = uint8(5 + len(test.options)/4)
+		p.TotalLength += uint16(len(test.options))
+
+		fragments, err := p.Fragment(test.mtu)
+		assert.Equal(t, test.err, err, "Test %d", i)
+		assert.Equal(t, len(test.sizes), len(fragments), "Test %d", i)
+
+		var reassembled []byte
+		for j, f := range fragments {
+			assert.Equal(t, test.sizes[j], len(f.Payload), "Test %d, fragment %d", i, j)
+			assert.Equal(t, test.offsets[j], f.FragmentOffset, "Test %d, fragment %d", i, j)
+			assert.Equal(t, test.more[j], f.Flags&FlagMoreFragments != 0, "Test %d, fragment %d", i, j)
+			assert.Equal(t, p.Identification, f.Identification)
+			assert.True(t, int(f.TotalLength) <= test.mtu)
+			assert.Equal(t, int(f.TotalLength), 20+len(f.Options)+len(f.Payload))
+
+			f.Checksum = f.CalculateChecksum()
+			assert.Nil(t, f.Check(), "Test %d, fragment %d", i, j)
+			if j > 0 && test.options != nil {
+				assert.Equal(t, []byte{0x82, 4, 0, 0}, f.Options)
+			}
+			reassembled = append(reassembled, f.Payload...)
+		}
+		if err == nil {
+			assert.Equal(t, payload, reassembled, "Test %d", i)
+		}
+	}
+}
+
+type staticRouter ethernet.MAC
+
+func (r staticRouter) Resolve(address Address) (ethernet.MAC, error) {
+	return ethernet.MAC(r), nil
+}
+
+func (r staticRouter) Route(address Address) (NextHop, error) {
+	return NextHop{Address: address}, nil
+}
+
+func (r staticRouter) Configure(address, netmask Address, gateway *Address) {}
+
+func (r staticRouter) AddRoute(route Route) error { return nil }
+
+func (r staticRouter) DeleteRoute(route Route) error { return nil }
+
+func (r staticRouter) Routes() []Route { return nil }
+
+// testEthernet is an Ethernet layer that only receives IPv4 packets.
+type testEthernet struct {
+	in  chan ethernet.Packet
+	out chan ethernet.Packet
+}
+
+func newTestEthernet() *testEthernet {
+	return &testEthernet{make(chan ethernet.Packet), make(chan ethernet.Packet, 16)}
+}
+
+func (e *testEthernet) Packets(t ethernet.EtherType) <-chan ethernet.Packet {
+	return e.in
+}
+
+func (e *testEthernet) Send(p ethernet.Packet) error {
+	e.out <- p
+	return nil
+}
+
+func TestLayerFragments(t *testing.T) {
+	eth := newTestEthernet()
+	l := NewCustomLayer(
+		Address{10, 0, 0, 1}, staticRouter{0x02, 0, 0, 0, 0, 2}, eth, 576,
+		DefaultReassemblyTimeout, DefaultReassemblyMemory,
+	)
+
+	p := NewPacketTo(Address{10, 0, 0, 2}, ProtocolUDP, make([]byte, 1000))
+	assert.Nil(t, l.Send(p))
+	for _, size := range []int{572, 468} {
+		select {
+		case frame := <-eth.out:
+			assert.Equal(t, size, len(frame.Payload))
+			f, err := NewPacket(bytes.NewReader(frame.Payload))
+			assert.Nil(t, err)
+			assert.Equal(t, Address{10, 0, 0, 1}, f.Source)
+		case <-time.After(time.Second):
+			t.Fatal("No fragment sent")
+		}
+	}
+
+	p.Flags = FlagDontFragment
+	assert.Equal(t, ErrFragmentationNeeded, l.Send(p))
+}
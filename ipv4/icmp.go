@@ -0,0 +1,114 @@
+package ipv4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/unigornel/go-tcpip/common"
+)
+
+// The ICMP error messages sent by the IPv4 layer. The icmp package cannot
+// be used, as it depends on this package.
+const (
+	icmpTypeDestinationUnreachable = 3
+	icmpTypeTimeExceeded           = 11
+
+	icmpCodeNetUnreachable         = 0
+	icmpCodeHostUnreachable        = 1
+	icmpCodeProtocolUnreachable    = 2
+	icmpCodeFragmentationNeeded    = 4
+	icmpCodeTTLExceeded            = 0
+	icmpCodeReassemblyTimeExceeded = 1
+)
+
+const (
+	// icmpErrorRate is the number of ICMP errors per second that a layer
+	// sends.
+	icmpErrorRate = 100
+
+	// icmpErrorBurst is the number of ICMP errors that a layer can send at
+	// once.
+	icmpErrorBurst = 10
+
+	// icmpErrorQueueLength is the number of ICMP errors that are queued
+	// for sending. Errors are dropped when the queue is full.
+	icmpErrorQueueLength = 64
+)
+
+// rateLimiter is a token bucket that limits the rate of ICMP errors, as
+// suggested in RFC 1812 section 4.3.2.8.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// allow takes a token from the bucket, if there is one.
+func (l *rateLimiter) allow() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// newICMPError creates an ICMP error message about a packet. The message
+// contains the header and the first eight bytes of the payload of the
+// packet. The source address of the message is left unspecified.
+func newICMPError(t, code uint8, rest uint32, p Packet) Packet {
+	b := bytes.NewBuffer(nil)
+	b.Write([]byte{t, code, 0, 0})
+	binary.Write(b, binary.BigEndian, rest)
+	p.Header.Write(b)
+	if len(p.Payload) > 8 {
+		b.Write(p.Payload[:8])
+	} else {
+		b.Write(p.Payload)
+	}
+
+	message := b.Bytes()
+	binary.BigEndian.PutUint16(message[2:], common.Checksum(message))
+	return NewPacketTo(p.Source, ProtocolICMP, message)
+}
+
+// ICMPErrorAllowed determines whether an ICMP error message may be sent
+// about a packet, as described in RFC 1122 section 3.2.2.
+//
+// No errors are sent about ICMP error messages, fragments other than the
+// first one, and packets from or to broadcast and multicast addresses.
+func ICMPErrorAllowed(p Packet) bool {
+	if p.Protocol == ProtocolICMP && len(p.Payload) > 0 {
+		switch p.Payload[0] {
+		case 0, 8, 13, 14, 15, 16, 17, 18:
+			// Queries and replies
+		default:
+			return false
+		}
+	}
+	if p.FragmentOffset != 0 {
+		return false
+	}
+	return !p.Destination.Equals(Broadcast) && !p.Destination.IsMulticast() &&
+		!p.Source.Equals(Address{}) && !p.Source.Equals(Broadcast) && !p.Source.IsMulticast()
+}
@@ -0,0 +1,265 @@
+package ipv4
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/unigornel/go-tcpip/ethernet"
+)
+
+var (
+	// ErrAddressNotFound is returned when removing an address that does
+	// not belong to an interface.
+	ErrAddressNotFound = errors.New("address not found")
+
+	// ErrNotMulticast is returned when joining a group with an address
+	// that is not a multicast address.
+	ErrNotMulticast = errors.New("not a multicast address")
+
+	// ErrGroupNotJoined is returned when leaving a multicast group that
+	// was not joined.
+	ErrGroupNotJoined = errors.New("multicast group not joined")
+)
+
+// InterfaceAddress is an address of an interface and the netmask of its
+// network.
+type InterfaceAddress struct {
+	Address Address
+	Netmask Address
+}
+
+// Contains determines whether an address is in the network of the
+// interface address.
+func (a InterfaceAddress) Contains(b Address) bool {
+	return a.Address.And(a.Netmask).Equals(b.And(a.Netmask))
+}
+
+// IsBroadcast determines whether an address is the directed broadcast
+// address of the network of the interface address. Networks with a prefix
+// longer than 30 bits have no broadcast address.
+func (a InterfaceAddress) IsBroadcast(b Address) bool {
+	if prefixLength(a.Netmask) > 30 {
+		return false
+	}
+	for i := range b {
+		if b[i] != a.Address[i]|^a.Netmask[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (a InterfaceAddress) String() string {
+	return a.Address.String() + "/" + a.Netmask.String()
+}
+
+// Interface is a network interface of an IPv4 layer.
+type Interface interface {
+	Name() string
+	Ethernet() ethernet.Layer
+
+	// ARP returns the ARP layer of the interface. It is nil for the
+	// interface of layers created by NewLayer, whose router resolves the
+	// addresses.
+	ARP() ARP
+
+	MTU() int
+
+	// Addresses returns the addresses of the interface. The first address
+	// is the primary address.
+	Addresses() []InterfaceAddress
+
+	// AddAddress adds an address to the interface, or changes the netmask
+	// of an existing address.
+	AddAddress(address, netmask Address)
+
+	// RemoveAddress removes an address from the interface.
+	//
+	// See also ErrAddressNotFound.
+	RemoveAddress(address Address) error
+
+	// Configure replaces the addresses of the interface with a single
+	// address. A zero address removes all addresses.
+	Configure(address, netmask Address)
+
+	// JoinGroup makes the interface receive the packets to a multicast
+	// group. Joining a group twice has no effect.
+	//
+	// See also ErrNotMulticast.
+	JoinGroup(group Address) error
+
+	// LeaveGroup stops receiving the packets to a multicast group.
+	//
+	// See also ErrGroupNotJoined.
+	LeaveGroup(group Address) error
+
+	// Groups returns the multicast groups that were joined. The interface
+	// is also a member of AllHosts, which cannot be left.
+	Groups() []Address
+}
+
+type netInterface struct {
+	name string
+	eth  ethernet.Layer
+	arp  ARP
+	mtu  int
+
+	lock      sync.RWMutex
+	addresses []InterfaceAddress
+	groups    []Address
+}
+
+// NewInterface creates an interface without addresses. The addresses of
+// the interface are also configured on its ARP layer, and the multicast
+// groups on its Ethernet layer if it is an ethernet.MulticastLayer.
+func NewInterface(name string, eth ethernet.Layer, arp ARP, mtu int) Interface {
+	if mtu < MinMTU {
+		mtu = MinMTU
+	}
+	if m, ok := eth.(ethernet.MulticastLayer); ok {
+		m.JoinMulticast(MulticastMAC(AllHosts))
+	}
+	return &netInterface{
+		name: name,
+		eth:  eth,
+		arp:  arp,
+		mtu:  mtu,
+	}
+}
+
+func (i *netInterface) Name() string {
+	return i.name
+}
+
+func (i *netInterface) Ethernet() ethernet.Layer {
+	return i.eth
+}
+
+func (i *netInterface) ARP() ARP {
+	return i.arp
+}
+
+func (i *netInterface) MTU() int {
+	return i.mtu
+}
+
+func (i *netInterface) Addresses() []InterfaceAddress {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return append([]InterfaceAddress(nil), i.addresses...)
+}
+
+func (i *netInterface) AddAddress(address, netmask Address) {
+	i.lock.Lock()
+	found := false
+	for j, a := range i.addresses {
+		if a.Address.Equals(address) {
+			i.addresses[j].Netmask = netmask
+			found = true
+		}
+	}
+	if !found {
+		i.addresses = append(i.addresses, InterfaceAddress{address, netmask})
+	}
+	i.lock.Unlock()
+
+	if i.arp != nil {
+		i.arp.AddAddress(address, netmask)
+	}
+}
+
+func (i *netInterface) RemoveAddress(address Address) error {
+	i.lock.Lock()
+	found := false
+	for j, a := range i.addresses {
+		if a.Address.Equals(address) {
+			i.addresses = append(i.addresses[:j], i.addresses[j+1:]...)
+			found = true
+			break
+		}
+	}
+	i.lock.Unlock()
+
+	if !found {
+		return ErrAddressNotFound
+	}
+	if i.arp != nil {
+		i.arp.RemoveAddress(address)
+	}
+	return nil
+}
+
+func (i *netInterface) Configure(address, netmask Address) {
+	i.lock.Lock()
+	i.addresses = nil
+	if !address.Equals(Address{}) {
+		i.addresses = []InterfaceAddress{{address, netmask}}
+	}
+	i.lock.Unlock()
+
+	if i.arp != nil {
+		i.arp.Configure(address, netmask)
+	}
+}
+
+func (i *netInterface) JoinGroup(group Address) error {
+	if !group.IsMulticast() {
+		return ErrNotMulticast
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	for _, g := range i.groups {
+		if g.Equals(group) {
+			return nil
+		}
+	}
+	i.groups = append(i.groups, group)
+	if m, ok := i.eth.(ethernet.MulticastLayer); ok {
+		m.JoinMulticast(MulticastMAC(group))
+	}
+	return nil
+}
+
+func (i *netInterface) LeaveGroup(group Address) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	for j, g := range i.groups {
+		if g.Equals(group) {
+			i.groups = append(i.groups[:j], i.groups[j+1:]...)
+			if m, ok := i.eth.(ethernet.MulticastLayer); ok {
+				m.LeaveMulticast(MulticastMAC(group))
+			}
+			return nil
+		}
+	}
+	return ErrGroupNotJoined
+}
+
+func (i *netInterface) Groups() []Address {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return append([]Address(nil), i.groups...)
+}
+
+// isMember determines whether an interface receives the packets to a
+// multicast group.
+func isMember(i Interface, group Address) bool {
+	if group.Equals(AllHosts) {
+		return true
+	}
+	for _, g := range i.Groups() {
+		if g.Equals(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// primary returns the primary address of an interface, or the zero
+// address if it has no addresses.
+func primary(i Interface) InterfaceAddress {
+	if addresses := i.Addresses(); len(addresses) > 0 {
+		return addresses[0]
+	}
+	return InterfaceAddress{}
+}
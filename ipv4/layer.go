@@ -2,6 +2,8 @@ package ipv4
 
 import (
 	"bytes"
+	"sync"
+	"time"
 
 	"github.com/unigornel/go-tcpip/common"
 	"github.com/unigornel/go-tcpip/ethernet"
@@ -10,29 +12,115 @@ import (
 // Layer is an IPv4 layer.
 type Layer interface {
 	Packets(p Protocol) <-chan Packet
+
+	// Send sends a packet, and fragments it if it is larger than the MTU
+	// of the interface. An unspecified source address is replaced by the
+	// source address of the route.
+	//
+	// See also ErrFragmentationNeeded.
 	Send(t Packet) error
+
+	// Address returns the primary address of the first interface.
+	Address() Address
+
+	// SetAddress changes the primary address of the first interface.
+	SetAddress(address Address)
+
+	// HasAddress determines whether an address belongs to one of the
+	// interfaces.
+	HasAddress(address Address) bool
+
+	// Source returns the source address of the packets to a destination.
+	//
+	// See also ErrNoRouteToDestinationAddress.
+	Source(destination Address) (Address, error)
+
+	// Interfaces returns the interfaces of the layer.
+	Interfaces() []Interface
+
+	// SetForwarding enables or disables forwarding. A forwarding layer
+	// routes the unicast packets it receives for other destinations, and
+	// sends ICMP errors when they cannot be forwarded.
+	SetForwarding(enabled bool)
+
+	// Forwarding determines whether the layer forwards packets.
+	Forwarding() bool
+
+	// SendICMPError sends an ICMP error message generated by an upper
+	// layer, such as a port unreachable message. The errors of the layer
+	// share a rate limit and are sent in order from a bounded queue. Errors
+	// above the rate limit or that do not fit in the queue are dropped.
+	SendICMPError(m Packet)
 }
 
 type layer struct {
-	address  Address
-	router   Router
-	eth      ethernet.Layer
-	channels map[Protocol]chan Packet
+	router      Router
+	interfaces  []Interface
+	reassembler *reassembler
+
+	channelsLock sync.RWMutex
+	channels     map[Protocol]chan Packet
+
+	forwardingLock sync.RWMutex
+	forwarding     bool
+
+	errorLimiter *rateLimiter
+	errors       chan Packet
 }
 
+// DefaultInterfaceName is the name of the interface of layers created by
+// NewLayer and NewCustomLayer.
+const DefaultInterfaceName = "eth0"
+
+// forwardingQueueLength is the number of packets of an interface that are
+// queued for forwarding. Packets are dropped when the queue is full.
+const forwardingQueueLength = 256
+
 // NewLayer creates a new instance of the default IPv4 layer.
 func NewLayer(address Address, router Router, eth ethernet.Layer) Layer {
+	return NewCustomLayer(address, router, eth, DefaultMTU, DefaultReassemblyTimeout, DefaultReassemblyMemory)
+}
+
+// NewCustomLayer creates an IPv4 layer with a custom configuration.
+//
+// Packets larger than the MTU are fragmented. Received fragments are
+// reassembled, using at most reassemblyMemory bytes for incomplete
+// datagrams. Incomplete datagrams are dropped after reassemblyTimeout,
+// and an ICMP Time Exceeded message is sent to their source.
+//
+// The layer has a single interface, and the router resolves the addresses
+// on it.
+func NewCustomLayer(address Address, router Router, eth ethernet.Layer, mtu int, reassemblyTimeout time.Duration, reassemblyMemory int) Layer {
+	i := NewInterface(DefaultInterfaceName, eth, nil, mtu)
+	if !address.Equals(Address{}) {
+		i.AddAddress(address, Broadcast)
+	}
+	return NewInterfaceLayer(router, []Interface{i}, reassemblyTimeout, reassemblyMemory)
+}
+
+// NewInterfaceLayer creates an IPv4 layer that receives packets from
+// several interfaces. The router selects the interface of the packets
+// that are sent, usually a router created by NewInterfaceRouter.
+func NewInterfaceLayer(router Router, interfaces []Interface, reassemblyTimeout time.Duration, reassemblyMemory int) Layer {
 	l := &layer{
-		address:  address,
-		router:   router,
-		eth:      eth,
-		channels: make(map[Protocol]chan Packet),
+		router:       router,
+		interfaces:   interfaces,
+		channels:     make(map[Protocol]chan Packet),
+		errorLimiter: newRateLimiter(icmpErrorRate, icmpErrorBurst),
+		errors:       make(chan Packet, icmpErrorQueueLength),
+	}
+	go l.sendErrors()
+	l.reassembler = newReassembler(reassemblyTimeout, reassemblyMemory, l.reassemblyTimeExceeded)
+	for _, i := range interfaces {
+		go l.run(i, i.Ethernet().Packets(ethernet.EtherTypeIPv4))
 	}
-	go l.run()
 	return l
 }
 
 func (layer *layer) Packets(t Protocol) <-chan Packet {
+	layer.channelsLock.Lock()
+	defer layer.channelsLock.Unlock()
+
 	c, ok := layer.channels[t]
 	if !ok {
 		c = make(chan Packet)
@@ -41,32 +129,261 @@ func (layer *layer) Packets(t Protocol) <-chan Packet {
 	return c
 }
 
+func (layer *layer) Address() Address {
+	if len(layer.interfaces) == 0 {
+		return Address{}
+	}
+	return primary(layer.interfaces[0]).Address
+}
+
+func (layer *layer) SetAddress(address Address) {
+	if len(layer.interfaces) == 0 {
+		return
+	}
+	i := layer.interfaces[0]
+	i.Configure(address, primary(i).Netmask)
+}
+
+func (layer *layer) HasAddress(address Address) bool {
+	for _, i := range layer.interfaces {
+		for _, a := range i.Addresses() {
+			if a.Address.Equals(address) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (layer *layer) Interfaces() []Interface {
+	return append([]Interface(nil), layer.interfaces...)
+}
+
+func (layer *layer) SetForwarding(enabled bool) {
+	layer.forwardingLock.Lock()
+	defer layer.forwardingLock.Unlock()
+	layer.forwarding = enabled
+}
+
+func (layer *layer) Forwarding() bool {
+	layer.forwardingLock.RLock()
+	defer layer.forwardingLock.RUnlock()
+	return layer.forwarding
+}
+
+func (layer *layer) Source(destination Address) (Address, error) {
+	hop, err := layer.route(destination)
+	return hop.Source, err
+}
+
+// route looks up the route to a destination, and fills in the interface
+// and source address for routers that do not know the interfaces.
+func (layer *layer) route(destination Address) (NextHop, error) {
+	hop, err := layer.router.Route(destination)
+	if err != nil {
+		return hop, err
+	}
+	if hop.Interface == nil {
+		if len(layer.interfaces) == 0 {
+			return hop, ErrNoRouteToDestinationAddress
+		}
+		hop.Interface = layer.interfaces[0]
+		hop.Source = primary(hop.Interface).Address
+	}
+	return hop, nil
+}
+
 func (layer *layer) Send(t Packet) error {
+	hop, err := layer.route(t.Destination)
+	if err != nil {
+		return err
+	}
 	mac, err := layer.router.Resolve(t.Destination)
 	if err != nil {
 		return err
 	}
 
-	t.Source = layer.address
-	t.Checksum = t.CalculateChecksum()
-	frame := ethernet.Packet{
-		Destination: mac,
-		EtherType:   ethernet.EtherTypeIPv4,
-		Payload:     common.PacketToBytes(t),
+	if t.Source.Equals(Address{}) {
+		t.Source = hop.Source
+	}
+	return layer.transmit(t, hop.Interface, mac)
+}
+
+// transmit fragments a packet to the MTU of an interface, and sends the
+// fragments to a MAC address.
+func (layer *layer) transmit(t Packet, i Interface, mac ethernet.MAC) error {
+	fragments, err := t.Fragment(i.MTU())
+	if err != nil {
+		return err
+	}
+	for _, f := range fragments {
+		f.Checksum = f.CalculateChecksum()
+		frame := ethernet.Packet{
+			Destination: mac,
+			EtherType:   ethernet.EtherTypeIPv4,
+			Payload:     common.PacketToBytes(f),
+		}
+		if err := i.Ethernet().Send(frame); err != nil {
+			return err
+		}
 	}
-	return layer.eth.Send(frame)
+	return nil
 }
 
-func (layer *layer) run() {
-	for frame := range layer.eth.Packets(ethernet.EtherTypeIPv4) {
+// run receives the packets of an interface. Packets to other hosts are
+// forwarded or dropped.
+//
+// The packets to forward are queued and forwarded in order by a single
+// goroutine, so that resolving a next hop does not block the interface.
+func (layer *layer) run(i Interface, frames <-chan ethernet.Packet) {
+	queue := make(chan Packet, forwardingQueueLength)
+	defer close(queue)
+	go layer.forwardAll(queue)
+
+	for frame := range frames {
 		p, err := NewPacket(bytes.NewBuffer(frame.Payload))
 		if err != nil {
 			continue
 		}
+		if !layer.accepts(i, p.Destination) {
+			if layer.Forwarding() && layer.forwardable(frame, p) {
+				select {
+				case queue <- p:
+				default:
+				}
+			}
+			continue
+		}
+		if p.Flags&FlagMoreFragments != 0 || p.FragmentOffset != 0 {
+			var ok bool
+			if p, ok = layer.reassembler.add(p); !ok {
+				continue
+			}
+		}
+
+		p.Interface = i
 
+		layer.channelsLock.RLock()
 		c := layer.channels[p.Protocol]
+		layer.channelsLock.RUnlock()
 		if c != nil {
 			c <- p
+		} else if layer.HasAddress(p.Destination) && ICMPErrorAllowed(p) {
+			m := newICMPError(icmpTypeDestinationUnreachable, icmpCodeProtocolUnreachable, 0, p)
+			m.Source = p.Destination
+			layer.SendICMPError(m)
 		}
 	}
 }
+
+// accepts determines whether an interface receives the packets to a
+// destination, which is either an address of the layer, the limited
+// broadcast address, the directed broadcast address of a network of the
+// interface, or a multicast group the interface is a member of.
+func (layer *layer) accepts(i Interface, destination Address) bool {
+	if destination.Equals(Broadcast) || layer.HasAddress(destination) {
+		return true
+	} else if destination.IsMulticast() {
+		return isMember(i, destination)
+	}
+	for _, a := range i.Addresses() {
+		if a.IsBroadcast(destination) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardable determines whether a packet to another host is routed to its
+// destination. Packets in broadcast or multicast frames, and packets from
+// or to broadcast, multicast and unspecified addresses are not forwarded,
+// nor are directed broadcasts to the networks of the layer.
+func (layer *layer) forwardable(frame ethernet.Packet, p Packet) bool {
+	if frame.Destination[0]&1 != 0 {
+		return false
+	}
+	for _, a := range []Address{p.Source, p.Destination} {
+		if a.Equals(Address{}) || a.Equals(Broadcast) || a.IsMulticast() {
+			return false
+		}
+	}
+	for _, i := range layer.interfaces {
+		for _, a := range i.Addresses() {
+			if a.IsBroadcast(p.Destination) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// forwardAll forwards the packets of a queue.
+func (layer *layer) forwardAll(queue <-chan Packet) {
+	for p := range queue {
+		layer.forward(p)
+	}
+}
+
+// forward decrements the time to live of a packet and sends it to the next
+// hop, fragmenting it to the MTU of the outgoing interface. The header
+// checksum is recomputed for every fragment.
+//
+// An ICMP error is sent to the source when the time to live expires, when
+// there is no route or the next hop cannot be resolved, and when the packet
+// must be fragmented but the Don't Fragment flag is set.
+func (layer *layer) forward(p Packet) {
+	if p.TTL <= 1 {
+		layer.forwardingError(icmpTypeTimeExceeded, icmpCodeTTLExceeded, 0, p)
+		return
+	}
+	hop, err := layer.route(p.Destination)
+	if err != nil {
+		layer.forwardingError(icmpTypeDestinationUnreachable, icmpCodeNetUnreachable, 0, p)
+		return
+	}
+	mac, err := layer.router.Resolve(p.Destination)
+	if err != nil {
+		layer.forwardingError(icmpTypeDestinationUnreachable, icmpCodeHostUnreachable, 0, p)
+		return
+	}
+
+	t := p
+	t.TTL--
+	if err := layer.transmit(t, hop.Interface, mac); err == ErrFragmentationNeeded {
+		layer.forwardingError(icmpTypeDestinationUnreachable, icmpCodeFragmentationNeeded, uint32(hop.Interface.MTU()), p)
+	}
+}
+
+// forwardingError sends an ICMP error about a packet that could not be
+// forwarded. The error is sent from the address of the route to the source
+// of the packet.
+func (layer *layer) forwardingError(t, code uint8, rest uint32, p Packet) {
+	if ICMPErrorAllowed(p) {
+		layer.SendICMPError(newICMPError(t, code, rest, p))
+	}
+}
+
+func (layer *layer) reassemblyTimeExceeded(first Packet) {
+	if layer.HasAddress(first.Destination) && ICMPErrorAllowed(first) {
+		m := newICMPError(icmpTypeTimeExceeded, icmpCodeReassemblyTimeExceeded, 0, first)
+		m.Source = first.Destination
+		layer.SendICMPError(m)
+	}
+}
+
+func (layer *layer) SendICMPError(m Packet) {
+	if !layer.errorLimiter.allow() {
+		return
+	}
+	select {
+	case layer.errors <- m:
+	default:
+	}
+}
+
+// sendErrors sends the queued ICMP errors.
+func (layer *layer) sendErrors() {
+	for m := range layer.errors {
+		layer.Send(m)
+	}
+}
@@ -0,0 +1,223 @@
+package ipv4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ethernet"
+)
+
+// resolvingRouter resolves every address to the same MAC address.
+type resolvingRouter struct {
+	Router
+	mac ethernet.MAC
+}
+
+func (r resolvingRouter) Resolve(address Address) (ethernet.MAC, error) {
+	if _, err := r.Route(address); err != nil {
+		return ethernet.MAC{}, err
+	}
+	return r.mac, nil
+}
+
+func TestForwarding(t *testing.T) {
+	eth0, eth1 := newTestEthernet(), newTestEthernet()
+	i0 := NewInterface("eth0", eth0, nil, DefaultMTU)
+	i0.AddAddress(Address{10, 0, 0, 1}, Address{255, 255, 255, 0})
+	i1 := NewInterface("eth1", eth1, nil, 576)
+	i1.AddAddress(Address{10, 0, 1, 1}, Address{255, 255, 255, 0})
+	router := resolvingRouter{NewInterfaceRouter([]Interface{i0, i1}, nil), ethernet.MAC{0x02, 0, 0, 0, 0, 2}}
+	l := NewInterfaceLayer(router, []Interface{i0, i1}, DefaultReassemblyTimeout, DefaultReassemblyMemory)
+
+	host := Address{10, 0, 0, 2}
+	send := func(destination Address, ttl, flags uint8, size int) Packet {
+		p := NewPacketTo(destination, 253, make([]byte, size))
+		p.Source = host
+		p.TTL = ttl
+		p.Flags = flags
+		p.Checksum = p.CalculateChecksum()
+		eth0.in <- ethernet.Packet{EtherType: ethernet.EtherTypeIPv4, Payload: common.PacketToBytes(p)}
+		return p
+	}
+	receive := func(eth *testEthernet) Packet {
+		select {
+		case frame := <-eth.out:
+			p, err := NewPacket(bytes.NewReader(frame.Payload))
+			assert.Nil(t, err)
+			assert.Nil(t, p.Check())
+			return p
+		case <-time.After(time.Second):
+			t.Fatal("No packet sent")
+			return Packet{}
+		}
+	}
+	icmpError := func(p Packet, code uint8) []byte {
+		q := receive(eth0)
+		assert.Equal(t, Address{10, 0, 0, 1}, q.Source)
+		assert.Equal(t, host, q.Destination)
+		assert.Equal(t, ProtocolICMP, int(q.Protocol))
+		assert.Equal(t, code, q.Payload[1])
+		h, err := NewHeader(bytes.NewReader(q.Payload[8:]))
+		assert.Nil(t, err)
+		assert.Equal(t, p.Identification, h.Identification)
+		return q.Payload
+	}
+
+	// Disabled
+	assert.False(t, l.Forwarding())
+	send(Address{10, 0, 1, 2}, 64, 0, 100)
+	select {
+	case <-eth1.out:
+		t.Fatal("Packet forwarded")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.SetForwarding(true)
+	assert.True(t, l.Forwarding())
+
+	// Forwarded
+	p := send(Address{10, 0, 1, 2}, 64, 0, 100)
+	q := receive(eth1)
+	assert.Equal(t, host, q.Source)
+	assert.Equal(t, p.Identification, q.Identification)
+	assert.Equal(t, uint8(63), q.TTL)
+	assert.Equal(t, p.Payload, q.Payload)
+
+	// Fragmented
+	send(Address{10, 0, 1, 2}, 64, 0, 1000)
+	assert.Equal(t, 552, len(receive(eth1).Payload))
+	assert.Equal(t, 448, len(receive(eth1).Payload))
+
+	// Time to live expired
+	p = send(Address{10, 0, 1, 2}, 1, 0, 100)
+	m := icmpError(p, icmpCodeTTLExceeded)
+	assert.Equal(t, uint8(icmpTypeTimeExceeded), m[0])
+
+	// Fragmentation needed
+	p = send(Address{10, 0, 1, 2}, 64, FlagDontFragment, 1000)
+	m = icmpError(p, icmpCodeFragmentationNeeded)
+	assert.Equal(t, uint8(icmpTypeDestinationUnreachable), m[0])
+	assert.Equal(t, uint16(576), binary.BigEndian.Uint16(m[6:]))
+
+	// No route
+	p = send(Address{192, 168, 0, 1}, 64, 0, 100)
+	m = icmpError(p, icmpCodeNetUnreachable)
+	assert.Equal(t, uint8(icmpTypeDestinationUnreachable), m[0])
+
+	// The rate of errors is limited.
+	go func() {
+		for n := 0; n < 5*icmpErrorBurst; n++ {
+			send(Address{192, 168, 0, 1}, 64, 0, 100)
+		}
+	}()
+	errors := 0
+	for {
+		select {
+		case <-eth0.out:
+			errors++
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	assert.True(t, errors > 0 && errors < 5*icmpErrorBurst, "%v errors", errors)
+}
+
+func TestReceive(t *testing.T) {
+	eth := newTestEthernet()
+	i := NewInterface("eth0", eth, nil, DefaultMTU)
+	i.AddAddress(Address{10, 0, 0, 1}, Address{255, 255, 255, 0})
+	l := NewInterfaceLayer(staticRouter{}, []Interface{i}, DefaultReassemblyTimeout, DefaultReassemblyMemory)
+	packets := l.Packets(253)
+
+	group := Address{239, 1, 1, 1}
+	assert.Equal(t, ErrNotMulticast, i.JoinGroup(Address{10, 0, 0, 2}))
+	assert.Equal(t, ErrGroupNotJoined, i.LeaveGroup(group))
+
+	tests := []struct {
+		destination Address
+		join        bool
+		received    bool
+	}{
+		{Address{10, 0, 0, 1}, false, true},
+		{Address{10, 0, 0, 255}, false, true},
+		{Broadcast, false, true},
+		{AllHosts, false, true},
+		{Address{10, 0, 0, 2}, false, false},
+		{Address{10, 0, 1, 255}, false, false},
+		{group, false, false},
+		{group, true, true},
+	}
+	for _, test := range tests {
+		if test.join {
+			assert.Nil(t, i.JoinGroup(group))
+			assert.Equal(t, []Address{group}, i.Groups())
+		}
+
+		// A packet to the local address follows the packet, so that it is
+		// received first if the packet is dropped.
+		go func(destination Address) {
+			for n, d := range []Address{destination, {10, 0, 0, 1}} {
+				p := NewPacketTo(d, 253, []byte{byte(n)})
+				p.Source = Address{10, 0, 0, 2}
+				p.Checksum = p.CalculateChecksum()
+				eth.in <- ethernet.Packet{EtherType: ethernet.EtherTypeIPv4, Payload: common.PacketToBytes(p)}
+			}
+		}(test.destination)
+		for n := 0; n < 2; n++ {
+			select {
+			case p := <-packets:
+				if n == 0 && !test.received {
+					assert.Equal(t, []byte{1}, p.Payload, "%v", test.destination)
+					n++
+				} else {
+					assert.Equal(t, []byte{byte(n)}, p.Payload, "%v", test.destination)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("No packet received")
+			}
+		}
+	}
+
+	assert.Nil(t, i.LeaveGroup(group))
+	assert.Equal(t, 0, len(i.Groups()))
+}
+
+func TestProtocolUnreachable(t *testing.T) {
+	eth := newTestEthernet()
+	i := NewInterface("eth0", eth, nil, DefaultMTU)
+	i.AddAddress(Address{10, 0, 0, 1}, Address{255, 255, 255, 0})
+	router := resolvingRouter{NewInterfaceRouter([]Interface{i}, nil), ethernet.MAC{0x02, 0, 0, 0, 0, 2}}
+	NewInterfaceLayer(router, []Interface{i}, DefaultReassemblyTimeout, DefaultReassemblyMemory)
+
+	// Errors are sent about packets without a layer for their protocol, at
+	// a limited rate.
+	go func() {
+		for n := 0; n < 5*icmpErrorBurst; n++ {
+			p := NewPacketTo(Address{10, 0, 0, 1}, 253, []byte{byte(n)})
+			p.Source = Address{10, 0, 0, 2}
+			p.Checksum = p.CalculateChecksum()
+			eth.in <- ethernet.Packet{EtherType: ethernet.EtherTypeIPv4, Payload: common.PacketToBytes(p)}
+		}
+	}()
+	errors := 0
+	for {
+		select {
+		case frame := <-eth.out:
+			q, err := NewPacket(bytes.NewReader(frame.Payload))
+			assert.Nil(t, err)
+			assert.Equal(t, Address{10, 0, 0, 1}, q.Source)
+			assert.Equal(t, Address{10, 0, 0, 2}, q.Destination)
+			assert.Equal(t, []byte{icmpTypeDestinationUnreachable, icmpCodeProtocolUnreachable}, q.Payload[:2])
+			errors++
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	assert.True(t, errors > 0 && errors < 5*icmpErrorBurst, "%v errors", errors)
+}
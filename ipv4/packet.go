@@ -32,6 +32,10 @@ type Address [4]byte
 // Broadcast is the IPv4 broadcast address.
 var Broadcast = Address([4]byte{255, 255, 255, 255})
 
+// AllHosts is the multicast group of all hosts, which every interface
+// is a member of.
+var AllHosts = Address([4]byte{224, 0, 0, 1})
+
 // NewAddress creates a new address from a string.
 //
 // If the string is invalid, NewAddress returns false.
@@ -72,6 +76,12 @@ func (a Address) Equals(b Address) bool {
 	return true
 }
 
+// IsMulticast determines whether an address is a multicast group, which
+// is in 224.0.0.0/4.
+func (a Address) IsMulticast() bool {
+	return a[0]&0xF0 == 0xE0
+}
+
 // Bytes copies an address to a new byte slice.
 func (a Address) Bytes() []byte {
 	s := make([]byte, len(a))
@@ -88,6 +98,12 @@ const (
 	// ProtocolICMP is used for the ICMP protocol.
 	ProtocolICMP = 1
 
+	// ProtocolIGMP is used for the IGMP protocol.
+	ProtocolIGMP = 2
+
+	// ProtocolTCP is used for the TCP protocol.
+	ProtocolTCP = 6
+
 	// ProtocolUDP is used for the UDP protocol.
 	ProtocolUDP = 17
 )
@@ -126,7 +142,7 @@ func NewHeader(r io.Reader) (Header, error) {
 	}
 
 	h := raw.Header()
-	numOptionBytes := (int(h.IHL) - 5) * 8
+	numOptionBytes := (int(h.IHL) - 5) * 4
 	if numOptionBytes < 0 {
 		return h, ErrInvalidIHL
 	} else if numOptionBytes > 0 {
@@ -210,7 +226,7 @@ func NewRawHeader(r io.Reader) (RawHeader, error) {
 func (h RawHeader) Header() Header {
 	var header Header
 	header.Version = (h.VersionIHL >> 4) & 0x0F
-	header.IHL = h.VersionIHL & 0x07
+	header.IHL = h.VersionIHL & 0x0F
 	header.ToS = h.ToS
 	header.TotalLength = h.TotalLength
 	header.Identification = h.Identification
@@ -224,10 +240,28 @@ func (h RawHeader) Header() Header {
 	return header
 }
 
+// PseudoHeader is the IPv4 pseudo header used in the checksums of upper
+// layer protocols.
+type PseudoHeader struct {
+	Source      Address
+	Destination Address
+	Zero        uint8
+	Protocol    Protocol
+	Length      uint16
+}
+
+// Write the pseudo header to a Writer.
+func (h PseudoHeader) Write(w io.Writer) error {
+	return binary.Write(w, binary.BigEndian, h)
+}
+
 // Packet is an IPv4 packet.
 type Packet struct {
 	Header
 	Payload []byte
+
+	// Interface is the interface on which a packet was received.
+	Interface Interface
 }
 
 // NewPacket will read a packet from a reader.
@@ -105,10 +105,10 @@ func TestHeader(t *testing.T) {
 	// A header with options.
 	{
 		test := headers[0]
-		test.Header.IHL = 6
+		test.Header.IHL = 7
 		test.Header.Options = []byte{0, 1, 2, 3, 4, 5, 6, 7}
 		test.Bytes += "0001020304050607"
-		test.Bytes = test.Bytes[:1] + "6" + test.Bytes[2:]
+		test.Bytes = test.Bytes[:1] + "7" + test.Bytes[2:]
 
 		b, err := hex.DecodeString(test.Bytes)
 		assert.Nil(t, err)
@@ -0,0 +1,229 @@
+package ipv4
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReassemblyTimeout is the time after which incomplete
+	// datagrams are dropped.
+	DefaultReassemblyTimeout = 30 * time.Second
+
+	// DefaultReassemblyMemory is the number of payload bytes that the
+	// fragments of incomplete datagrams may use. The oldest datagrams are
+	// dropped when new fragments do not fit.
+	DefaultReassemblyMemory = 256 * 1024
+)
+
+type fragmentKey struct {
+	source         Address
+	destination    Address
+	protocol       Protocol
+	identification uint16
+}
+
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+func (f fragment) end() int {
+	return f.offset + len(f.data)
+}
+
+// datagram is a datagram that is being reassembled.
+type datagram struct {
+	key     fragmentKey
+	created time.Time
+	timer   *time.Timer
+
+	// first is the first fragment, if it was received.
+	first *Packet
+
+	// length is the length of the payload, or -1 if the last fragment was
+	// not received yet.
+	length int
+
+	// size is the number of bytes in the fragments.
+	size   int
+	sorted []fragment
+}
+
+// reassembler reassembles fragmented datagrams.
+type reassembler struct {
+	timeout   time.Duration
+	maxMemory int
+
+	// expired is called with the first fragment of a datagram that timed
+	// out.
+	expired func(first Packet)
+
+	lock      sync.Mutex
+	datagrams map[fragmentKey]*datagram
+	memory    int
+}
+
+func newReassembler(timeout time.Duration, maxMemory int, expired func(Packet)) *reassembler {
+	return &reassembler{
+		timeout:   timeout,
+		maxMemory: maxMemory,
+		expired:   expired,
+		datagrams: make(map[fragmentKey]*datagram),
+	}
+}
+
+// add adds a fragment. The reassembled packet is returned when all
+// fragments have been received.
+//
+// Data that overlaps fragments that were already received is ignored.
+// Datagrams with inconsistent fragments, or with a total length larger
+// than the maximum packet size, are dropped.
+func (r *reassembler) add(p Packet) (Packet, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := fragmentKey{p.Source, p.Destination, p.Protocol, p.Identification}
+	d := r.datagrams[key]
+	if d == nil {
+		d = &datagram{key: key, created: time.Now(), length: -1}
+		d.timer = time.AfterFunc(r.timeout, func() { r.expire(d) })
+		r.datagrams[key] = d
+	}
+
+	f := fragment{int(p.FragmentOffset) * 8, p.Payload}
+	last := p.Flags&FlagMoreFragments == 0
+	if (!last && len(f.data)%8 != 0) || f.end()+20+len(p.Options) > 0xFFFF {
+		r.remove(d)
+		return Packet{}, false
+	}
+	if last {
+		inconsistent := d.length >= 0 && d.length != f.end()
+		if n := len(d.sorted); inconsistent || (n > 0 && d.sorted[n-1].end() > f.end()) {
+			r.remove(d)
+			return Packet{}, false
+		}
+		d.length = f.end()
+	} else if d.length >= 0 && f.end() > d.length {
+		r.remove(d)
+		return Packet{}, false
+	}
+	if f.offset == 0 && d.first == nil {
+		first := p
+		d.first = &first
+	}
+
+	n := d.insert(f)
+	if d.size+n > r.maxMemory {
+		r.remove(d)
+		return Packet{}, false
+	}
+	d.size += n
+	r.memory += n
+	r.evict(d)
+
+	if !d.complete() {
+		return Packet{}, false
+	}
+	r.remove(d)
+	return d.reassemble(), true
+}
+
+// insert inserts the parts of a fragment that were not received yet, and
+// returns the number of new bytes.
+func (d *datagram) insert(f fragment) int {
+	n := 0
+	var result []fragment
+	for _, g := range d.sorted {
+		if f.offset < g.offset && len(f.data) > 0 {
+			// The part of the new fragment before g
+			size := g.offset - f.offset
+			if size > len(f.data) {
+				size = len(f.data)
+			}
+			result = append(result, fragment{f.offset, f.data[:size]})
+			n += size
+		}
+		if f.end() <= g.end() {
+			f.data = nil
+		} else if f.offset < g.end() {
+			f.data = f.data[g.end()-f.offset:]
+			f.offset = g.end()
+		}
+		result = append(result, g)
+	}
+	if len(f.data) > 0 {
+		result = append(result, f)
+		n += len(f.data)
+	}
+	d.sorted = result
+	return n
+}
+
+func (d *datagram) complete() bool {
+	if d.first == nil || d.length < 0 {
+		return false
+	}
+	end := 0
+	for _, f := range d.sorted {
+		if f.offset != end {
+			return false
+		}
+		end = f.end()
+	}
+	return end == d.length
+}
+
+func (d *datagram) reassemble() Packet {
+	p := Packet{Header: d.first.Header}
+	p.Payload = make([]byte, 0, d.length)
+	for _, f := range d.sorted {
+		p.Payload = append(p.Payload, f.data...)
+	}
+	p.Flags &^= FlagMoreFragments
+	p.FragmentOffset = 0
+	p.TotalLength = uint16(20 + len(p.Options) + len(p.Payload))
+	p.Checksum = p.CalculateChecksum()
+	return p
+}
+
+// evict drops the oldest datagrams, except d, until the fragments fit in
+// the memory limit. The reassembler must be locked.
+func (r *reassembler) evict(d *datagram) {
+	for r.memory > r.maxMemory {
+		var oldest *datagram
+		for _, e := range r.datagrams {
+			if e != d && (oldest == nil || e.created.Before(oldest.created)) {
+				oldest = e
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		r.remove(oldest)
+	}
+}
+
+// remove drops a datagram. The reassembler must be locked.
+func (r *reassembler) remove(d *datagram) {
+	if r.datagrams[d.key] != d {
+		return
+	}
+	d.timer.Stop()
+	delete(r.datagrams, d.key)
+	r.memory -= d.size
+}
+
+func (r *reassembler) expire(d *datagram) {
+	r.lock.Lock()
+	if r.datagrams[d.key] != d {
+		r.lock.Unlock()
+		return
+	}
+	r.remove(d)
+	r.lock.Unlock()
+
+	if d.first != nil && r.expired != nil {
+		r.expired(*d.first)
+	}
+}
@@ -0,0 +1,150 @@
+package ipv4
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ethernet"
+)
+
+func fragments(t *testing.T, size, mtu int) (Packet, []Packet) {
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	p := NewPacketTo(Address{10, 0, 0, 1}, ProtocolUDP, payload)
+	p.Source = Address{10, 0, 0, 2}
+	fragments, err := p.Fragment(mtu)
+	assert.Nil(t, err)
+	return p, fragments
+}
+
+func TestReassembly(t *testing.T) {
+	p, f := fragments(t, 3000, 1000)
+	assert.Equal(t, 4, len(f))
+
+	overlapping := f[1]
+	overlapping.FragmentOffset -= 8
+	overlapping.Payload = p.Payload[overlapping.FragmentOffset*8 : overlapping.FragmentOffset*8+1000]
+	early := f[2]
+	early.Flags &^= FlagMoreFragments
+
+	tests := []struct {
+		name      string
+		fragments []Packet
+		complete  bool
+	}{
+		{"in order", []Packet{f[0], f[1], f[2], f[3]}, true},
+		{"reversed", []Packet{f[3], f[2], f[1], f[0]}, true},
+		{"duplicates", []Packet{f[0], f[2], f[0], f[3], f[2], f[1]}, true},
+		{"overlapping", []Packet{f[0], overlapping, f[2], f[1], f[3]}, true},
+		{"missing", []Packet{f[0], f[1], f[3]}, false},
+		{"inconsistent length", []Packet{f[0], f[1], f[3], early, f[2]}, false},
+	}
+
+	for _, test := range tests {
+		r := newReassembler(time.Minute, DefaultReassemblyMemory, nil)
+		var result Packet
+		var ok bool
+		for _, fragment := range test.fragments {
+			result, ok = r.add(fragment)
+		}
+		assert.Equal(t, test.complete, ok, test.name)
+		if test.complete {
+			assert.Equal(t, p.Payload, result.Payload, test.name)
+			assert.Equal(t, p.TotalLength, result.TotalLength, test.name)
+			assert.Equal(t, uint8(0), result.Flags&FlagMoreFragments, test.name)
+			assert.Nil(t, result.Check(), test.name)
+			assert.Equal(t, 0, len(r.datagrams), test.name)
+			assert.Equal(t, 0, r.memory, test.name)
+		}
+	}
+}
+
+func TestReassemblyLimits(t *testing.T) {
+	r := newReassembler(time.Minute, 2500, nil)
+
+	// Too large for the memory limit
+	_, f := fragments(t, 3000, 1000)
+	for _, fragment := range f {
+		_, ok := r.add(fragment)
+		assert.False(t, ok)
+		assert.True(t, r.memory <= 2500)
+	}
+
+	// The oldest datagram is dropped.
+	r = newReassembler(time.Minute, 2500, nil)
+	_, a := fragments(t, 2000, 1000)
+	_, b := fragments(t, 2000, 1000)
+	r.add(a[0])
+	r.add(b[0])
+	assert.Equal(t, 2, len(r.datagrams))
+	r.add(a[1])
+	assert.Equal(t, 1, len(r.datagrams))
+	_, ok := r.add(a[2])
+	assert.True(t, ok)
+	r.add(b[1])
+	_, ok = r.add(b[2])
+	assert.False(t, ok)
+
+	// Datagrams larger than 65535 bytes
+	r = newReassembler(time.Minute, 2500, nil)
+	_, f = fragments(t, 1000, 500)
+	f[1].FragmentOffset = 0x1FFF
+	r.add(f[0])
+	r.add(f[1])
+	assert.Equal(t, 0, len(r.datagrams))
+}
+
+func TestReassemblyTimeout(t *testing.T) {
+	eth := newTestEthernet()
+	local := Address{10, 0, 0, 1}
+	l := NewCustomLayer(
+		local, staticRouter{0x02, 0, 0, 0, 0, 2}, eth, DefaultMTU,
+		50*time.Millisecond, DefaultReassemblyMemory,
+	)
+	packets := l.Packets(ProtocolUDP)
+
+	send := func(p Packet) {
+		p.Checksum = p.CalculateChecksum()
+		eth.in <- ethernet.Packet{EtherType: ethernet.EtherTypeIPv4, Payload: common.PacketToBytes(p)}
+	}
+
+	// Complete datagram
+	p, f := fragments(t, 3000, 1000)
+	for _, fragment := range f {
+		send(fragment)
+	}
+	select {
+	case q := <-packets:
+		assert.Equal(t, p.Payload, q.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("Datagram not reassembled")
+	}
+
+	// Incomplete datagram
+	_, f = fragments(t, 3000, 1000)
+	send(f[0])
+	send(f[1])
+	select {
+	case frame := <-eth.out:
+		q, err := NewPacket(bytes.NewReader(frame.Payload))
+		assert.Nil(t, err)
+		assert.Equal(t, ProtocolICMP, int(q.Protocol))
+		assert.Equal(t, f[0].Source, q.Destination)
+		assert.Equal(t, []byte{icmpTypeTimeExceeded, icmpCodeReassemblyTimeExceeded}, q.Payload[:2])
+		assert.Equal(t, uint16(0xFFFF), common.Checksum(q.Payload))
+
+		// The message contains the header and the start of the first
+		// fragment.
+		h, err := NewHeader(bytes.NewReader(q.Payload[8:]))
+		assert.Nil(t, err)
+		assert.Equal(t, f[0].Identification, h.Identification)
+		assert.Equal(t, f[0].Payload[:8], q.Payload[28:])
+	case <-time.After(time.Second):
+		t.Fatal("No Time Exceeded message sent")
+	}
+}
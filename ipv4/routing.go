@@ -1,7 +1,11 @@
 package ipv4
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
 
 	"github.com/unigornel/go-tcpip/ethernet"
 )
@@ -10,66 +14,391 @@ var (
 	// ErrNoRouteToDestinationAddress is returned when there is no route
 	// to the destination address.
 	ErrNoRouteToDestinationAddress = errors.New("no route to destination address")
+
+	// ErrRouteExists is returned when adding a route that is already in
+	// the routing table.
+	ErrRouteExists = errors.New("route already exists")
+
+	// ErrRouteNotFound is returned when deleting a route that is not in
+	// the routing table.
+	ErrRouteNotFound = errors.New("route not found")
+
+	// ErrInvalidRoute is returned when adding a route with a netmask that
+	// is not contiguous, or a route without gateway and interface to a
+	// router with several interfaces.
+	ErrInvalidRoute = errors.New("invalid route")
 )
 
 // Router is an IPv4 router.
 type Router interface {
 	// Resolve will resolve the IPv4 address to the ethernet MAC address
-	// of the next hop.
+	// of the next hop. Limited and directed broadcast addresses resolve to
+	// ethernet.Broadcast, and multicast addresses to their MulticastMAC.
 	//
 	// See also ErrNoRouteToDestinationAddress.
 	Resolve(address Address) (ethernet.MAC, error)
+
+	// Route returns the interface, the source address and the next hop
+	// of the packets to a destination. The limited broadcast address and
+	// multicast groups without route are reached on the first interface.
+	//
+	// See also ErrNoRouteToDestinationAddress.
+	Route(destination Address) (NextHop, error)
+
+	// Configure changes the local network and replaces the default routes
+	// with a route through the gateway.
+	Configure(address, netmask Address, gateway *Address)
+
+	// AddRoute adds a route to the routing table.
+	//
+	// See also ErrRouteExists and ErrInvalidRoute.
+	AddRoute(route Route) error
+
+	// DeleteRoute deletes a route with the same destination, netmask,
+	// gateway and interface from the routing table. The routes to the
+	// local networks cannot be deleted.
+	//
+	// See also ErrRouteNotFound.
+	DeleteRoute(route Route) error
+
+	// Routes returns the routes to the local networks, followed by the
+	// routes that were added.
+	Routes() []Route
+}
+
+// Route is a route of a routing table.
+type Route struct {
+	Destination Address
+	Netmask     Address
+
+	// Gateway is nil for routes to local networks.
+	Gateway *Address
+
+	// Interface is the interface of the route. If it is nil, the
+	// interface is the one of the local network of the gateway.
+	Interface Interface
+
+	// Metric is used to select a route from routes with the same netmask.
+	// Lower metrics are preferred.
+	Metric int
+}
+
+func (r Route) String() string {
+	s := fmt.Sprintf("%v/%v", r.Destination, prefixLength(r.Netmask))
+	if r.Gateway != nil {
+		s += fmt.Sprintf(" via %v", *r.Gateway)
+	}
+	if r.Interface != nil {
+		s += fmt.Sprintf(" dev %v", r.Interface.Name())
+	}
+	return s + fmt.Sprintf(" metric %v", r.Metric)
+}
+
+// Contains determines whether an address is in the destination network
+// of the route.
+func (r Route) Contains(address Address) bool {
+	return r.Destination.And(r.Netmask).Equals(address.And(r.Netmask))
+}
+
+// same determines whether two routes have the same destination, netmask,
+// gateway and interface.
+func (r Route) same(o Route) bool {
+	sameGateway := (r.Gateway == nil && o.Gateway == nil) ||
+		(r.Gateway != nil && o.Gateway != nil && r.Gateway.Equals(*o.Gateway))
+	return r.Destination.Equals(o.Destination) && r.Netmask.Equals(o.Netmask) &&
+		sameGateway && r.Interface == o.Interface
+}
+
+// NextHop is the result of a route lookup.
+type NextHop struct {
+	// Interface is the interface on which the packets are sent. If it is
+	// nil, the packets are sent from the primary address of the first
+	// interface of the layer.
+	Interface Interface
+
+	// Source is the source address of the packets.
+	Source Address
+
+	// Address is the address of the next hop, which is either the
+	// destination or a gateway.
+	Address Address
 }
 
 type router struct {
-	arp     ARP
-	address Address
-	netmask Address
-	gateway *Address
+	interfaces []Interface
+
+	// arp resolves the next hops of a router without interfaces.
+	arp ARP
+
+	lock   sync.RWMutex
+	local  InterfaceAddress
+	routes []Route
 }
 
-// NewRouter creates a default router.
-//
-// ARP is used to resolve local addresses. Otherwise, the MAC address of
-// the gateway is returned.
+// NewRouter creates a router for a single local network, whose addresses
+// are resolved with ARP. The routes of the router have no interface.
 //
 // Specifying a gateway is optional.
 func NewRouter(arp ARP, address, netmask Address, gateway *Address) Router {
-	return &router{
-		arp:     arp,
-		address: address,
-		netmask: netmask,
-		gateway: gateway,
+	r := &router{arp: arp}
+	r.Configure(address, netmask, gateway)
+	return r
+}
+
+// NewInterfaceRouter creates a router for the local networks of several
+// interfaces.
+//
+// The addresses of the interfaces are the local networks of the router.
+// A destination is reached from the address in the local network of the
+// next hop. Configure changes the addresses of the first interface.
+//
+// Specifying a gateway is optional.
+func NewInterfaceRouter(interfaces []Interface, gateway *Address) Router {
+	r := &router{interfaces: interfaces}
+	r.setGateway(gateway)
+	return r
+}
+
+func (r *router) Configure(address, netmask Address, gateway *Address) {
+	if len(r.interfaces) > 0 {
+		r.interfaces[0].Configure(address, netmask)
+	} else {
+		r.lock.Lock()
+		r.local = InterfaceAddress{address, netmask}
+		r.lock.Unlock()
 	}
+	r.setGateway(gateway)
 }
 
-func (r *router) Resolve(address Address) (mac ethernet.MAC, err error) {
-	if r.isLocal(address) {
-		mac, err = r.arp.Resolve(address)
-		if err == ErrARPTimeout {
-			err = ErrNoRouteToDestinationAddress
+// setGateway replaces the default routes with a route through a gateway.
+func (r *router) setGateway(gateway *Address) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var routes []Route
+	for _, route := range r.routes {
+		if !route.Netmask.Equals(Address{}) {
+			routes = append(routes, route)
 		}
+	}
+	if gateway != nil {
+		g := *gateway
+		routes = append(routes, Route{Gateway: &g})
+	}
+	r.routes = routes
+}
 
-	} else if address.Equals(Broadcast) {
-		mac = ethernet.MulticastIPv4
-		mac[5] &= address[3]
-		mac[4] &= address[2]
-		mac[3] &= (address[1] & 0x7F)
+func (r *router) AddRoute(route Route) error {
+	if !contiguous(route.Netmask) {
+		return ErrInvalidRoute
+	} else if route.Gateway == nil && route.Interface == nil && len(r.interfaces) > 0 {
+		return ErrInvalidRoute
+	}
+	route.Destination = route.Destination.And(route.Netmask)
+	if route.Gateway != nil {
+		g := *route.Gateway
+		route.Gateway = &g
+	}
 
-	} else if r.gateway != nil {
-		mac, err = r.arp.Resolve(*r.gateway)
-		if err == ErrARPTimeout {
-			err = ErrNoRouteToDestinationAddress
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, other := range r.routes {
+		if other.same(route) {
+			return ErrRouteExists
 		}
-	} else {
+	}
+	r.routes = append(r.routes, route)
+	return nil
+}
+
+func (r *router) DeleteRoute(route Route) error {
+	route.Destination = route.Destination.And(route.Netmask)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, other := range r.routes {
+		if other.same(route) {
+			r.routes = append(r.routes[:i], r.routes[i+1:]...)
+			return nil
+		}
+	}
+	return ErrRouteNotFound
+}
+
+func (r *router) Routes() []Route {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return append(r.connected(), r.routes...)
+}
+
+// connected returns the routes to the local networks. The router must be
+// locked.
+func (r *router) connected() []Route {
+	var routes []Route
+	if len(r.interfaces) == 0 && !r.local.Address.Equals(Address{}) {
+		routes = append(routes, Route{
+			Destination: r.local.Address.And(r.local.Netmask),
+			Netmask:     r.local.Netmask,
+		})
+	}
+	for _, i := range r.interfaces {
+		for _, a := range i.Addresses() {
+			routes = append(routes, Route{
+				Destination: a.Address.And(a.Netmask),
+				Netmask:     a.Netmask,
+				Interface:   i,
+			})
+		}
+	}
+	return routes
+}
+
+// lookup finds the route with the longest prefix that contains an
+// address, and the lowest metric of those.
+func lookup(routes []Route, address Address) (Route, bool) {
+	var best Route
+	found := false
+	for _, route := range routes {
+		if !route.Contains(address) {
+			continue
+		}
+		if !found || moreSpecific(route.Netmask, best.Netmask) ||
+			(route.Netmask.Equals(best.Netmask) && route.Metric < best.Metric) {
+			best = route
+			found = true
+		}
+	}
+	return best, found
+}
+
+func (r *router) Route(destination Address) (NextHop, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if destination.Equals(Broadcast) {
+		return r.first(destination), nil
+	}
+
+	connected := r.connected()
+	route, ok := lookup(append(connected, r.routes...), destination)
+	if destination.IsMulticast() {
+		// Multicast packets are sent on the interface of a route without
+		// gateway, or on the first interface.
+		if !ok || route.Interface == nil {
+			return r.first(destination), nil
+		}
+		return NextHop{route.Interface, source(route.Interface, destination), destination}, nil
+	}
+	if !ok {
+		return NextHop{}, ErrNoRouteToDestinationAddress
+	}
+
+	hop := NextHop{Interface: route.Interface, Address: destination}
+	if route.Gateway != nil {
+		hop.Address = *route.Gateway
+	}
+	if hop.Interface == nil && len(r.interfaces) > 0 {
+		local, ok := lookup(connected, hop.Address)
+		if !ok {
+			return NextHop{}, ErrNoRouteToDestinationAddress
+		}
+		hop.Interface = local.Interface
+	}
+	if hop.Interface != nil {
+		hop.Source = source(hop.Interface, hop.Address)
+	}
+	return hop, nil
+}
+
+// first returns the next hop to a destination on the first interface. The
+// router must be locked.
+func (r *router) first(destination Address) NextHop {
+	if len(r.interfaces) == 0 {
+		return NextHop{Address: destination}
+	}
+	return NextHop{r.interfaces[0], primary(r.interfaces[0]).Address, destination}
+}
+
+// isBroadcast determines whether an address is the directed broadcast
+// address of a local network.
+func (r *router) isBroadcast(address Address) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, route := range r.connected() {
+		if (InterfaceAddress{route.Destination, route.Netmask}).IsBroadcast(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// source returns the address of an interface with the most specific
+// network that contains the next hop, or the primary address.
+func source(i Interface, next Address) Address {
+	var best *InterfaceAddress
+	addresses := i.Addresses()
+	for j, a := range addresses {
+		if a.Contains(next) && (best == nil || moreSpecific(a.Netmask, best.Netmask)) {
+			best = &addresses[j]
+		}
+	}
+	if best != nil {
+		return best.Address
+	}
+	return primary(i).Address
+}
+
+func (r *router) Resolve(address Address) (ethernet.MAC, error) {
+	if address.Equals(Broadcast) {
+		return ethernet.Broadcast, nil
+	} else if address.IsMulticast() {
+		return MulticastMAC(address), nil
+	}
+	hop, err := r.Route(address)
+	if err != nil {
+		return ethernet.MAC{}, err
+	}
+	if hop.Address.Equals(address) && r.isBroadcast(address) {
+		return ethernet.Broadcast, nil
+	}
+
+	arp := r.arp
+	if hop.Interface != nil {
+		arp = hop.Interface.ARP()
+	}
+	if arp == nil {
+		return ethernet.MAC{}, ErrNoRouteToDestinationAddress
+	}
+	mac, err := arp.Resolve(hop.Address)
+	if err == ErrARPTimeout {
 		err = ErrNoRouteToDestinationAddress
 	}
+	return mac, err
+}
+
+// MulticastMAC returns the Ethernet address of a multicast group, which
+// is ethernet.MulticastIPv4 with the low 23 bits of the group, as described
+// in RFC 1112.
+func MulticastMAC(group Address) ethernet.MAC {
+	mac := ethernet.MulticastIPv4
+	mac[3] = group[1] & 0x7F
+	mac[4] = group[2]
+	mac[5] = group[3]
+	return mac
+}
+
+// moreSpecific determines whether netmask a has more bits set than b.
+func moreSpecific(a, b Address) bool {
+	return binary.BigEndian.Uint32(a[:]) > binary.BigEndian.Uint32(b[:])
+}
 
-	return
+// contiguous determines whether the bits that are set in a netmask are
+// contiguous.
+func contiguous(netmask Address) bool {
+	n := binary.BigEndian.Uint32(netmask[:])
+	return bits.OnesCount32(n) == bits.LeadingZeros32(^n)
 }
 
-func (r *router) isLocal(address Address) bool {
-	a := r.address.And(r.netmask)
-	b := address.And(r.netmask)
-	return a.Equals(b)
+func prefixLength(netmask Address) int {
+	return bits.OnesCount32(binary.BigEndian.Uint32(netmask[:]))
 }
@@ -0,0 +1,156 @@
+package ipv4
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/ethernet"
+)
+
+func TestInterfaceRouter(t *testing.T) {
+	eth0 := NewInterface("eth0", newTestEthernet(), nil, DefaultMTU)
+	eth0.AddAddress(Address{10, 0, 0, 1}, Address{255, 255, 0, 0})
+	eth1 := NewInterface("eth1", newTestEthernet(), nil, DefaultMTU)
+	eth1.AddAddress(Address{192, 168, 0, 1}, Address{255, 255, 255, 0})
+	eth1.AddAddress(Address{10, 0, 1, 1}, Address{255, 255, 255, 0})
+	gateway := Address{192, 168, 0, 254}
+	r := NewInterfaceRouter([]Interface{eth0, eth1}, &gateway)
+
+	tests := []struct {
+		destination Address
+		hop         NextHop
+	}{
+		{Address{10, 0, 0, 2}, NextHop{eth0, Address{10, 0, 0, 1}, Address{10, 0, 0, 2}}},
+		{Address{10, 0, 1, 2}, NextHop{eth1, Address{10, 0, 1, 1}, Address{10, 0, 1, 2}}},
+		{Address{192, 168, 0, 2}, NextHop{eth1, Address{192, 168, 0, 1}, Address{192, 168, 0, 2}}},
+		{Address{8, 8, 8, 8}, NextHop{eth1, Address{192, 168, 0, 1}, gateway}},
+		{Broadcast, NextHop{eth0, Address{10, 0, 0, 1}, Broadcast}},
+	}
+	for _, test := range tests {
+		hop, err := r.Route(test.destination)
+		assert.Nil(t, err, "%v", test.destination)
+		assert.Equal(t, test.hop, hop, "%v", test.destination)
+	}
+
+	// Without gateway
+	r.Configure(Address{10, 0, 0, 1}, Address{255, 255, 255, 0}, nil)
+	_, err := r.Route(Address{8, 8, 8, 8})
+	assert.Equal(t, ErrNoRouteToDestinationAddress, err)
+	_, err = r.Route(Address{10, 0, 2, 1})
+	assert.Equal(t, ErrNoRouteToDestinationAddress, err)
+
+	assert.Equal(t, ErrAddressNotFound, eth1.RemoveAddress(Address{10, 0, 0, 1}))
+	assert.Nil(t, eth1.RemoveAddress(Address{10, 0, 1, 1}))
+	_, err = r.Route(Address{10, 0, 1, 2})
+	assert.Equal(t, ErrNoRouteToDestinationAddress, err)
+}
+
+func TestRoutingTable(t *testing.T) {
+	eth0 := NewInterface("eth0", newTestEthernet(), nil, DefaultMTU)
+	eth0.AddAddress(Address{10, 0, 0, 1}, Address{255, 255, 255, 0})
+	eth1 := NewInterface("eth1", newTestEthernet(), nil, DefaultMTU)
+	eth1.AddAddress(Address{192, 168, 0, 1}, Address{255, 255, 255, 0})
+	gateway := Address{192, 168, 0, 254}
+	r := NewInterfaceRouter([]Interface{eth0, eth1}, &gateway)
+
+	netmask16 := Address{255, 255, 0, 0}
+	netmask24 := Address{255, 255, 255, 0}
+	a, b, c := Address{10, 0, 0, 254}, Address{10, 0, 0, 253}, Address{192, 168, 0, 253}
+	routes := []Route{
+		{Destination: Address{10, 1, 0, 0}, Netmask: netmask16, Gateway: &a, Metric: 10},
+		{Destination: Address{10, 1, 0, 0}, Netmask: netmask16, Gateway: &b, Metric: 5},
+		{Destination: Address{10, 1, 2, 3}, Netmask: netmask24, Gateway: &c},
+		{Destination: Address{172, 16, 0, 0}, Netmask: netmask16, Interface: eth1},
+	}
+	for _, route := range routes {
+		assert.Nil(t, r.AddRoute(route))
+	}
+	assert.Equal(t, ErrRouteExists, r.AddRoute(routes[0]))
+	assert.Equal(t, ErrInvalidRoute, r.AddRoute(Route{Netmask: Address{255, 0, 255, 0}, Gateway: &a}))
+	assert.Equal(t, ErrInvalidRoute, r.AddRoute(Route{Destination: Address{172, 17, 0, 0}, Netmask: netmask16}))
+
+	var listed []string
+	for _, route := range r.Routes() {
+		listed = append(listed, route.String())
+	}
+	assert.Equal(t, []string{
+		"10.0.0.0/24 dev eth0 metric 0",
+		"192.168.0.0/24 dev eth1 metric 0",
+		"0.0.0.0/0 via 192.168.0.254 metric 0",
+		"10.1.0.0/16 via 10.0.0.254 metric 10",
+		"10.1.0.0/16 via 10.0.0.253 metric 5",
+		"10.1.2.0/24 via 192.168.0.253 metric 0",
+		"172.16.0.0/16 dev eth1 metric 0",
+	}, listed)
+
+	tests := []struct {
+		destination Address
+		hop         NextHop
+	}{
+		{Address{10, 1, 1, 1}, NextHop{eth0, Address{10, 0, 0, 1}, b}},
+		{Address{10, 1, 2, 1}, NextHop{eth1, Address{192, 168, 0, 1}, c}},
+		{Address{172, 16, 5, 5}, NextHop{eth1, Address{192, 168, 0, 1}, Address{172, 16, 5, 5}}},
+		{Address{8, 8, 8, 8}, NextHop{eth1, Address{192, 168, 0, 1}, gateway}},
+	}
+	for _, test := range tests {
+		hop, err := r.Route(test.destination)
+		assert.Nil(t, err, "%v", test.destination)
+		assert.Equal(t, test.hop, hop, "%v", test.destination)
+	}
+
+	// Deleting a route
+	assert.Nil(t, r.DeleteRoute(routes[1]))
+	assert.Equal(t, ErrRouteNotFound, r.DeleteRoute(routes[1]))
+	hop, err := r.Route(Address{10, 1, 1, 1})
+	assert.Nil(t, err)
+	assert.Equal(t, a, hop.Address)
+
+	// Gateways outside the local networks are unreachable.
+	d := Address{10, 9, 0, 1}
+	assert.Nil(t, r.AddRoute(Route{Destination: Address{10, 2, 0, 0}, Netmask: netmask16, Gateway: &d}))
+	_, err = r.Route(Address{10, 2, 0, 1})
+	assert.Equal(t, ErrNoRouteToDestinationAddress, err)
+
+	// Configure replaces the default route.
+	r.Configure(Address{10, 0, 0, 1}, netmask24, nil)
+	_, err = r.Route(Address{8, 8, 8, 8})
+	assert.Equal(t, ErrNoRouteToDestinationAddress, err)
+	assert.Equal(t, 6, len(r.Routes()))
+}
+
+func TestBroadcastAndMulticast(t *testing.T) {
+	eth0 := NewInterface("eth0", newTestEthernet(), nil, DefaultMTU)
+	eth0.AddAddress(Address{10, 0, 0, 1}, Address{255, 255, 255, 0})
+	eth1 := NewInterface("eth1", newTestEthernet(), nil, DefaultMTU)
+	eth1.AddAddress(Address{192, 168, 0, 1}, Address{255, 255, 0, 0})
+	eth1.AddAddress(Address{192, 168, 1, 1}, Address{255, 255, 255, 255})
+	r := NewInterfaceRouter([]Interface{eth0, eth1}, nil)
+	assert.Nil(t, r.AddRoute(Route{Destination: Address{239, 1, 0, 0}, Netmask: Address{255, 255, 0, 0}, Interface: eth1}))
+
+	tests := []struct {
+		destination Address
+		hop         NextHop
+		mac         ethernet.MAC
+	}{
+		{Broadcast, NextHop{eth0, Address{10, 0, 0, 1}, Broadcast}, ethernet.Broadcast},
+		{Address{10, 0, 0, 255}, NextHop{eth0, Address{10, 0, 0, 1}, Address{10, 0, 0, 255}}, ethernet.Broadcast},
+		{Address{192, 168, 255, 255}, NextHop{eth1, Address{192, 168, 0, 1}, Address{192, 168, 255, 255}}, ethernet.Broadcast},
+		{AllHosts, NextHop{eth0, Address{10, 0, 0, 1}, AllHosts}, ethernet.MAC{0x01, 0, 0x5E, 0, 0, 1}},
+		{Address{224, 0, 0, 251}, NextHop{eth0, Address{10, 0, 0, 1}, Address{224, 0, 0, 251}}, ethernet.MAC{0x01, 0, 0x5E, 0, 0, 0xFB}},
+		{Address{239, 1, 130, 7}, NextHop{eth1, Address{192, 168, 0, 1}, Address{239, 1, 130, 7}}, ethernet.MAC{0x01, 0, 0x5E, 0x01, 0x82, 0x07}},
+		{Address{239, 255, 255, 250}, NextHop{eth0, Address{10, 0, 0, 1}, Address{239, 255, 255, 250}}, ethernet.MAC{0x01, 0, 0x5E, 0x7F, 0xFF, 0xFA}},
+	}
+	for _, test := range tests {
+		hop, err := r.Route(test.destination)
+		assert.Nil(t, err, "%v", test.destination)
+		assert.Equal(t, test.hop, hop, "%v", test.destination)
+		mac, err := r.Resolve(test.destination)
+		assert.Nil(t, err, "%v", test.destination)
+		assert.Equal(t, test.mac, mac, "%v", test.destination)
+	}
+
+	// Networks without broadcast address
+	assert.False(t, InterfaceAddress{Address{192, 168, 1, 1}, Broadcast}.IsBroadcast(Address{192, 168, 1, 1}))
+	assert.False(t, InterfaceAddress{Address{192, 168, 1, 0}, Address{255, 255, 255, 254}}.IsBroadcast(Address{192, 168, 1, 1}))
+	assert.True(t, InterfaceAddress{Address{192, 168, 1, 1}, Address{255, 255, 255, 252}}.IsBroadcast(Address{192, 168, 1, 3}))
+}
@@ -0,0 +1,72 @@
+package pcap
+
+import (
+	"time"
+
+	"github.com/unigornel/go-tcpip/ethernet"
+)
+
+type captureNIC struct {
+	nic ethernet.NIC
+	w   *Writer
+	tx  chan ethernet.Packet
+	rx  chan ethernet.Packet
+
+	closed chan struct{}
+}
+
+// NewCaptureNIC wraps a NIC so that all sent and received packets are
+// written to w.
+//
+// Packets are forwarded even if they cannot be written. Starting and
+// closing the returned NIC also starts and closes the wrapped NIC.
+func NewCaptureNIC(nic ethernet.NIC, w *Writer) ethernet.NIC {
+	return &captureNIC{
+		nic: nic,
+		w:   w,
+		tx:  make(chan ethernet.Packet),
+		rx:  make(chan ethernet.Packet),
+
+		closed: make(chan struct{}),
+	}
+}
+
+func (nic *captureNIC) Start() {
+	nic.nic.Start()
+	go nic.sendAll()
+	go nic.receiveAll()
+}
+
+func (nic *captureNIC) Close() {
+	close(nic.tx)
+	<-nic.closed
+}
+
+func (nic *captureNIC) Send() chan<- ethernet.Packet {
+	return nic.tx
+}
+
+func (nic *captureNIC) Receive() <-chan ethernet.Packet {
+	return nic.rx
+}
+
+func (nic *captureNIC) GetMAC() ethernet.MAC {
+	return nic.nic.GetMAC()
+}
+
+func (nic *captureNIC) sendAll() {
+	for p := range nic.tx {
+		nic.w.WritePacket(time.Now(), p)
+		nic.nic.Send() <- p
+	}
+	nic.nic.Close()
+	close(nic.closed)
+}
+
+func (nic *captureNIC) receiveAll() {
+	for p := range nic.nic.Receive() {
+		nic.w.WritePacket(time.Now(), p)
+		nic.rx <- p
+	}
+	close(nic.rx)
+}
@@ -0,0 +1,103 @@
+package pcap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/unigornel/go-tcpip/ethernet"
+)
+
+// GoldenMismatchError describes the first packet that differs from the
+// packets of a golden file.
+type GoldenMismatchError struct {
+	Index int
+
+	// Expected is nil if more packets were sent than the golden file
+	// contains, and Actual is nil if fewer were sent.
+	Expected *ethernet.Packet
+	Actual   *ethernet.Packet
+}
+
+func (e *GoldenMismatchError) Error() string {
+	switch {
+	case e.Expected == nil:
+		return fmt.Sprintf("pcap: unexpected packet %v: %v", e.Index, *e.Actual)
+	case e.Actual == nil:
+		return fmt.Sprintf("pcap: missing packet %v: %v", e.Index, *e.Expected)
+	default:
+		return fmt.Sprintf("pcap: packet %v differs: expected %v, got %v", e.Index, *e.Expected, *e.Actual)
+	}
+}
+
+// ReadFile reads all packets of a pcap file.
+func ReadFile(path string) ([]ethernet.Packet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var packets []ethernet.Packet
+	for {
+		_, p, err := r.ReadPacket()
+		if err == io.EOF {
+			return packets, nil
+		} else if err != nil {
+			return nil, err
+		}
+		packets = append(packets, p)
+	}
+}
+
+// WriteFile writes packets to a pcap file, e.g. to create a golden file.
+// The packets are timestamped one millisecond apart from the Unix epoch,
+// so that the file only changes when the packets do.
+func WriteFile(path string, packets []ethernet.Packet) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w, err := NewWriter(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	for i, p := range packets {
+		if err := w.WritePacket(time.Unix(0, 0).Add(time.Duration(i)*time.Millisecond), p); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// CompareGolden compares packets with the packets of a golden pcap file,
+// such as the packets sent to a ReplayNIC. Timestamps are ignored.
+//
+// See also GoldenMismatchError.
+func CompareGolden(path string, packets []ethernet.Packet) error {
+	golden, err := ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(golden) || i < len(packets); i++ {
+		e := &GoldenMismatchError{Index: i}
+		if i < len(golden) {
+			e.Expected = &golden[i]
+		}
+		if i < len(packets) {
+			e.Actual = &packets[i]
+		}
+		if e.Expected == nil || e.Actual == nil || !bytes.Equal(e.Expected.Bytes(), e.Actual.Bytes()) {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/unigornel/go-tcpip/ethernet"
+)
+
+const (
+	// Magic is the magic number of a libpcap file with microsecond
+	// timestamps.
+	Magic = 0xa1b2c3d4
+
+	// MagicNanoseconds is the magic number of a libpcap file with
+	// nanosecond timestamps.
+	MagicNanoseconds = 0xa1b23c4d
+
+	// VersionMajor is the major version of the file format.
+	VersionMajor = 2
+
+	// VersionMinor is the minor version of the file format.
+	VersionMinor = 4
+
+	// LinkTypeEthernet is the link type for Ethernet frames.
+	LinkTypeEthernet = 1
+
+	// DefaultSnapLen is the maximum number of bytes stored per packet.
+	DefaultSnapLen = 65535
+
+	// MaxSnapLen is the largest snapshot length accepted when reading a
+	// file.
+	MaxSnapLen = 262144
+)
+
+var (
+	// ErrInvalidMagic is returned when a file is not a libpcap file.
+	ErrInvalidMagic = errors.New("invalid pcap magic number")
+
+	// ErrUnsupportedLinkType is returned when a file does not contain
+	// Ethernet frames.
+	ErrUnsupportedLinkType = errors.New("unsupported pcap link type")
+
+	// ErrRecordTooLong is returned when a record is longer than the
+	// snapshot length of the file.
+	ErrRecordTooLong = errors.New("pcap record too long")
+)
+
+// FileHeader is the global header of a libpcap file.
+type FileHeader struct {
+	Magic        uint32
+	VersionMajor uint16
+	VersionMinor uint16
+	ThisZone     int32
+	SigFigs      uint32
+	SnapLen      uint32
+	LinkType     uint32
+}
+
+// RecordHeader is the header preceding every packet in a libpcap file.
+type RecordHeader struct {
+	Seconds        uint32
+	Microseconds   uint32 // nanoseconds in files with MagicNanoseconds
+	CapturedLength uint32
+	OriginalLength uint32
+}
+
+// Writer writes Ethernet packets to a libpcap file.
+//
+// A Writer is safe for concurrent use.
+type Writer struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewWriter creates a new writer and writes the file header.
+func NewWriter(w io.Writer) (*Writer, error) {
+	h := FileHeader{
+		Magic:        Magic,
+		VersionMajor: VersionMajor,
+		VersionMinor: VersionMinor,
+		SnapLen:      DefaultSnapLen,
+		LinkType:     LinkTypeEthernet,
+	}
+	if err := binary.Write(w, binary.LittleEndian, h); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// WritePacket writes a packet captured at time t.
+func (w *Writer) WritePacket(t time.Time, p ethernet.Packet) error {
+	data := p.Bytes()
+	if len(data) > DefaultSnapLen {
+		data = data[:DefaultSnapLen]
+	}
+	h := RecordHeader{
+		Seconds:        uint32(t.Unix()),
+		Microseconds:   uint32(t.Nanosecond() / 1000),
+		CapturedLength: uint32(len(data)),
+		OriginalLength: uint32(ethernet.HeaderSize + len(p.Payload)),
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := binary.Write(w.w, binary.LittleEndian, h); err != nil {
+		return err
+	}
+	_, err := w.w.Write(data)
+	return err
+}
+
+// Reader reads Ethernet packets from a libpcap file.
+type Reader struct {
+	r       io.Reader
+	order   binary.ByteOrder
+	nanos   bool
+	snapLen uint32
+}
+
+// NewReader creates a new reader and reads the file header.
+//
+// Files in both byte orders and with either microsecond or nanosecond
+// timestamps are supported. Snapshot lengths above MaxSnapLen are limited
+// to MaxSnapLen. See also ErrInvalidMagic and ErrUnsupportedLinkType.
+func NewReader(r io.Reader) (*Reader, error) {
+	var h FileHeader
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+
+	reader := &Reader{r: r, order: binary.LittleEndian}
+	switch h.Magic {
+	case Magic:
+	case MagicNanoseconds:
+		reader.nanos = true
+	case swap(Magic):
+		reader.order = binary.BigEndian
+	case swap(MagicNanoseconds):
+		reader.order = binary.BigEndian
+		reader.nanos = true
+	default:
+		return nil, ErrInvalidMagic
+	}
+
+	linkType, snapLen := h.LinkType, h.SnapLen
+	if reader.order == binary.BigEndian {
+		linkType, snapLen = swap(linkType), swap(snapLen)
+	}
+	if linkType != LinkTypeEthernet {
+		return nil, ErrUnsupportedLinkType
+	}
+	reader.snapLen = snapLen
+	if snapLen == 0 || snapLen > MaxSnapLen {
+		reader.snapLen = MaxSnapLen
+	}
+	return reader, nil
+}
+
+// ReadPacket reads the next packet and the time it was captured.
+//
+// At the end of the file, io.EOF is returned. See also ErrRecordTooLong.
+func (r *Reader) ReadPacket() (time.Time, ethernet.Packet, error) {
+	var h RecordHeader
+	if err := binary.Read(r.r, r.order, &h); err != nil {
+		return time.Time{}, ethernet.Packet{}, err
+	}
+	if h.CapturedLength > r.snapLen {
+		return time.Time{}, ethernet.Packet{}, ErrRecordTooLong
+	}
+
+	data := make([]byte, h.CapturedLength)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return time.Time{}, ethernet.Packet{}, err
+	}
+
+	nanos := int64(h.Microseconds)
+	if !r.nanos {
+		nanos *= 1000
+	}
+	t := time.Unix(int64(h.Seconds), nanos)
+
+	p, err := ethernet.PacketFromBytes(data)
+	return t, p, err
+}
+
+func swap(i uint32) uint32 {
+	return i>>24 | (i>>8)&0xff00 | (i<<8)&0xff0000 | i<<24
+}
@@ -0,0 +1,111 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/virtual"
+)
+
+var packet = ethernet.Packet{
+	Destination: ethernet.Broadcast,
+	Source:      ethernet.MAC{0x02, 0, 0, 0, 0, 1},
+	EtherType:   ethernet.EtherTypeARP,
+	Payload:     []byte{0xde, 0xad, 0xbe, 0xef},
+}
+
+func TestWriter(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	w, err := NewWriter(b)
+	assert.Nil(t, err)
+	assert.Equal(t, "d4c3b2a1020004000000000000000000ffff000001000000", hex.EncodeToString(b.Bytes()))
+
+	b.Reset()
+	err = w.WritePacket(time.Unix(1500000000, 123456000), packet)
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		"002f6859"+"40e20100"+"12000000"+"12000000"+
+			"ffffffffffff"+"020000000001"+"0806"+"deadbeef",
+		hex.EncodeToString(b.Bytes()),
+	)
+}
+
+func TestCaptureNIC(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	w, err := NewWriter(b)
+	assert.Nil(t, err)
+
+	a, c := virtual.NewWire(packet.Source, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	a = NewCaptureNIC(a, w)
+	a.Start()
+	c.Start()
+
+	a.Send() <- packet
+	<-c.Receive()
+	reply := ethernet.Packet{Destination: packet.Source, Source: c.GetMAC(), EtherType: ethernet.EtherTypeARP}
+	c.Send() <- reply
+	<-a.Receive()
+
+	a.Close()
+	c.Close()
+
+	// File header, then two records with 16 byte headers.
+	data := b.Bytes()
+	assert.Equal(t, 24+16+18+16+14, len(data))
+	assert.Equal(t, packet.Bytes(), data[24+16:24+16+18])
+	assert.Equal(t, reply.Bytes(), data[24+16+18+16:])
+}
+
+func TestReader(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	w, err := NewWriter(b)
+	assert.Nil(t, err)
+	ts := time.Unix(1500000000, 123456000)
+	assert.Nil(t, w.WritePacket(ts, packet))
+
+	r, err := NewReader(b)
+	assert.Nil(t, err)
+	ts2, p, err := r.ReadPacket()
+	assert.Nil(t, err)
+	assert.True(t, ts.Equal(ts2))
+	assert.Equal(t, packet, p)
+
+	_, _, err = r.ReadPacket()
+	assert.Equal(t, io.EOF, err)
+
+	// A big-endian file with nanosecond timestamps.
+	raw := "a1b23c4d000200040000000000000000" + "0000ffff00000001" +
+		"596882f0" + "075bcd15" + "00000012" + "00000012" +
+		"ffffffffffff" + "020000000001" + "0806" + "deadbeef"
+	data, err := hex.DecodeString(raw)
+	assert.Nil(t, err)
+	r, err = NewReader(bytes.NewReader(data))
+	assert.Nil(t, err)
+	ts2, p, err = r.ReadPacket()
+	assert.Nil(t, err)
+	assert.True(t, time.Unix(1500021488, 123456789).Equal(ts2))
+	assert.Equal(t, packet, p)
+
+	// Invalid files.
+	_, err = NewReader(bytes.NewReader(make([]byte, 24)))
+	assert.Equal(t, ErrInvalidMagic, err)
+	_, err = NewReader(bytes.NewReader(data[:20]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// A record longer than the snapshot length.
+	data[18], data[19] = 0, 16
+	r, err = NewReader(bytes.NewReader(data))
+	assert.Nil(t, err)
+	_, _, err = r.ReadPacket()
+	assert.Equal(t, ErrRecordTooLong, err)
+
+	data[23] = 101
+	_, err = NewReader(bytes.NewReader(data))
+	assert.Equal(t, ErrUnsupportedLinkType, err)
+}
@@ -0,0 +1,161 @@
+package pcap
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/unigornel/go-tcpip/ethernet"
+)
+
+// ReplayNIC is a NIC that receives the packets stored in a pcap file and
+// collects the packets that are sent to it.
+type ReplayNIC interface {
+	ethernet.NIC
+
+	// Done is closed when all packets of the file have been received.
+	Done() <-chan struct{}
+
+	// Err returns the error that stopped the replay, if any.
+	Err() error
+
+	// Sent returns the packets that have been sent so far.
+	Sent() []ethernet.Packet
+
+	// WaitSent waits until n packets have been sent or the timeout
+	// expires, and returns the packets that have been sent.
+	WaitSent(n int, timeout time.Duration) []ethernet.Packet
+}
+
+type replayNIC struct {
+	r    *Reader
+	mac  ethernet.MAC
+	tx   chan ethernet.Packet
+	rx   chan ethernet.Packet
+	done chan struct{}
+	stop chan struct{}
+
+	lock sync.Mutex
+	sent []ethernet.Packet
+	err  error
+
+	// sentSignal is closed and replaced when a packet is sent.
+	sentSignal chan struct{}
+}
+
+// NewReplayNIC creates a NIC that replays the packets read from r.
+//
+// Packets are received as fast as the upper layer accepts them. Packets
+// with mac as source address were sent by the captured stack itself and
+// are skipped, so a capture of NewCaptureNIC can be replayed directly.
+func NewReplayNIC(r *Reader, mac ethernet.MAC) ReplayNIC {
+	return &replayNIC{
+		r:    r,
+		mac:  mac,
+		tx:   make(chan ethernet.Packet),
+		rx:   make(chan ethernet.Packet),
+		done: make(chan struct{}),
+		stop: make(chan struct{}),
+
+		sentSignal: make(chan struct{}),
+	}
+}
+
+func (nic *replayNIC) Start() {
+	go nic.sendAll()
+	go nic.receiveAll()
+}
+
+func (nic *replayNIC) Close() {
+	close(nic.tx)
+	close(nic.stop)
+}
+
+func (nic *replayNIC) Send() chan<- ethernet.Packet {
+	return nic.tx
+}
+
+func (nic *replayNIC) Receive() <-chan ethernet.Packet {
+	return nic.rx
+}
+
+func (nic *replayNIC) GetMAC() ethernet.MAC {
+	return nic.mac
+}
+
+func (nic *replayNIC) Done() <-chan struct{} {
+	return nic.done
+}
+
+func (nic *replayNIC) Err() error {
+	nic.lock.Lock()
+	defer nic.lock.Unlock()
+	return nic.err
+}
+
+func (nic *replayNIC) Sent() []ethernet.Packet {
+	nic.lock.Lock()
+	defer nic.lock.Unlock()
+	return append([]ethernet.Packet(nil), nic.sent...)
+}
+
+func (nic *replayNIC) WaitSent(n int, timeout time.Duration) []ethernet.Packet {
+	expired := time.After(timeout)
+	for {
+		nic.lock.Lock()
+		if len(nic.sent) >= n {
+			nic.lock.Unlock()
+			return nic.Sent()
+		}
+		signal := nic.sentSignal
+		nic.lock.Unlock()
+
+		select {
+		case <-signal:
+		case <-expired:
+			return nic.Sent()
+		}
+	}
+}
+
+func (nic *replayNIC) sendAll() {
+	for p := range nic.tx {
+		nic.lock.Lock()
+		nic.sent = append(nic.sent, p)
+		close(nic.sentSignal)
+		nic.sentSignal = make(chan struct{})
+		nic.lock.Unlock()
+	}
+}
+
+func (nic *replayNIC) receiveAll() {
+	defer close(nic.rx)
+
+	nic.replay()
+	close(nic.done)
+	<-nic.stop
+}
+
+func (nic *replayNIC) replay() {
+	for {
+		_, p, err := nic.r.ReadPacket()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			nic.lock.Lock()
+			nic.err = err
+			nic.lock.Unlock()
+			return
+		}
+
+		if p.Source == nic.mac {
+			continue
+		}
+
+		select {
+		case nic.rx <- p:
+		case <-nic.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package pcap
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/udp"
+)
+
+func TestReplayNIC(t *testing.T) {
+	local := ethernet.MAC{0x02, 0, 0, 0, 0, 1}
+	localIP, _ := ipv4.NewAddress("10.0.0.1")
+	remoteIP, _ := ipv4.NewAddress("10.0.0.2")
+	netmask, _ := ipv4.NewAddress("255.255.255.0")
+
+	// The capture contains an ARP request, the reply of the captured stack
+	// and a UDP datagram to port 7.
+	f, err := os.Open("testdata/arp_udp.pcap")
+	assert.Nil(t, err)
+	defer f.Close()
+	r, err := NewReader(f)
+	assert.Nil(t, err)
+
+	nic := NewReplayNIC(r, local)
+	eth := ethernet.NewLayer(nic)
+	arp := ipv4.NewARP(local, localIP, eth)
+	udpLayer := udp.NewLayer(ipv4.NewLayer(localIP, ipv4.NewRouter(arp, localIP, netmask, nil), eth))
+	packets := udpLayer.Packets(7)
+	nic.Start()
+	defer nic.Close()
+
+	select {
+	case p := <-packets:
+		assert.Equal(t, remoteIP, p.Address)
+		assert.Equal(t, []byte("hello"), p.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("Replayed UDP packet was not received")
+	}
+	<-nic.Done()
+	assert.Nil(t, nic.Err())
+
+	// The stack answers the ARP request exactly like the captured stack.
+	assert.Nil(t, CompareGolden("testdata/arp_udp.golden.pcap", nic.WaitSent(1, time.Second)))
+}
+
+func TestCompareGolden(t *testing.T) {
+	golden, err := ReadFile("testdata/arp_udp.golden.pcap")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(golden))
+	assert.Nil(t, CompareGolden("testdata/arp_udp.golden.pcap", golden))
+
+	other := golden[0]
+	other.Destination = ethernet.Broadcast
+	tests := []struct {
+		packets  []ethernet.Packet
+		expected bool
+		actual   bool
+	}{
+		{nil, true, false},
+		{[]ethernet.Packet{other}, true, true},
+		{[]ethernet.Packet{golden[0], other}, false, true},
+	}
+	for _, test := range tests {
+		err := CompareGolden("testdata/arp_udp.golden.pcap", test.packets)
+		mismatch, ok := err.(*GoldenMismatchError)
+		assert.True(t, ok, "%v", err)
+		assert.Equal(t, test.expected, mismatch.Expected != nil)
+		assert.Equal(t, test.actual, mismatch.Actual != nil)
+	}
+
+	_, err = ReadFile("testdata/missing.pcap")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,144 @@
+package stack
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/unigornel/go-tcpip/common"
+)
+
+// DefaultQueueLength is the number of datagrams a packet connection
+// queues until they are read. Datagrams that do not fit are dropped.
+const DefaultQueueLength = 64
+
+type datagram struct {
+	payload []byte
+	addr    net.Addr
+}
+
+// packetConn is a datagram connection, used for ICMP.
+//
+// A connection with a remote address implements net.Conn and only
+// receives datagrams from that address.
+type packetConn struct {
+	local  net.Addr
+	remote net.Addr
+	write  func(b []byte, addr net.Addr) error
+	close  func()
+
+	queue     chan datagram
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	readDeadline  *common.Deadline
+	writeDeadline *common.Deadline
+}
+
+func newPacketConn(local, remote net.Addr, write func([]byte, net.Addr) error, close func()) *packetConn {
+	return &packetConn{
+		local:         local,
+		remote:        remote,
+		write:         write,
+		close:         close,
+		queue:         make(chan datagram, DefaultQueueLength),
+		closed:        make(chan struct{}),
+		readDeadline:  common.NewDeadline(),
+		writeDeadline: common.NewDeadline(),
+	}
+}
+
+// deliver queues a received datagram without blocking.
+func (c *packetConn) deliver(payload []byte, addr net.Addr) {
+	if c.remote != nil && c.remote.String() != addr.String() {
+		return
+	}
+	select {
+	case c.queue <- datagram{payload, addr}:
+	default:
+	}
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if common.IsClosed(c.closed) {
+		return 0, nil, net.ErrClosed
+	} else if common.IsClosed(c.readDeadline.Wait()) {
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+
+	select {
+	case d := <-c.queue:
+		return copy(b, d.payload), d.addr, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-c.readDeadline.Wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.remote != nil {
+		return 0, net.ErrWriteToConnected
+	}
+	return c.writeTo(b, addr)
+}
+
+func (c *packetConn) writeTo(b []byte, addr net.Addr) (int, error) {
+	if common.IsClosed(c.closed) {
+		return 0, net.ErrClosed
+	} else if common.IsClosed(c.writeDeadline.Wait()) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	if err := c.write(b, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *packetConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+func (c *packetConn) Write(b []byte) (int, error) {
+	if c.remote == nil {
+		return 0, ErrNotConnected
+	}
+	return c.writeTo(b, c.remote)
+}
+
+func (c *packetConn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.close()
+		err = nil
+	})
+	return err
+}
+
+func (c *packetConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *packetConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *packetConn) SetDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	c.writeDeadline.Set(t)
+	return nil
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	return nil
+}
+
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Set(t)
+	return nil
+}
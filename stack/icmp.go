@@ -0,0 +1,64 @@
+package stack
+
+import (
+	"net"
+
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/icmp"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+// dispatchICMP copies the received ICMP messages of all types to all ICMP
+// connections.
+//
+// Echo requests are answered by the ICMP layer and are not delivered.
+func (s *Stack) dispatchICMP(packets <-chan icmp.Packet) {
+	for p := range packets {
+		payload := common.PacketToBytes(p)
+		addr := &net.IPAddr{IP: net.IP(p.Address.Bytes())}
+
+		s.lock.Lock()
+		for c := range s.icmpConns {
+			c.deliver(payload, addr)
+		}
+		s.lock.Unlock()
+	}
+}
+
+// listenICMP opens a connection that reads and writes complete ICMP
+// messages, including the ICMP header.
+func (s *Stack) listenICMP(remote *net.IPAddr) *packetConn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var c *packetConn
+	remove := func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		delete(s.icmpConns, c)
+	}
+
+	var remoteAddr net.Addr
+	if remote != nil {
+		remoteAddr = remote
+	}
+	local := &net.IPAddr{IP: net.IP(s.Address().Bytes())}
+	c = newPacketConn(local, remoteAddr, s.writeICMP, remove)
+	s.icmpConns[c] = true
+	return c
+}
+
+func (s *Stack) writeICMP(b []byte, addr net.Addr) error {
+	a, ok := addr.(*net.IPAddr)
+	if !ok {
+		return ErrInvalidAddress
+	}
+	destination, ok := ipv4.NewAddress(a.IP.String())
+	if !ok {
+		return ErrInvalidAddress
+	}
+
+	payload := make([]byte, len(b))
+	copy(payload, b)
+	return s.IPv4.Send(ipv4.NewPacketTo(destination, ipv4.ProtocolICMP, payload))
+}
@@ -0,0 +1,62 @@
+package stack
+
+import (
+	"net"
+	"sync"
+
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/udp"
+)
+
+// ListenMulticastUDP announces on the port of a UDP multicast group, and
+// joins the group on the interface with a name until the connection is
+// closed. An empty name is the primary interface.
+//
+// The connection receives the datagrams to the group, and sends datagrams
+// from the address of the route to their destination.
+func (s *Stack) ListenMulticastUDP(network, name string, gaddr *net.UDPAddr) (net.PacketConn, error) {
+	if proto, err := parseNetwork(network); err != nil {
+		return nil, err
+	} else if proto != protocolUDP {
+		return nil, net.UnknownNetworkError(network)
+	}
+	group, ok := ipv4.NewAddress(gaddr.IP.String())
+	if !ok || !group.IsMulticast() {
+		return nil, &net.AddrError{Err: "not a multicast address", Addr: gaddr.String()}
+	} else if gaddr.Port < 0 || gaddr.Port > 0xFFFF {
+		return nil, &net.AddrError{Err: "invalid port", Addr: gaddr.String()}
+	}
+
+	var i ipv4.Interface
+	if name != "" {
+		if i, ok = s.Interface(name); !ok {
+			return nil, &net.AddrError{Err: "no such interface", Addr: name}
+		}
+	}
+
+	socket, err := s.UDP.Bind(group, uint16(gaddr.Port))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.IGMP.Join(i, group); err != nil {
+		socket.Close()
+		return nil, err
+	}
+	return &multicastConn{
+		PacketConn: udp.NewPacketConn(socket),
+		leave:      func() { s.IGMP.Leave(i, group) },
+	}, nil
+}
+
+// multicastConn leaves its group when it is closed.
+type multicastConn struct {
+	net.PacketConn
+	leave     func()
+	leaveOnce sync.Once
+}
+
+func (c *multicastConn) Close() error {
+	err := c.PacketConn.Close()
+	c.leaveOnce.Do(c.leave)
+	return err
+}
@@ -0,0 +1,327 @@
+package stack
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/udp"
+)
+
+// Network is the interface shared by a stack and the host network, so
+// applications can use either of them.
+//
+// The network strings and address types are the same as in the net
+// package. Only IPv4 networks are supported by a stack: "tcp", "tcp4",
+// "udp", "udp4", "ip4:icmp" and "ip4:1".
+type Network interface {
+	Dial(network, address string) (net.Conn, error)
+	Listen(network, address string) (net.Listener, error)
+	ListenPacket(network, address string) (net.PacketConn, error)
+	ListenUDP(network string, laddr *net.UDPAddr) (net.PacketConn, error)
+}
+
+// Resolver looks up the IPv4 addresses of a host name.
+type Resolver interface {
+	LookupHost(host string) ([]ipv4.Address, error)
+}
+
+var (
+	// ErrNoResolver is returned when looking up a host name on a stack
+	// without a resolver.
+	ErrNoResolver = errors.New("no resolver for host names")
+
+	// ErrHostNotFound is returned when a host name has no IPv4 addresses.
+	ErrHostNotFound = errors.New("host not found")
+
+	// ErrAddressNotAvailable is returned when listening on an address
+	// that does not belong to the stack.
+	ErrAddressNotAvailable = errors.New("address not available")
+
+	// ErrInvalidAddress is returned when writing to an address of the
+	// wrong type.
+	ErrInvalidAddress = errors.New("invalid address")
+
+	// ErrNotConnected is returned when writing to an unconnected packet
+	// connection without a destination address.
+	ErrNotConnected = errors.New("destination address required")
+)
+
+// Host is the network of the host operating system.
+var Host Network = host{}
+
+type host struct{}
+
+func (host) Dial(network, address string) (net.Conn, error) {
+	return net.Dial(network, address)
+}
+
+func (host) Listen(network, address string) (net.Listener, error) {
+	return net.Listen(network, address)
+}
+
+func (host) ListenPacket(network, address string) (net.PacketConn, error) {
+	return net.ListenPacket(network, address)
+}
+
+func (host) ListenUDP(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+	return net.ListenUDP(network, laddr)
+}
+
+type protocol int
+
+const (
+	protocolTCP protocol = iota
+	protocolUDP
+	protocolICMP
+)
+
+func parseNetwork(network string) (protocol, error) {
+	switch network {
+	case "tcp", "tcp4":
+		return protocolTCP, nil
+	case "udp", "udp4":
+		return protocolUDP, nil
+	}
+
+	i := strings.IndexByte(network, ':')
+	if i >= 0 && (network[:i] == "ip" || network[:i] == "ip4") {
+		switch network[i+1:] {
+		case "icmp", "1":
+			return protocolICMP, nil
+		}
+	}
+	return 0, net.UnknownNetworkError(network)
+}
+
+// Dial connects to an address on the named network.
+//
+// The address is a host and port for TCP and UDP, and a host for ICMP.
+func (s *Stack) Dial(network, address string) (net.Conn, error) {
+	proto, err := parseNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := s.resolve(proto, address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch proto {
+	case protocolTCP:
+		return s.TCP.Dial(host, port)
+	case protocolUDP:
+		local, err := s.IPv4.Source(host)
+		if err != nil {
+			return nil, err
+		}
+		socket, err := s.UDP.Bind(local, 0)
+		if err != nil {
+			return nil, err
+		}
+		return udp.NewConn(socket, host, port), nil
+	default:
+		return s.listenICMP(&net.IPAddr{IP: net.IP(host.Bytes())}), nil
+	}
+}
+
+// Listen announces on a TCP address.
+//
+// The host of the address must be empty, unspecified or an address of
+// the stack.
+func (s *Stack) Listen(network, address string) (net.Listener, error) {
+	proto, err := parseNetwork(network)
+	if err != nil {
+		return nil, err
+	} else if proto != protocolTCP {
+		return nil, net.UnknownNetworkError(network)
+	}
+	_, port, err := s.local(proto, address)
+	if err != nil {
+		return nil, err
+	}
+	return s.TCP.Listen(port)
+}
+
+// ListenPacket announces on a UDP or ICMP address.
+//
+// The host of the address must be empty, unspecified or an address of
+// the stack. A zero UDP port selects an ephemeral port.
+func (s *Stack) ListenPacket(network, address string) (net.PacketConn, error) {
+	proto, err := parseNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	local, port, err := s.local(proto, address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch proto {
+	case protocolUDP:
+		socket, err := s.UDP.Bind(local, port)
+		if err != nil {
+			return nil, err
+		}
+		return udp.NewPacketConn(socket), nil
+	case protocolICMP:
+		return s.listenICMP(nil), nil
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+}
+
+// ListenUDP announces on a UDP address. If laddr is nil, an ephemeral
+// port is selected.
+func (s *Stack) ListenUDP(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+	if proto, err := parseNetwork(network); err != nil {
+		return nil, err
+	} else if proto != protocolUDP {
+		return nil, net.UnknownNetworkError(network)
+	}
+
+	var local ipv4.Address
+	var port uint16
+	if laddr != nil {
+		var err error
+		if local, err = s.localAddress(laddr.IP); err != nil {
+			return nil, err
+		}
+		if laddr.Port < 0 || laddr.Port > 0xFFFF {
+			return nil, &net.AddrError{Err: "invalid port", Addr: laddr.String()}
+		}
+		port = uint16(laddr.Port)
+	}
+	socket, err := s.UDP.Bind(local, port)
+	if err != nil {
+		return nil, err
+	}
+	return udp.NewPacketConn(socket), nil
+}
+
+// ResolveTCPAddr resolves a TCP address in the same way as the net package.
+func (s *Stack) ResolveTCPAddr(network, address string) (*net.TCPAddr, error) {
+	host, port, err := s.resolveAddr(network, address, protocolTCP)
+	if err != nil {
+		return nil, err
+	}
+	return &net.TCPAddr{IP: host, Port: port}, nil
+}
+
+// ResolveUDPAddr resolves a UDP address in the same way as the net package.
+func (s *Stack) ResolveUDPAddr(network, address string) (*net.UDPAddr, error) {
+	host, port, err := s.resolveAddr(network, address, protocolUDP)
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: host, Port: port}, nil
+}
+
+// ResolveIPAddr resolves an IP address in the same way as the net package.
+func (s *Stack) ResolveIPAddr(network, address string) (*net.IPAddr, error) {
+	if network != "ip" && network != "ip4" {
+		if _, err := parseNetwork(network); err != nil {
+			return nil, err
+		}
+	}
+	host, err := s.lookup(address)
+	if err != nil {
+		return nil, err
+	}
+	return &net.IPAddr{IP: net.IP(host.Bytes())}, nil
+}
+
+func (s *Stack) resolveAddr(network, address string, want protocol) (net.IP, int, error) {
+	proto, err := parseNetwork(network)
+	if err != nil {
+		return nil, 0, err
+	} else if proto != want {
+		return nil, 0, net.UnknownNetworkError(network)
+	}
+	host, port, err := s.resolve(proto, address)
+	if err != nil {
+		return nil, 0, err
+	}
+	return net.IP(host.Bytes()), int(port), nil
+}
+
+// resolve splits an address into a host and a port, and looks up the
+// host. An empty host is the primary address of the stack.
+func (s *Stack) resolve(proto protocol, address string) (ipv4.Address, uint16, error) {
+	host, port, err := splitHostPort(proto, address)
+	if err != nil {
+		return ipv4.Address{}, 0, err
+	}
+	if host == "" {
+		return s.Address(), port, nil
+	}
+	a, err := s.lookup(host)
+	return a, port, err
+}
+
+// local parses a local address to listen on. An empty host is the
+// unspecified address.
+func (s *Stack) local(proto protocol, address string) (ipv4.Address, uint16, error) {
+	host, port, err := splitHostPort(proto, address)
+	if err != nil {
+		return ipv4.Address{}, 0, err
+	}
+	if host == "" {
+		return ipv4.Address{}, port, nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ipv4.Address{}, 0, ErrAddressNotAvailable
+	}
+	a, err := s.localAddress(ip)
+	return a, port, err
+}
+
+// localAddress checks whether the stack can listen on an address.
+func (s *Stack) localAddress(ip net.IP) (ipv4.Address, error) {
+	if ip == nil || ip.IsUnspecified() {
+		return ipv4.Address{}, nil
+	}
+	a, ok := ipv4.NewAddress(ip.String())
+	if !ok || !s.IPv4.HasAddress(a) {
+		return ipv4.Address{}, ErrAddressNotAvailable
+	}
+	return a, nil
+}
+
+func (s *Stack) lookup(host string) (ipv4.Address, error) {
+	if a, ok := ipv4.NewAddress(host); ok {
+		return a, nil
+	} else if net.ParseIP(host) != nil {
+		return ipv4.Address{}, &net.AddrError{Err: "not an IPv4 address", Addr: host}
+	}
+
+	if s.Resolver == nil {
+		return ipv4.Address{}, ErrNoResolver
+	}
+	addresses, err := s.Resolver.LookupHost(host)
+	if err != nil {
+		return ipv4.Address{}, err
+	} else if len(addresses) == 0 {
+		return ipv4.Address{}, ErrHostNotFound
+	}
+	return addresses[0], nil
+}
+
+func splitHostPort(proto protocol, address string) (string, uint16, error) {
+	if proto == protocolICMP {
+		return address, 0, nil
+	}
+
+	host, service, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.ParseUint(service, 10, 16)
+	if err != nil {
+		return "", 0, &net.AddrError{Err: "invalid port", Addr: address}
+	}
+	return host, uint16(port), nil
+}
@@ -0,0 +1,177 @@
+package stack
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/icmp"
+	"github.com/unigornel/go-tcpip/igmp"
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/tcp"
+	"github.com/unigornel/go-tcpip/udp"
+)
+
+// Config is the configuration of a network stack.
+type Config struct {
+	NIC     ethernet.NIC
+	Address ipv4.Address
+	Netmask ipv4.Address
+
+	// Gateway is optional.
+	Gateway *ipv4.Address
+
+	// MTU is the maximum transmission unit of the NIC. If it is zero,
+	// ipv4.DefaultMTU is used.
+	MTU int
+
+	// Addresses are the secondary addresses of the NIC.
+	Addresses []ipv4.InterfaceAddress
+
+	// Interfaces are the other interfaces of the stack.
+	Interfaces []InterfaceConfig
+
+	// Forwarding makes the stack route packets between its interfaces.
+	// See also ipv4.Layer.SetForwarding.
+	Forwarding bool
+}
+
+// InterfaceConfig is the configuration of an interface.
+type InterfaceConfig struct {
+	// Name is the name of the interface. If it is empty, the interface
+	// is named after its position, as in eth1.
+	Name string
+
+	NIC       ethernet.NIC
+	Addresses []ipv4.InterfaceAddress
+
+	// MTU is the maximum transmission unit of the NIC. If it is zero,
+	// ipv4.DefaultMTU is used.
+	MTU int
+}
+
+// Stack is a complete network stack on top of one or more NICs.
+//
+// The layers are exported for applications that need more control than
+// the net-like methods of the stack offer. NIC, Ethernet and ARP belong
+// to the primary interface, which is the first interface of the IPv4
+// layer.
+type Stack struct {
+	NIC      ethernet.NIC
+	Ethernet ethernet.Layer
+	ARP      ipv4.ARP
+	Router   ipv4.Router
+	IPv4     ipv4.Layer
+	ICMP     icmp.Layer
+	IGMP     igmp.Layer
+	UDP      udp.Layer
+	TCP      tcp.Layer
+
+	// Resolver is used to look up host names. If it is nil, only IPv4
+	// literals can be used.
+	Resolver Resolver
+
+	nics []ethernet.NIC
+
+	lock      sync.Mutex
+	icmpConns map[*packetConn]bool
+
+	configLock sync.Mutex
+}
+
+// New assembles the layers of a stack and starts the NICs.
+func New(config Config) *Stack {
+	nic := config.NIC
+	s := &Stack{
+		NIC:       nic,
+		Ethernet:  ethernet.NewLayer(nic),
+		nics:      []ethernet.NIC{nic},
+		icmpConns: make(map[*packetConn]bool),
+	}
+	s.ARP = ipv4.NewARP(nic.GetMAC(), config.Address, s.Ethernet)
+
+	interfaces := []ipv4.Interface{ipv4.NewInterface(ipv4.DefaultInterfaceName, s.Ethernet, s.ARP, mtu(config.MTU))}
+	addresses := [][]ipv4.InterfaceAddress{config.Addresses}
+	if !config.Address.Equals(ipv4.Address{}) {
+		addresses[0] = append([]ipv4.InterfaceAddress{{Address: config.Address, Netmask: config.Netmask}}, config.Addresses...)
+	}
+	for n, c := range config.Interfaces {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("eth%d", n+1)
+		}
+		eth := ethernet.NewLayer(c.NIC)
+		arp := ipv4.NewARP(c.NIC.GetMAC(), ipv4.Address{}, eth)
+		interfaces = append(interfaces, ipv4.NewInterface(name, eth, arp, mtu(c.MTU)))
+		addresses = append(addresses, c.Addresses)
+		s.nics = append(s.nics, c.NIC)
+	}
+
+	s.Router = ipv4.NewInterfaceRouter(interfaces, config.Gateway)
+	s.IPv4 = ipv4.NewInterfaceLayer(
+		s.Router, interfaces,
+		ipv4.DefaultReassemblyTimeout, ipv4.DefaultReassemblyMemory,
+	)
+	s.IPv4.SetForwarding(config.Forwarding)
+	s.ICMP = icmp.NewLayer(s.IPv4)
+	s.IGMP = igmp.NewLayer(s.IPv4)
+	s.UDP = udp.NewCustomLayer(s.IPv4, s.ICMP, udp.DefaultMinEphemeralPort, udp.DefaultMaxEphemeralPort)
+	s.TCP = tcp.NewLayer(s.IPv4)
+
+	go s.dispatchICMP(s.ICMP.AllPackets())
+
+	for _, nic := range s.nics {
+		nic.Start()
+	}
+
+	// The secondary addresses are announced, which requires started NICs.
+	for n, i := range interfaces {
+		for _, a := range addresses[n] {
+			i.AddAddress(a.Address, a.Netmask)
+		}
+	}
+	return s
+}
+
+func mtu(mtu int) int {
+	if mtu == 0 {
+		return ipv4.DefaultMTU
+	}
+	return mtu
+}
+
+// Address returns the primary IPv4 address of the stack.
+func (s *Stack) Address() ipv4.Address {
+	return s.IPv4.Address()
+}
+
+// Interface returns the interface with a name.
+func (s *Stack) Interface(name string) (ipv4.Interface, bool) {
+	for _, i := range s.IPv4.Interfaces() {
+		if i.Name() == name {
+			return i, true
+		}
+	}
+	return nil, false
+}
+
+// Configure replaces the addresses of the primary interface with a single
+// address, and changes the gateway of the stack.
+//
+// The ARP entries for hosts outside the new network are removed, and a
+// gratuitous ARP request announces a new address. Sockets that are bound
+// to the old address no longer receive datagrams.
+func (s *Stack) Configure(address, netmask ipv4.Address, gateway *ipv4.Address) {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+
+	s.Router.Configure(address, netmask, gateway)
+}
+
+// Close stops the NICs of the stack.
+func (s *Stack) Close() error {
+	for _, nic := range s.nics {
+		nic.Close()
+	}
+	return nil
+}
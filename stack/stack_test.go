@@ -0,0 +1,391 @@
+package stack
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/icmp"
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/udp"
+	"github.com/unigornel/go-tcpip/virtual"
+)
+
+var _ Network = &Stack{}
+
+type resolver map[string][]ipv4.Address
+
+func (r resolver) LookupHost(host string) ([]ipv4.Address, error) {
+	return r[host], nil
+}
+
+func newStacks() (*Stack, *Stack) {
+	a, b := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	netmask, _ := ipv4.NewAddress("255.255.255.0")
+	return New(Config{NIC: a, Address: ipv4.Address{10, 0, 0, 1}, Netmask: netmask}),
+		New(Config{NIC: b, Address: ipv4.Address{10, 0, 0, 2}, Netmask: netmask})
+}
+
+func TestResolve(t *testing.T) {
+	a, _ := newStacks()
+	a.Resolver = resolver{"b.example": {{10, 0, 0, 2}}, "none.example": nil}
+
+	tests := []struct {
+		network, address string
+		result           string
+		err              bool
+	}{
+		{"udp4", "10.0.0.2:53", "10.0.0.2:53", false},
+		{"udp", "b.example:53", "10.0.0.2:53", false},
+		{"udp", ":53", "10.0.0.1:53", false},
+		{"udp", "none.example:53", "", true},
+		{"udp", "10.0.0.2", "", true},
+		{"udp", "10.0.0.2:domain", "", true},
+		{"udp", "[::1]:53", "", true},
+		{"udp6", "10.0.0.2:53", "", true},
+		{"tcp", "10.0.0.2:53", "", true},
+	}
+	for _, test := range tests {
+		addr, err := a.ResolveUDPAddr(test.network, test.address)
+		if test.err {
+			assert.NotNil(t, err, "%v %v", test.network, test.address)
+		} else if assert.Nil(t, err, "%v %v", test.network, test.address) {
+			assert.Equal(t, test.result, addr.String())
+		}
+	}
+
+	a.Resolver = nil
+	_, err := a.ResolveTCPAddr("tcp", "b.example:80")
+	assert.Equal(t, ErrNoResolver, err)
+	ip, err := a.ResolveIPAddr("ip4", "10.0.0.2")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2", ip.String())
+}
+
+func TestUDP(t *testing.T) {
+	a, b := newStacks()
+
+	server, err := b.ListenPacket("udp4", ":7")
+	assert.Nil(t, err)
+	defer server.Close()
+	_, err = b.ListenPacket("udp4", ":7")
+	assert.Equal(t, udp.ErrPortInUse, err)
+	_, err = b.ListenPacket("udp4", "10.0.0.1:8")
+	assert.Equal(t, ErrAddressNotAvailable, err)
+
+	client, err := a.Dial("udp4", "10.0.0.2:7")
+	assert.Nil(t, err)
+	defer client.Close()
+	assert.Equal(t, "10.0.0.2:7", client.RemoteAddr().String())
+
+	_, err = client.Write([]byte("hello"))
+	assert.Nil(t, err)
+	buf := make([]byte, 100)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, addr, err := server.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	assert.Equal(t, client.LocalAddr().String(), addr.String())
+
+	_, err = server.WriteTo([]byte("world"), addr)
+	assert.Nil(t, err)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err = client.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "world", string(buf[:n]))
+
+	// Large datagrams are fragmented.
+	large := make([]byte, 4000)
+	for i := range large {
+		large[i] = byte(i)
+	}
+	_, err = client.Write(large)
+	assert.Nil(t, err)
+	buf = make([]byte, 5000)
+	n, _, err = server.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, large, buf[:n])
+
+	// Deadlines
+	server.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, _, err = server.ReadFrom(buf)
+	assert.Equal(t, os.ErrDeadlineExceeded, err)
+
+	// Closing releases the port.
+	assert.Nil(t, server.Close())
+	_, _, err = server.ReadFrom(buf)
+	assert.Equal(t, net.ErrClosed, err)
+	again, err := b.ListenUDP("udp", &net.UDPAddr{Port: 7})
+	assert.Nil(t, err)
+	again.Close()
+}
+
+func TestUDPUnreachable(t *testing.T) {
+	a, b := newStacks()
+
+	// Port unreachable
+	client, err := a.Dial("udp4", "10.0.0.2:9")
+	assert.Nil(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("hello"))
+	assert.Nil(t, err)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = client.Read(make([]byte, 100))
+	assert.Equal(t, udp.ErrConnectionRefused, err)
+
+	// Protocol unreachable
+	errors := a.ICMP.Errors(253)
+	p := ipv4.NewPacketTo(ipv4.Address{10, 0, 0, 2}, 253, []byte("hello"))
+	assert.Nil(t, a.IPv4.Send(p))
+	select {
+	case m := <-errors:
+		assert.Equal(t, icmp.Code(icmp.ProtocolUnreachableCode), m.Header.Code)
+		assert.Equal(t, b.Address(), m.Address)
+	case <-time.After(5 * time.Second):
+		t.Fatal("No protocol unreachable message received")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	a, b := newStacks()
+	netmask := ipv4.Address{255, 255, 255, 0}
+
+	server, err := b.ListenPacket("udp4", ":7")
+	assert.Nil(t, err)
+	defer server.Close()
+	client, err := a.ListenPacket("udp4", ":1234")
+	assert.Nil(t, err)
+	defer client.Close()
+
+	buf := make([]byte, 100)
+	for _, network := range []byte{0, 1} {
+		if network == 1 {
+			a.Configure(ipv4.Address{10, 0, 1, 1}, netmask, nil)
+			b.Configure(ipv4.Address{10, 0, 1, 2}, netmask, nil)
+			assert.Equal(t, ipv4.Address{10, 0, 1, 1}, a.Address())
+		}
+
+		_, err = client.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.IPv4(10, 0, network, 2), Port: 7})
+		assert.Nil(t, err)
+		server.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, addr, err := server.ReadFrom(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("10.0.%v.1:1234", network), addr.String())
+	}
+}
+
+func TestInterfaces(t *testing.T) {
+	a0, b := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	a1, c := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 1, 1}, ethernet.MAC{0x02, 0, 0, 0, 1, 2})
+	netmask := ipv4.Address{255, 255, 255, 0}
+	a := New(Config{
+		NIC:       a0,
+		Address:   ipv4.Address{10, 0, 0, 1},
+		Netmask:   netmask,
+		Addresses: []ipv4.InterfaceAddress{{Address: ipv4.Address{10, 0, 0, 10}, Netmask: netmask}},
+		Interfaces: []InterfaceConfig{{
+			NIC:       a1,
+			Addresses: []ipv4.InterfaceAddress{{Address: ipv4.Address{10, 0, 1, 1}, Netmask: netmask}},
+		}},
+	})
+	defer a.Close()
+	sb := New(Config{NIC: b, Address: ipv4.Address{10, 0, 0, 2}, Netmask: netmask})
+	sc := New(Config{NIC: c, Address: ipv4.Address{10, 0, 1, 2}, Netmask: netmask})
+
+	eth1, ok := a.Interface("eth1")
+	assert.True(t, ok)
+	assert.Equal(t, []ipv4.InterfaceAddress{{Address: ipv4.Address{10, 0, 1, 1}, Netmask: netmask}}, eth1.Addresses())
+	assert.Equal(t, ipv4.Address{10, 0, 0, 1}, a.Address())
+
+	buf := make([]byte, 100)
+	read := func(c net.PacketConn) string {
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, addr, err := c.ReadFrom(buf)
+		assert.Nil(t, err)
+		if err != nil {
+			return ""
+		}
+		return addr.String()
+	}
+
+	// Datagrams to the secondary address are received.
+	server, err := a.ListenPacket("udp4", "10.0.0.10:7")
+	assert.Nil(t, err)
+	defer server.Close()
+	client, err := sb.ListenPacket("udp4", ":1234")
+	assert.Nil(t, err)
+	defer client.Close()
+	_, err = client.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 10), Port: 7})
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2:1234", read(server))
+
+	// Replies are sent from the bound address.
+	_, err = server.WriteTo([]byte("world"), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1234})
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.10:7", read(client))
+
+	// Echo requests to the secondary address are answered from it.
+	pinger, err := icmp.NewPinger(sb.ICMP, ipv4.Address{10, 0, 0, 10})
+	assert.Nil(t, err)
+	defer pinger.Close()
+	_, err = pinger.Ping(5 * time.Second)
+	assert.Nil(t, err)
+
+	// The interface and source address are selected by destination.
+	other, err := sc.ListenPacket("udp4", ":7")
+	assert.Nil(t, err)
+	defer other.Close()
+	conn, err := a.Dial("udp4", "10.0.1.2:7")
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Equal(t, "10.0.1.1", conn.LocalAddr().(*net.UDPAddr).IP.String())
+	_, err = conn.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, conn.LocalAddr().String(), read(other))
+
+	_, err = a.ListenPacket("udp4", "10.0.2.1:7")
+	assert.Equal(t, ErrAddressNotAvailable, err)
+	_, err = a.Dial("udp4", "10.0.2.1:7")
+	assert.Equal(t, ipv4.ErrNoRouteToDestinationAddress, err)
+}
+
+func TestForwarding(t *testing.T) {
+	a0, b := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	a1, c := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 1, 1}, ethernet.MAC{0x02, 0, 0, 0, 1, 2})
+	netmask := ipv4.Address{255, 255, 255, 0}
+	a := New(Config{
+		NIC:     a0,
+		Address: ipv4.Address{10, 0, 0, 1},
+		Netmask: netmask,
+		Interfaces: []InterfaceConfig{{
+			NIC:       a1,
+			Addresses: []ipv4.InterfaceAddress{{Address: ipv4.Address{10, 0, 1, 1}, Netmask: netmask}},
+		}},
+		Forwarding: true,
+	})
+	defer a.Close()
+	gb, gc := ipv4.Address{10, 0, 0, 1}, ipv4.Address{10, 0, 1, 1}
+	sb := New(Config{NIC: b, Address: ipv4.Address{10, 0, 0, 2}, Netmask: netmask, Gateway: &gb})
+	sc := New(Config{NIC: c, Address: ipv4.Address{10, 0, 1, 2}, Netmask: netmask, Gateway: &gc})
+
+	server, err := sc.ListenPacket("udp4", ":7")
+	assert.Nil(t, err)
+	defer server.Close()
+	client, err := sb.Dial("udp4", "10.0.1.2:7")
+	assert.Nil(t, err)
+	defer client.Close()
+
+	_, err = client.Write([]byte("hello"))
+	assert.Nil(t, err)
+	buf := make([]byte, 100)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, addr, err := server.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	assert.Equal(t, "10.0.0.2:"+fmt.Sprint(client.LocalAddr().(*net.UDPAddr).Port), addr.String())
+
+	pinger, err := icmp.NewPinger(sb.ICMP, ipv4.Address{10, 0, 1, 2})
+	assert.Nil(t, err)
+	defer pinger.Close()
+	_, err = pinger.Ping(5 * time.Second)
+	assert.Nil(t, err)
+
+	// Without forwarding
+	a.IPv4.SetForwarding(false)
+	_, err = pinger.Ping(100 * time.Millisecond)
+	assert.NotNil(t, err)
+}
+
+func TestMulticast(t *testing.T) {
+	a, b := newStacks()
+
+	group := &net.UDPAddr{IP: net.IPv4(239, 1, 2, 3), Port: 5000}
+	server, err := b.ListenMulticastUDP("udp4", "", group)
+	assert.Nil(t, err)
+	assert.Equal(t, []ipv4.Address{{239, 1, 2, 3}}, b.IGMP.Groups(nil))
+	_, err = b.ListenMulticastUDP("udp4", "eth9", group)
+	assert.NotNil(t, err)
+	_, err = b.ListenMulticastUDP("udp4", "", &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000})
+	assert.NotNil(t, err)
+
+	client, err := a.Dial("udp4", group.String())
+	assert.Nil(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("hello"))
+	assert.Nil(t, err)
+
+	buf := make([]byte, 100)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, addr, err := server.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	assert.Equal(t, client.LocalAddr().String(), addr.String())
+
+	// The group is left when the connection is closed.
+	assert.Nil(t, server.Close())
+	assert.Equal(t, 0, len(b.IGMP.Groups(nil)))
+}
+
+func TestTCP(t *testing.T) {
+	a, b := newStacks()
+
+	l, err := b.Listen("tcp4", "0.0.0.0:80")
+	assert.Nil(t, err)
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+
+	client, err := a.Dial("tcp", "10.0.0.2:80")
+	assert.Nil(t, err)
+	server := <-accepted
+	defer client.Close()
+	defer server.Close()
+
+	_, err = client.Write([]byte("hello"))
+	assert.Nil(t, err)
+	buf := make([]byte, 100)
+	n, err := server.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+}
+
+func TestICMP(t *testing.T) {
+	a, _ := newStacks()
+
+	c, err := a.ListenPacket("ip4:icmp", "0.0.0.0")
+	assert.Nil(t, err)
+	defer c.Close()
+
+	request := common.PacketToBytes(icmp.NewEchoRequest(1, 2, []byte("ping")))
+	_, err = c.WriteTo(request, &net.IPAddr{IP: net.IPv4(10, 0, 0, 2)})
+	assert.Nil(t, err)
+
+	buf := make([]byte, 100)
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, addr, err := c.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2", addr.String())
+	assert.Equal(t, common.PacketToBytes(icmp.NewEchoReply(1, 2, []byte("ping"))), buf[:n])
+
+	// Error messages are delivered as well.
+	u, err := a.Dial("udp", "10.0.0.2:9")
+	assert.Nil(t, err)
+	defer u.Close()
+	_, err = u.Write([]byte("discard"))
+	assert.Nil(t, err)
+	n, addr, err = c.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2", addr.String())
+	assert.Equal(t, uint8(icmp.DestinationUnreachableType), buf[0])
+
+	_, err = a.Dial("ip4:tcp", "10.0.0.2")
+	assert.Equal(t, net.UnknownNetworkError("ip4:tcp"), err)
+}
@@ -0,0 +1,947 @@
+package tcp
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+const (
+	// DefaultMSS is the maximum segment size assumed for peers that do not
+	// announce one.
+	DefaultMSS = 536
+
+	// MaxMSS is the maximum segment size announced to peers. It is the
+	// Ethernet payload size minus the IPv4 and TCP headers.
+	MaxMSS = 1500 - 20 - 20
+
+	// InitialRTO is the retransmission timeout before the round-trip time
+	// has been measured.
+	InitialRTO = 1 * time.Second
+
+	// MinRTO is the lower bound of the retransmission timeout.
+	MinRTO = 200 * time.Millisecond
+
+	// MaxRTO is the upper bound of the retransmission timeout.
+	MaxRTO = 60 * time.Second
+
+	// MaxRetransmissions is the number of times a segment is retransmitted
+	// before the connection is aborted with ErrTimeout.
+	MaxRetransmissions = 8
+
+	// sendQueueLength is the number of segments that are queued for the
+	// IPv4 layer per connection.
+	sendQueueLength = 64
+)
+
+type state int
+
+const (
+	stateClosed state = iota
+	stateListen
+	stateSynSent
+	stateSynReceived
+	stateEstablished
+	stateFinWait1
+	stateFinWait2
+	stateCloseWait
+	stateClosing
+	stateLastAck
+	stateTimeWait
+)
+
+var stateNames = []string{
+	"CLOSED", "LISTEN", "SYN-SENT", "SYN-RECEIVED", "ESTABLISHED",
+	"FIN-WAIT-1", "FIN-WAIT-2", "CLOSE-WAIT", "CLOSING", "LAST-ACK",
+	"TIME-WAIT",
+}
+
+func (s state) String() string {
+	return stateNames[s]
+}
+
+// Sequence number comparisons modulo 2^32.
+func seqLT(a, b uint32) bool  { return int32(a-b) < 0 }
+func seqLEQ(a, b uint32) bool { return int32(a-b) <= 0 }
+func seqGT(a, b uint32) bool  { return int32(a-b) > 0 }
+
+type segment struct {
+	seq  uint32
+	data []byte
+	fin  bool
+}
+
+type conn struct {
+	layer    *layer
+	id       connID
+	local    ipv4.Address
+	listener *listener
+
+	lock   sync.Mutex
+	cond   *sync.Cond
+	state  state
+	err    error
+	closed bool
+	out    chan Packet
+
+	// Send sequence space. The send buffer holds all unacknowledged and
+	// unsent data, starting at sndUna. sndMax is the highest sequence
+	// number sent so far, sndNxt goes back to sndUna on retransmission.
+	iss        uint32
+	sndUna     uint32
+	sndNxt     uint32
+	sndMax     uint32
+	sndWnd     uint32
+	sndWl1     uint32
+	sndWl2     uint32
+	sndMSS     int
+	sendBuffer []byte
+	finQueued  bool
+	finSent    bool
+	dupAcks    int
+
+	// Receive sequence space.
+	irs           uint32
+	rcvNxt        uint32
+	rcvAdvertised uint32
+	recvBuffer    []byte
+	outOfOrder    []segment
+	finReceived   bool
+
+	// Retransmission, see RFC 6298.
+	srtt         time.Duration
+	rttvar       time.Duration
+	rto          time.Duration
+	rttMeasuring bool
+	rttSeq       uint32
+	rttStart     time.Time
+	retransmits  int
+	timer        *time.Timer
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readTimer     *time.Timer
+	writeTimer    *time.Timer
+}
+
+func newConn(layer *layer, id connID, local ipv4.Address) *conn {
+	iss := rand.Uint32()
+	c := &conn{
+		layer:  layer,
+		id:     id,
+		local:  local,
+		out:    make(chan Packet, sendQueueLength),
+		iss:    iss,
+		sndUna: iss,
+		sndNxt: iss,
+		sndMax: iss,
+		sndMSS: DefaultMSS,
+		rto:    InitialRTO,
+	}
+	c.cond = sync.NewCond(&c.lock)
+	go c.sendAll()
+	return c
+}
+
+func (c *conn) sendAll() {
+	for p := range c.out {
+		c.layer.send(c.local, p)
+	}
+}
+
+// connect performs an active open and waits until the connection is
+// established or fails.
+func (c *conn) connect() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.state = stateSynSent
+	c.sendSYN()
+	for c.state == stateSynSent {
+		c.cond.Wait()
+	}
+	if c.state != stateEstablished && c.state != stateCloseWait {
+		return c.err
+	}
+	return nil
+}
+
+// transmit queues a segment for the IPv4 layer.
+//
+// The segment is dropped if the queue is full. It will be retransmitted
+// later on like any other lost segment.
+func (c *conn) transmit(flags Flags, seq uint32, data []byte) {
+	if c.out == nil {
+		return
+	}
+
+	p := Packet{
+		Header: Header{
+			SourcePort:      c.id.localPort,
+			DestinationPort: c.id.remotePort,
+			SequenceNumber:  seq,
+			DataOffset:      5,
+			Flags:           flags,
+		},
+		Payload: data,
+		Address: c.id.remote,
+	}
+	if flags&FlagACK != 0 {
+		window := c.window()
+		p.AcknowledgmentNumber = c.rcvNxt
+		p.Window = uint16(window)
+		c.rcvAdvertised = c.rcvNxt + window
+	}
+	if flags&FlagSYN != 0 {
+		p.Options = MSSOption(MaxMSS)
+		p.DataOffset = 6
+	}
+
+	select {
+	case c.out <- p:
+	default:
+	}
+}
+
+func (c *conn) sendACK() {
+	c.transmit(FlagACK, c.sndNxt, nil)
+}
+
+func (c *conn) sendSYN() {
+	flags := Flags(FlagSYN)
+	if c.state == stateSynReceived {
+		flags |= FlagACK
+	}
+	c.transmit(flags, c.iss, nil)
+	c.rttMeasuring = c.retransmits == 0
+	c.rttStart = time.Now()
+	c.sndNxt = c.iss + 1
+	if seqLT(c.sndMax, c.sndNxt) {
+		c.sndMax = c.sndNxt
+	}
+	c.startTimer()
+}
+
+// window returns the receive window.
+func (c *conn) window() uint32 {
+	w := c.layer.bufferSize - len(c.recvBuffer)
+	if w < 0 {
+		w = 0
+	}
+	return uint32(w)
+}
+
+// output sends as much unsent data as the send window allows, followed by
+// a FIN if the connection is being closed.
+func (c *conn) output() {
+	switch c.state {
+	case stateEstablished, stateCloseWait, stateFinWait1, stateClosing, stateLastAck:
+	default:
+		return
+	}
+
+	for {
+		inFlight := int(c.sndNxt - c.sndUna)
+		if c.finSent || inFlight > len(c.sendBuffer) {
+			return
+		}
+
+		unsent := len(c.sendBuffer) - inFlight
+		if unsent > 0 {
+			window := int(c.sndWnd) - inFlight
+			if window <= 0 {
+				// Probe the zero window when the timer expires.
+				if inFlight == 0 {
+					c.startTimer()
+				}
+				return
+			}
+
+			n := unsent
+			if n > window {
+				n = window
+			}
+			if n > c.sndMSS {
+				n = c.sndMSS
+			}
+			flags := Flags(FlagACK)
+			if n == unsent {
+				flags |= FlagPSH
+			}
+			c.transmitData(flags, inFlight, n)
+			continue
+		}
+
+		if c.finQueued {
+			c.transmit(FlagFIN|FlagACK, c.sndNxt, nil)
+			c.finSent = true
+			c.advance(1)
+		}
+		return
+	}
+}
+
+// transmitData sends n bytes of the send buffer, starting at offset.
+func (c *conn) transmitData(flags Flags, offset, n int) {
+	c.transmit(flags, c.sndNxt, c.sendBuffer[offset:offset+n])
+	c.advance(uint32(n))
+}
+
+// advance moves sndNxt after sending new sequence numbers.
+func (c *conn) advance(n uint32) {
+	if !c.rttMeasuring && c.sndNxt == c.sndMax {
+		c.rttMeasuring = true
+		c.rttSeq = c.sndNxt
+		c.rttStart = time.Now()
+	}
+
+	c.sndNxt += n
+	if seqLT(c.sndMax, c.sndNxt) {
+		c.sndMax = c.sndNxt
+	}
+	if c.timer == nil || c.sndUna == c.sndNxt-n {
+		c.startTimer()
+	}
+}
+
+func (c *conn) startTimer() {
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.rto, c.timeout)
+	} else {
+		c.timer.Reset(c.rto)
+	}
+}
+
+func (c *conn) stopTimer() {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
+
+// timeout handles the expiry of the retransmission timer.
+func (c *conn) timeout() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var zeroWindowProbe bool
+	switch c.state {
+	case stateSynSent, stateSynReceived:
+	case stateEstablished, stateCloseWait, stateFinWait1, stateClosing, stateLastAck:
+		if c.sndWnd == 0 && len(c.sendBuffer) > 0 {
+			zeroWindowProbe = true
+		} else if c.sndUna == c.sndMax {
+			return
+		}
+	default:
+		return
+	}
+
+	// A peer that keeps advertising a zero window is not a reason to
+	// give up on the connection.
+	if c.sndWnd != 0 || c.state == stateSynSent || c.state == stateSynReceived {
+		c.retransmits++
+		if c.retransmits > MaxRetransmissions {
+			c.abort(ErrTimeout)
+			return
+		}
+	}
+	c.rto *= 2
+	if c.rto > MaxRTO {
+		c.rto = MaxRTO
+	}
+	c.rttMeasuring = false
+
+	switch {
+	case c.state == stateSynSent || c.state == stateSynReceived:
+		c.sendSYN()
+	case zeroWindowProbe:
+		c.sndNxt = c.sndUna
+		c.transmitData(FlagACK, 0, 1)
+	default:
+		// Go back to the first unacknowledged segment.
+		c.sndNxt = c.sndUna
+		c.finSent = false
+		c.dupAcks = 0
+		c.output()
+		c.startTimer()
+	}
+}
+
+// updateRTO updates the retransmission timeout with a round-trip time
+// measurement.
+func (c *conn) updateRTO(r time.Duration) {
+	if c.srtt == 0 {
+		c.srtt = r
+		c.rttvar = r / 2
+	} else {
+		delta := c.srtt - r
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttvar = (3*c.rttvar + delta) / 4
+		c.srtt = (7*c.srtt + r) / 8
+	}
+
+	c.rto = c.srtt + 4*c.rttvar
+	if c.rto < MinRTO {
+		c.rto = MinRTO
+	} else if c.rto > MaxRTO {
+		c.rto = MaxRTO
+	}
+}
+
+// handle processes an incoming segment, following the event processing
+// section of RFC 793.
+func (c *conn) handle(p Packet) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	switch c.state {
+	case stateClosed:
+		return
+	case stateListen:
+		c.handleListen(p)
+		return
+	case stateSynSent:
+		c.handleSynSent(p)
+		return
+	}
+
+	if !c.acceptable(p) {
+		if p.Flags&FlagRST == 0 {
+			c.sendACK()
+		}
+		return
+	}
+
+	if p.Flags&FlagRST != 0 {
+		if c.state == stateSynReceived && c.listener != nil {
+			c.terminate()
+		} else {
+			c.abort(ErrConnectionReset)
+		}
+		return
+	}
+
+	if p.Flags&FlagSYN != 0 {
+		c.transmit(FlagRST, c.sndNxt, nil)
+		c.abort(ErrConnectionReset)
+		return
+	}
+
+	if p.Flags&FlagACK == 0 {
+		return
+	}
+
+	if c.state == stateSynReceived {
+		if seqLEQ(p.AcknowledgmentNumber, c.sndUna) || seqGT(p.AcknowledgmentNumber, c.sndMax) {
+			c.transmit(FlagRST, p.AcknowledgmentNumber, nil)
+			return
+		}
+		c.establish(p)
+		if c.listener != nil && !c.listener.established(c) {
+			c.transmit(FlagRST, c.sndNxt, nil)
+			c.terminate()
+			return
+		}
+	}
+
+	if !c.handleACK(p) {
+		return
+	}
+
+	switch c.state {
+	case stateEstablished, stateFinWait1, stateFinWait2:
+		c.receive(segment{
+			seq:  p.SequenceNumber,
+			data: p.Payload,
+			fin:  p.Flags&FlagFIN != 0,
+		})
+	case stateTimeWait:
+		if p.Flags&FlagFIN != 0 {
+			c.sendACK()
+			c.enterTimeWait()
+		}
+	}
+
+	c.output()
+}
+
+func (c *conn) handleListen(p Packet) {
+	if p.Flags&(FlagSYN|FlagACK|FlagRST) != FlagSYN {
+		return
+	}
+	c.irs = p.SequenceNumber
+	c.rcvNxt = p.SequenceNumber + 1
+	c.setMSS(p)
+	c.state = stateSynReceived
+	c.sendSYN()
+}
+
+func (c *conn) handleSynSent(p Packet) {
+	ack := p.Flags&FlagACK != 0
+	if ack && (seqLEQ(p.AcknowledgmentNumber, c.iss) || seqGT(p.AcknowledgmentNumber, c.sndMax)) {
+		if p.Flags&FlagRST == 0 {
+			c.transmit(FlagRST, p.AcknowledgmentNumber, nil)
+		}
+		return
+	}
+
+	if p.Flags&FlagRST != 0 {
+		if ack {
+			c.abort(ErrConnectionRefused)
+		}
+		return
+	}
+
+	if p.Flags&FlagSYN == 0 {
+		return
+	}
+
+	c.irs = p.SequenceNumber
+	c.rcvNxt = p.SequenceNumber + 1
+	c.setMSS(p)
+	if ack {
+		c.establish(p)
+		c.sendACK()
+	} else {
+		// Simultaneous open.
+		c.state = stateSynReceived
+		c.sendSYN()
+	}
+}
+
+func (c *conn) setMSS(p Packet) {
+	c.sndMSS = DefaultMSS
+	if mss, ok := p.MSS(); ok && mss > 0 {
+		c.sndMSS = int(mss)
+	}
+	if c.sndMSS > MaxMSS {
+		c.sndMSS = MaxMSS
+	}
+}
+
+// establish moves the connection to the ESTABLISHED state when our SYN is
+// acknowledged.
+func (c *conn) establish(p Packet) {
+	if c.rttMeasuring && c.retransmits == 0 {
+		c.updateRTO(time.Since(c.rttStart))
+	}
+	c.rttMeasuring = false
+	c.retransmits = 0
+	c.stopTimer()
+
+	c.sndUna = c.iss + 1
+	c.sndWnd = uint32(p.Window)
+	c.sndWl1 = p.SequenceNumber
+	c.sndWl2 = p.AcknowledgmentNumber
+	c.state = stateEstablished
+	c.cond.Broadcast()
+}
+
+// acceptable checks whether a segment falls in the receive window.
+func (c *conn) acceptable(p Packet) bool {
+	seq := p.SequenceNumber
+	length := p.Length()
+	window := c.window()
+	if seqLT(c.rcvNxt+window, c.rcvAdvertised) {
+		window = c.rcvAdvertised - c.rcvNxt
+	}
+
+	switch {
+	case length == 0 && window == 0:
+		return seq == c.rcvNxt
+	case length == 0:
+		return seqLEQ(c.rcvNxt, seq) && seqLT(seq, c.rcvNxt+window)
+	case window == 0:
+		return false
+	default:
+		end := seq + length - 1
+		return (seqLEQ(c.rcvNxt, seq) && seqLT(seq, c.rcvNxt+window)) ||
+			(seqLEQ(c.rcvNxt, end) && seqLT(end, c.rcvNxt+window))
+	}
+}
+
+// handleACK processes the acknowledgment of a segment. It returns false if
+// the segment should not be processed any further.
+func (c *conn) handleACK(p Packet) bool {
+	ack := p.AcknowledgmentNumber
+	if seqGT(ack, c.sndMax) {
+		c.sendACK()
+		return false
+	}
+
+	if seqGT(ack, c.sndUna) {
+		acked := int(ack - c.sndUna)
+		if acked > len(c.sendBuffer) {
+			// Our FIN has been acknowledged.
+			acked = len(c.sendBuffer)
+			c.finQueued = false
+		}
+		c.sendBuffer = c.sendBuffer[acked:]
+		c.sndUna = ack
+		if seqLT(c.sndNxt, ack) {
+			c.sndNxt = ack
+		}
+
+		if c.rttMeasuring && seqGT(ack, c.rttSeq) {
+			c.rttMeasuring = false
+			if c.retransmits == 0 {
+				c.updateRTO(time.Since(c.rttStart))
+			}
+		}
+		c.retransmits = 0
+		c.dupAcks = 0
+		if c.sndUna == c.sndMax {
+			c.stopTimer()
+		} else {
+			c.startTimer()
+		}
+		c.cond.Broadcast()
+
+	} else if ack == c.sndUna && c.sndUna != c.sndMax &&
+		len(p.Payload) == 0 && uint32(p.Window) == c.sndWnd {
+		// Fast retransmit after three duplicate acknowledgments.
+		c.dupAcks++
+		if c.dupAcks == 3 && len(c.sendBuffer) > 0 {
+			n := len(c.sendBuffer)
+			if n > c.sndMSS {
+				n = c.sndMSS
+			}
+			c.transmit(FlagACK, c.sndUna, c.sendBuffer[:n])
+		}
+	}
+
+	if seqLT(c.sndWl1, p.SequenceNumber) ||
+		(c.sndWl1 == p.SequenceNumber && seqLEQ(c.sndWl2, ack)) {
+		c.sndWnd = uint32(p.Window)
+		c.sndWl1 = p.SequenceNumber
+		c.sndWl2 = ack
+		c.cond.Broadcast()
+	}
+
+	finAcked := c.finSent && !c.finQueued
+	switch c.state {
+	case stateFinWait1:
+		if finAcked {
+			c.state = stateFinWait2
+		}
+	case stateClosing:
+		if finAcked {
+			c.enterTimeWait()
+		}
+	case stateLastAck:
+		if finAcked {
+			c.terminate()
+			return false
+		}
+	}
+	return true
+}
+
+// receive processes the data and FIN of an acceptable segment.
+func (c *conn) receive(s segment) {
+	if len(s.data) == 0 && !s.fin {
+		return
+	}
+
+	// Trim the part that has already been received.
+	if seqLT(s.seq, c.rcvNxt) {
+		skip := c.rcvNxt - s.seq
+		if int(skip) > len(s.data) {
+			c.sendACK()
+			return
+		}
+		s.data = s.data[skip:]
+		s.seq = c.rcvNxt
+		if len(s.data) == 0 && !s.fin {
+			c.sendACK()
+			return
+		}
+	}
+
+	// Trim the part that does not fit in the window.
+	window := int(c.window())
+	if offset := int(s.seq - c.rcvNxt); offset+len(s.data) > window {
+		if offset >= window {
+			c.sendACK()
+			return
+		}
+		s.data = s.data[:window-offset]
+		s.fin = false
+	}
+
+	if s.seq != c.rcvNxt {
+		c.queueOutOfOrder(s)
+		c.sendACK()
+		return
+	}
+
+	c.deliver(s)
+	for len(c.outOfOrder) > 0 && !c.finReceived {
+		next := c.outOfOrder[0]
+		if seqGT(next.seq, c.rcvNxt) {
+			break
+		}
+		c.outOfOrder = c.outOfOrder[1:]
+
+		skip := c.rcvNxt - next.seq
+		if int(skip) > len(next.data) || (int(skip) == len(next.data) && !next.fin) {
+			continue
+		}
+		next.data = next.data[skip:]
+		next.seq = c.rcvNxt
+		c.deliver(next)
+	}
+	c.sendACK()
+}
+
+// deliver appends in-order data to the receive buffer.
+func (c *conn) deliver(s segment) {
+	c.recvBuffer = append(c.recvBuffer, s.data...)
+	c.rcvNxt += uint32(len(s.data))
+	if s.fin {
+		c.rcvNxt++
+		c.handleFIN()
+	}
+	c.cond.Broadcast()
+}
+
+// queueOutOfOrder stores a segment that arrived ahead of rcvNxt, keeping
+// the queue sorted by sequence number.
+func (c *conn) queueOutOfOrder(s segment) {
+	s.data = append([]byte(nil), s.data...)
+
+	i := 0
+	for i < len(c.outOfOrder) && seqLEQ(c.outOfOrder[i].seq, s.seq) {
+		if o := c.outOfOrder[i]; o.seq == s.seq && len(o.data) >= len(s.data) {
+			return
+		}
+		i++
+	}
+	c.outOfOrder = append(c.outOfOrder, segment{})
+	copy(c.outOfOrder[i+1:], c.outOfOrder[i:])
+	c.outOfOrder[i] = s
+}
+
+func (c *conn) handleFIN() {
+	c.finReceived = true
+	c.outOfOrder = nil
+
+	switch c.state {
+	case stateEstablished:
+		c.state = stateCloseWait
+	case stateFinWait1:
+		if c.finSent && !c.finQueued {
+			c.enterTimeWait()
+		} else {
+			c.state = stateClosing
+		}
+	case stateFinWait2:
+		c.enterTimeWait()
+	}
+}
+
+func (c *conn) enterTimeWait() {
+	c.state = stateTimeWait
+	c.stopTimer()
+	c.timer = time.AfterFunc(2*c.layer.msl, c.timeWaitExpired)
+	c.cond.Broadcast()
+}
+
+func (c *conn) timeWaitExpired() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.state == stateTimeWait {
+		c.terminate()
+	}
+}
+
+// terminate removes the connection from the layer.
+func (c *conn) terminate() {
+	c.state = stateClosed
+	c.stopTimer()
+	if c.out != nil {
+		close(c.out)
+		c.out = nil
+	}
+	c.layer.remove(c)
+	c.cond.Broadcast()
+}
+
+// abort terminates the connection with an error.
+func (c *conn) abort(err error) {
+	c.err = err
+	c.sendBuffer = nil
+	c.terminate()
+}
+
+// reset aborts the connection and sends a reset to the peer.
+func (c *conn) reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.state != stateClosed {
+		c.transmit(FlagRST|FlagACK, c.sndNxt, nil)
+		c.abort(ErrConnectionReset)
+	}
+}
+
+// wait waits until the condition is signalled or the deadline expires.
+func (c *conn) wait(deadline time.Time) error {
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return os.ErrDeadlineExceeded
+	}
+	c.cond.Wait()
+	return nil
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for len(c.recvBuffer) == 0 {
+		if c.closed {
+			return 0, ErrClosed
+		} else if c.finReceived {
+			return 0, io.EOF
+		} else if c.err != nil {
+			return 0, c.err
+		} else if c.state == stateClosed {
+			return 0, io.EOF
+		}
+		if err := c.wait(c.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+
+	before := c.window()
+	n := copy(b, c.recvBuffer)
+	c.recvBuffer = c.recvBuffer[n:]
+	if len(c.recvBuffer) == 0 {
+		c.recvBuffer = nil
+	}
+
+	// Announce the window if it opened significantly.
+	if after := c.window(); before < uint32(c.sndMSS) && after >= uint32(c.sndMSS) {
+		c.sendACK()
+	}
+	return n, nil
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	written := 0
+	for written < len(b) {
+		if c.closed {
+			return written, ErrClosed
+		} else if c.err != nil {
+			return written, c.err
+		} else if c.state != stateEstablished && c.state != stateCloseWait {
+			return written, ErrClosed
+		}
+
+		space := c.layer.bufferSize - len(c.sendBuffer)
+		if space <= 0 {
+			if err := c.wait(c.writeDeadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		n := len(b) - written
+		if n > space {
+			n = space
+		}
+		c.sendBuffer = append(c.sendBuffer, b[written:written+n]...)
+		written += n
+		c.output()
+	}
+	return written, nil
+}
+
+// Close closes the connection.
+//
+// Queued data is still sent and the connection is shut down gracefully in
+// the background.
+func (c *conn) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+	c.cond.Broadcast()
+
+	switch c.state {
+	case stateListen, stateSynSent:
+		c.terminate()
+	case stateSynReceived, stateEstablished:
+		c.state = stateFinWait1
+		c.finQueued = true
+		c.output()
+	case stateCloseWait:
+		c.state = stateLastAck
+		c.finQueued = true
+		c.output()
+	}
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IP(c.local.Bytes()), Port: int(c.id.localPort)}
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IP(c.id.remote.Bytes()), Port: int(c.id.remotePort)}
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	c.SetWriteDeadline(t)
+	return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.readDeadline = t
+	c.readTimer = c.deadlineTimer(c.readTimer, t)
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.writeDeadline = t
+	c.writeTimer = c.deadlineTimer(c.writeTimer, t)
+	return nil
+}
+
+// deadlineTimer wakes up waiting readers or writers when a deadline
+// expires.
+func (c *conn) deadlineTimer(timer *time.Timer, t time.Time) *time.Timer {
+	if timer != nil {
+		timer.Stop()
+	}
+	c.cond.Broadcast()
+	if t.IsZero() {
+		return nil
+	}
+	return time.AfterFunc(time.Until(t), func() {
+		c.lock.Lock()
+		c.cond.Broadcast()
+		c.lock.Unlock()
+	})
+}
@@ -0,0 +1,220 @@
+package tcp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/virtual"
+)
+
+type stack struct {
+	nic     ethernet.NIC
+	address ipv4.Address
+	tcp     *layer
+}
+
+func newStack(nic ethernet.NIC, address string, bufferSize int) *stack {
+	s := &stack{nic: nic}
+	s.address, _ = ipv4.NewAddress(address)
+	netmask, _ := ipv4.NewAddress("255.255.255.0")
+
+	eth := ethernet.NewLayer(nic)
+	arp := ipv4.NewARP(nic.GetMAC(), s.address, eth)
+	router := ipv4.NewRouter(arp, s.address, netmask, nil)
+	ip := ipv4.NewLayer(s.address, router, eth)
+	s.tcp = NewCustomLayer(ip, bufferSize, 50*time.Millisecond).(*layer)
+
+	nic.Start()
+	return s
+}
+
+func newStacks(send, receive virtual.Impairment, bufferSize int) (*stack, *stack) {
+	a, b := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	a = virtual.NewImpairedNIC(a, send, receive)
+	return newStack(a, "10.0.0.1", bufferSize), newStack(b, "10.0.0.2", bufferSize)
+}
+
+func (s *stack) numConns() int {
+	s.tcp.lock.Lock()
+	defer s.tcp.lock.Unlock()
+	return len(s.tcp.conns)
+}
+
+func accept(t *testing.T, l net.Listener) <-chan net.Conn {
+	c := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		assert.Nil(t, err)
+		c <- conn
+	}()
+	return c
+}
+
+func TestConnection(t *testing.T) {
+	a, b := newStacks(virtual.Impairment{}, virtual.Impairment{}, DefaultBufferSize)
+
+	l, err := b.tcp.Listen(80)
+	assert.Nil(t, err)
+	_, err = b.tcp.Listen(80)
+	assert.Equal(t, ErrPortInUse, err)
+	accepted := accept(t, l)
+
+	client, err := a.tcp.Dial(b.address, 80)
+	assert.Nil(t, err)
+	server := <-accepted
+	assert.Equal(t, "10.0.0.2:80", client.RemoteAddr().String())
+	assert.Equal(t, client.LocalAddr().String(), server.RemoteAddr().String())
+	assert.Equal(t, "10.0.0.2:80", l.Addr().String())
+
+	_, err = client.Write([]byte("hello"))
+	assert.Nil(t, err)
+	b1 := make([]byte, 100)
+	n, err := server.Read(b1)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(b1[:n]))
+
+	_, err = server.Write([]byte("world"))
+	assert.Nil(t, err)
+	assert.Nil(t, server.Close())
+	data, err := ioutil.ReadAll(client)
+	assert.Nil(t, err)
+	assert.Equal(t, "world", string(data))
+
+	_, err = server.Read(b1)
+	assert.Equal(t, ErrClosed, err)
+	_, err = server.Write(b1)
+	assert.Equal(t, ErrClosed, err)
+	assert.Equal(t, ErrClosed, server.Close())
+
+	// The server closed first, so only the server enters TIME-WAIT.
+	assert.Nil(t, client.Close())
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, a.numConns())
+	assert.Equal(t, 1, b.numConns())
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, b.numConns())
+
+	assert.Nil(t, l.Close())
+	_, err = l.Accept()
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestConnectionRefused(t *testing.T) {
+	a, b := newStacks(virtual.Impairment{}, virtual.Impairment{}, DefaultBufferSize)
+
+	_, err := a.tcp.Dial(b.address, 80)
+	assert.Equal(t, ErrConnectionRefused, err)
+	assert.Equal(t, 0, a.numConns())
+}
+
+func TestConnectionReset(t *testing.T) {
+	a, b := newStacks(virtual.Impairment{}, virtual.Impairment{}, DefaultBufferSize)
+
+	l, err := b.tcp.Listen(80)
+	assert.Nil(t, err)
+	accepted := accept(t, l)
+	client, err := a.tcp.Dial(b.address, 80)
+	assert.Nil(t, err)
+	server := <-accepted
+
+	server.(*conn).reset()
+	_, err = client.Read(make([]byte, 1))
+	assert.Equal(t, ErrConnectionReset, err)
+	_, err = client.Write([]byte("hello"))
+	assert.Equal(t, ErrConnectionReset, err)
+}
+
+func TestDeadline(t *testing.T) {
+	a, b := newStacks(virtual.Impairment{}, virtual.Impairment{}, DefaultBufferSize)
+
+	l, err := b.tcp.Listen(80)
+	assert.Nil(t, err)
+	accepted := accept(t, l)
+	client, err := a.tcp.Dial(b.address, 80)
+	assert.Nil(t, err)
+	<-accepted
+
+	start := time.Now()
+	client.SetReadDeadline(start.Add(50 * time.Millisecond))
+	_, err = client.Read(make([]byte, 1))
+	assert.Equal(t, os.ErrDeadlineExceeded, err)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+}
+
+func transfer(t *testing.T, a, b *stack, size int) {
+	l, err := b.tcp.Listen(80)
+	assert.Nil(t, err)
+	defer l.Close()
+	accepted := accept(t, l)
+
+	client, err := a.tcp.Dial(b.address, 80)
+	assert.Nil(t, err)
+	server := <-accepted
+
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	go func() {
+		_, err := client.Write(data)
+		assert.Nil(t, err)
+		client.Close()
+	}()
+
+	received, err := ioutil.ReadAll(server)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(data, received), "Received data differs")
+	assert.Nil(t, server.Close())
+}
+
+func TestFlowControl(t *testing.T) {
+	a, b := newStacks(virtual.Impairment{}, virtual.Impairment{}, 4096)
+
+	l, err := b.tcp.Listen(80)
+	assert.Nil(t, err)
+	accepted := accept(t, l)
+	client, err := a.tcp.Dial(b.address, 80)
+	assert.Nil(t, err)
+	server := <-accepted
+
+	// The writer blocks once both the receive window and the send buffer
+	// are full.
+	client.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := client.Write(make([]byte, 3*4096))
+	assert.Equal(t, os.ErrDeadlineExceeded, err)
+	assert.Equal(t, 2*4096, n)
+
+	client.SetWriteDeadline(time.Time{})
+	go func() {
+		client.Write(make([]byte, 4096))
+		client.Close()
+	}()
+	received, err := ioutil.ReadAll(server)
+	assert.Nil(t, err)
+	assert.Equal(t, 3*4096, len(received))
+}
+
+func TestLossyTransfer(t *testing.T) {
+	imp := virtual.Impairment{
+		Seed:        1,
+		Loss:        0.02,
+		Duplication: 0.01,
+		Reordering:  0.02,
+		Delay:       time.Millisecond,
+	}
+	a, b := newStacks(imp, imp, DefaultBufferSize)
+
+	transfer(t, a, b, 256*1024)
+}
+
+func TestTransfer(t *testing.T) {
+	a, b := newStacks(virtual.Impairment{}, virtual.Impairment{}, DefaultBufferSize)
+
+	transfer(t, a, b, 1024*1024)
+}
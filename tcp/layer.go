@@ -0,0 +1,239 @@
+package tcp
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+// Layer is a TCP layer.
+type Layer interface {
+	// Listen announces on a local port.
+	Listen(port uint16) (net.Listener, error)
+
+	// Dial opens a connection to a remote address and port.
+	//
+	// Dial blocks until the connection is established. See also
+	// ErrConnectionRefused and ErrTimeout.
+	Dial(address ipv4.Address, port uint16) (net.Conn, error)
+}
+
+var (
+	// ErrPortInUse is returned when listening on a port that is in use.
+	ErrPortInUse = errors.New("port already in use")
+
+	// ErrNoFreePort is returned when all ephemeral ports are in use.
+	ErrNoFreePort = errors.New("no free ephemeral port")
+
+	// ErrConnectionRefused is returned when the remote host resets a
+	// connection attempt.
+	ErrConnectionRefused = errors.New("connection refused")
+
+	// ErrConnectionReset is returned when the remote host resets an
+	// established connection.
+	ErrConnectionReset = errors.New("connection reset by peer")
+
+	// ErrTimeout is returned when the remote host stops acknowledging
+	// segments.
+	ErrTimeout = errors.New("connection timed out")
+
+	// ErrClosed is returned when using a closed connection or listener.
+	ErrClosed = net.ErrClosed
+)
+
+const (
+	// DefaultBufferSize is the default size of the send and receive buffers
+	// of a connection.
+	DefaultBufferSize = 65535
+
+	// DefaultMSL is the default maximum segment lifetime. Connections stay
+	// in the TIME-WAIT state for twice this duration.
+	DefaultMSL = 30 * time.Second
+
+	// DefaultBacklog is the number of established connections a listener
+	// queues until they are accepted.
+	DefaultBacklog = 16
+
+	// MinEphemeralPort is the first port used for outgoing connections.
+	MinEphemeralPort = 49152
+)
+
+type connID struct {
+	localPort  uint16
+	remote     ipv4.Address
+	remotePort uint16
+}
+
+type layer struct {
+	ip         ipv4.Layer
+	bufferSize int
+	msl        time.Duration
+
+	lock      sync.Mutex
+	listeners map[uint16]*listener
+	conns     map[connID]*conn
+	nextPort  uint16
+}
+
+// NewLayer creates a new instance of the default TCP layer.
+func NewLayer(ip ipv4.Layer) Layer {
+	return NewCustomLayer(ip, DefaultBufferSize, DefaultMSL)
+}
+
+// NewCustomLayer creates a TCP layer with a custom configuration.
+//
+// The buffer size limits the amount of unread and unacknowledged data per
+// connection, and is at most 65535 bytes.
+func NewCustomLayer(ip ipv4.Layer, bufferSize int, msl time.Duration) Layer {
+	if bufferSize > 0xFFFF {
+		bufferSize = 0xFFFF
+	}
+	l := &layer{
+		ip:         ip,
+		bufferSize: bufferSize,
+		msl:        msl,
+		listeners:  make(map[uint16]*listener),
+		conns:      make(map[connID]*conn),
+		nextPort:   MinEphemeralPort + uint16(rand.Intn(0x10000-MinEphemeralPort)),
+	}
+	go l.run(ip.Packets(ipv4.ProtocolTCP))
+	return l
+}
+
+func (layer *layer) Listen(port uint16) (net.Listener, error) {
+	layer.lock.Lock()
+	defer layer.lock.Unlock()
+
+	if _, ok := layer.listeners[port]; ok {
+		return nil, ErrPortInUse
+	}
+	l := newListener(layer, port)
+	layer.listeners[port] = l
+	return l, nil
+}
+
+func (layer *layer) Dial(address ipv4.Address, port uint16) (net.Conn, error) {
+	local, err := layer.ip.Source(address)
+	if err != nil {
+		return nil, err
+	}
+
+	layer.lock.Lock()
+	id, ok := layer.allocate(address, port)
+	if !ok {
+		layer.lock.Unlock()
+		return nil, ErrNoFreePort
+	}
+	c := newConn(layer, id, local)
+	layer.conns[id] = c
+	layer.lock.Unlock()
+
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// allocate finds a free ephemeral port for a connection to a remote
+// address and port. The layer must be locked.
+func (layer *layer) allocate(address ipv4.Address, port uint16) (connID, bool) {
+	for i := 0; i < 0x10000-MinEphemeralPort; i++ {
+		id := connID{layer.nextPort, address, port}
+		layer.nextPort++
+		if layer.nextPort < MinEphemeralPort {
+			layer.nextPort = MinEphemeralPort
+		}
+
+		if _, ok := layer.listeners[id.localPort]; ok {
+			continue
+		} else if _, ok := layer.conns[id]; ok {
+			continue
+		}
+		return id, true
+	}
+	return connID{}, false
+}
+
+func (layer *layer) remove(c *conn) {
+	layer.lock.Lock()
+	defer layer.lock.Unlock()
+
+	if layer.conns[c.id] == c {
+		delete(layer.conns, c.id)
+	}
+}
+
+func (layer *layer) removeListener(l *listener) {
+	layer.lock.Lock()
+	defer layer.lock.Unlock()
+
+	if layer.listeners[l.port] == l {
+		delete(layer.listeners, l.port)
+	}
+}
+
+func (layer *layer) send(source ipv4.Address, p Packet) error {
+	p.Checksum = p.CalculateChecksum(source, p.Address)
+	packet := ipv4.NewPacketTo(p.Address, ipv4.ProtocolTCP, common.PacketToBytes(p))
+	packet.Source = source
+	return layer.ip.Send(packet)
+}
+
+func (layer *layer) run(packets <-chan ipv4.Packet) {
+	for packet := range packets {
+		p, err := NewPacket(bytes.NewReader(packet.Payload))
+		if err != nil {
+			continue
+		}
+		if err := p.VerifyChecksum(packet.Source, packet.Destination); err != nil {
+			continue
+		}
+		p.Address = packet.Source
+
+		id := connID{p.DestinationPort, p.Address, p.SourcePort}
+		layer.lock.Lock()
+		c := layer.conns[id]
+		l := layer.listeners[p.DestinationPort]
+		if c == nil && l != nil && p.Flags&(FlagSYN|FlagACK|FlagRST) == FlagSYN {
+			c = l.newConn(id, packet.Destination, p)
+		}
+		layer.lock.Unlock()
+
+		if c != nil {
+			c.handle(p)
+		} else {
+			layer.reset(packet.Destination, p)
+		}
+	}
+}
+
+// reset answers a segment that does not belong to any connection with a
+// reset, as described in RFC 793.
+func (layer *layer) reset(source ipv4.Address, p Packet) {
+	if p.Flags&FlagRST != 0 {
+		return
+	}
+
+	rst := Packet{
+		Header: Header{
+			SourcePort:      p.DestinationPort,
+			DestinationPort: p.SourcePort,
+			DataOffset:      5,
+		},
+		Address: p.Address,
+	}
+	if p.Flags&FlagACK != 0 {
+		rst.SequenceNumber = p.AcknowledgmentNumber
+		rst.Flags = FlagRST
+	} else {
+		rst.AcknowledgmentNumber = p.SequenceNumber + p.Length()
+		rst.Flags = FlagRST | FlagACK
+	}
+	go layer.send(source, rst)
+}
@@ -0,0 +1,93 @@
+package tcp
+
+import (
+	"net"
+	"sync"
+
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+type listener struct {
+	layer  *layer
+	port   uint16
+	accept chan *conn
+
+	lock     sync.Mutex
+	isClosed bool
+	closed   chan struct{}
+}
+
+func newListener(layer *layer, port uint16) *listener {
+	return &listener{
+		layer:  layer,
+		port:   port,
+		accept: make(chan *conn, DefaultBacklog),
+		closed: make(chan struct{}),
+	}
+}
+
+// newConn creates a passive connection for an incoming SYN segment. The
+// layer must be locked.
+func (l *listener) newConn(id connID, local ipv4.Address, syn Packet) *conn {
+	c := newConn(l.layer, id, local)
+	c.listener = l
+	c.state = stateListen
+	l.layer.conns[id] = c
+	return c
+}
+
+// established queues a connection that completed the three-way handshake.
+//
+// False is returned if the backlog is full or the listener is closed.
+func (l *listener) established(c *conn) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.isClosed {
+		return false
+	}
+	select {
+	case l.accept <- c:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closed:
+		return nil, ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.lock.Lock()
+	if l.isClosed {
+		l.lock.Unlock()
+		return ErrClosed
+	}
+	l.isClosed = true
+	close(l.closed)
+
+	var pending []*conn
+	for len(l.accept) > 0 {
+		pending = append(pending, <-l.accept)
+	}
+	l.lock.Unlock()
+
+	l.layer.removeListener(l)
+	for _, c := range pending {
+		c.reset()
+	}
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return &net.TCPAddr{
+		IP:   net.IP(l.layer.ip.Address().Bytes()),
+		Port: int(l.port),
+	}
+}
@@ -0,0 +1,270 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+var (
+	// ErrInvalidDataOffset is returned when the data offset field is
+	// smaller than the minimal header size.
+	ErrInvalidDataOffset = errors.New("DataOffset field is too small")
+
+	// ErrInvalidChecksum is returned when the segment checksum is
+	// incorrect.
+	ErrInvalidChecksum = errors.New("Checksum field is incorrect")
+)
+
+// Flags are the control bits of a TCP segment.
+type Flags uint8
+
+const (
+	// FlagFIN indicates that the sender has no more data.
+	FlagFIN = 0x01
+	// FlagSYN synchronizes sequence numbers.
+	FlagSYN = 0x02
+	// FlagRST resets the connection.
+	FlagRST = 0x04
+	// FlagPSH asks the receiver to push the data to the application.
+	FlagPSH = 0x08
+	// FlagACK indicates that the acknowledgment number is significant.
+	FlagACK = 0x10
+	// FlagURG indicates that the urgent pointer is significant.
+	FlagURG = 0x20
+)
+
+func (f Flags) String() string {
+	names := []string{"FIN", "SYN", "RST", "PSH", "ACK", "URG"}
+	b := bytes.NewBuffer(nil)
+	for i, name := range names {
+		if f&(1<<uint(i)) != 0 {
+			if b.Len() > 0 {
+				b.WriteByte('|')
+			}
+			b.WriteString(name)
+		}
+	}
+	return b.String()
+}
+
+const (
+	// OptionEnd marks the end of the option list.
+	OptionEnd = 0
+	// OptionNOP is used for padding between options.
+	OptionNOP = 1
+	// OptionMSS is the maximum segment size option.
+	OptionMSS = 2
+)
+
+// Header is the logical version of a TCP header.
+type Header struct {
+	SourcePort           uint16
+	DestinationPort      uint16
+	SequenceNumber       uint32
+	AcknowledgmentNumber uint32
+	DataOffset           uint8
+	Flags                Flags
+	Window               uint16
+	Checksum             uint16
+	UrgentPointer        uint16
+	Options              []byte
+}
+
+// NewHeader reads a header from a Reader.
+func NewHeader(r io.Reader) (Header, error) {
+	raw, err := NewRawHeader(r)
+	if err != nil {
+		return Header{}, err
+	}
+
+	h := raw.Header()
+	numOptionBytes := (int(h.DataOffset) - 5) * 4
+	if numOptionBytes < 0 {
+		return h, ErrInvalidDataOffset
+	} else if numOptionBytes > 0 {
+		h.Options = make([]byte, numOptionBytes)
+		_, err = io.ReadFull(r, h.Options)
+	}
+
+	return h, err
+}
+
+// Write the header to a Writer.
+func (h Header) Write(w io.Writer) error {
+	if err := h.RawHeader().Write(w); err != nil {
+		return err
+	}
+	_, err := w.Write(h.Options)
+	return err
+}
+
+// RawHeader converts the header to a RawHeader.
+func (h Header) RawHeader() RawHeader {
+	return RawHeader{
+		SourcePort:           h.SourcePort,
+		DestinationPort:      h.DestinationPort,
+		SequenceNumber:       h.SequenceNumber,
+		AcknowledgmentNumber: h.AcknowledgmentNumber,
+		DataOffsetFlags:      uint16(h.DataOffset)<<12 | uint16(h.Flags),
+		Window:               h.Window,
+		Checksum:             h.Checksum,
+		UrgentPointer:        h.UrgentPointer,
+	}
+}
+
+// MSS returns the value of the maximum segment size option.
+//
+// If the option is not present, false is returned.
+func (h Header) MSS() (uint16, bool) {
+	for o := h.Options; len(o) > 0; {
+		switch o[0] {
+		case OptionEnd:
+			return 0, false
+		case OptionNOP:
+			o = o[1:]
+			continue
+		}
+		if len(o) < 2 || int(o[1]) < 2 || int(o[1]) > len(o) {
+			return 0, false
+		}
+		if o[0] == OptionMSS && o[1] == 4 {
+			return binary.BigEndian.Uint16(o[2:4]), true
+		}
+		o = o[o[1]:]
+	}
+	return 0, false
+}
+
+// MSSOption encodes a maximum segment size option.
+func MSSOption(mss uint16) []byte {
+	return []byte{OptionMSS, 4, byte(mss >> 8), byte(mss)}
+}
+
+// RawHeader represents a raw TCP header without options.
+//
+// This struct can be written and read with the binary package.
+type RawHeader struct {
+	SourcePort           uint16
+	DestinationPort      uint16
+	SequenceNumber       uint32
+	AcknowledgmentNumber uint32
+	DataOffsetFlags      uint16
+	Window               uint16
+	Checksum             uint16
+	UrgentPointer        uint16
+}
+
+// NewRawHeader reads a new raw header from a reader.
+func NewRawHeader(r io.Reader) (RawHeader, error) {
+	var header RawHeader
+	err := binary.Read(r, binary.BigEndian, &header)
+	return header, err
+}
+
+// Write the header to a Writer.
+func (h RawHeader) Write(w io.Writer) error {
+	return binary.Write(w, binary.BigEndian, h)
+}
+
+// Header converts the RawHeader to a logic Header.
+func (h RawHeader) Header() Header {
+	return Header{
+		SourcePort:           h.SourcePort,
+		DestinationPort:      h.DestinationPort,
+		SequenceNumber:       h.SequenceNumber,
+		AcknowledgmentNumber: h.AcknowledgmentNumber,
+		DataOffset:           uint8(h.DataOffsetFlags >> 12),
+		Flags:                Flags(h.DataOffsetFlags & 0x3F),
+		Window:               h.Window,
+		Checksum:             h.Checksum,
+		UrgentPointer:        h.UrgentPointer,
+	}
+}
+
+// Packet is a TCP segment.
+type Packet struct {
+	Header
+	Payload []byte
+
+	// Address is either the source or destination address.
+	Address ipv4.Address
+}
+
+// NewPacket reads a segment from a reader.
+//
+// The checksum is not verified, as this requires the IPv4 addresses. See
+// also VerifyChecksum.
+func NewPacket(r io.Reader) (p Packet, err error) {
+	p.Header, err = NewHeader(r)
+	if err != nil {
+		return
+	}
+	p.Payload, err = ioutil.ReadAll(r)
+	return
+}
+
+// Write the segment to a Writer.
+func (p Packet) Write(w io.Writer) error {
+	if err := p.Header.Write(w); err != nil {
+		return err
+	}
+	_, err := w.Write(p.Payload)
+	return err
+}
+
+// Length returns the number of sequence numbers the segment occupies.
+func (p Packet) Length() uint32 {
+	n := uint32(len(p.Payload))
+	if p.Flags&FlagSYN != 0 {
+		n++
+	}
+	if p.Flags&FlagFIN != 0 {
+		n++
+	}
+	return n
+}
+
+// CalculateChecksum calculates the checksum of a segment sent from source
+// to destination.
+func (p Packet) CalculateChecksum(source, destination ipv4.Address) uint16 {
+	p.Checksum = 0
+	return p.checksum(source, destination)
+}
+
+// VerifyChecksum checks the checksum of a segment sent from source to
+// destination.
+func (p Packet) VerifyChecksum(source, destination ipv4.Address) error {
+	if p.checksum(source, destination) != 0xFFFF {
+		return ErrInvalidChecksum
+	}
+	return nil
+}
+
+func (p Packet) checksum(source, destination ipv4.Address) uint16 {
+	segment := common.PacketToBytes(p)
+	b := bytes.NewBuffer(nil)
+	ipv4.PseudoHeader{
+		Source:      source,
+		Destination: destination,
+		Protocol:    ipv4.ProtocolTCP,
+		Length:      uint16(len(segment)),
+	}.Write(b)
+	b.Write(segment)
+	return common.Checksum(b.Bytes())
+}
+
+func (p Packet) String() string {
+	return fmt.Sprintf(
+		"Packet{%v -> %v, Seq: %v, Ack: %v, %v, Window: %v, %v}",
+		p.SourcePort, p.DestinationPort,
+		p.SequenceNumber, p.AcknowledgmentNumber,
+		p.Flags, p.Window, p.Payload,
+	)
+}
@@ -0,0 +1,117 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+var packets = []struct {
+	Bytes       string
+	Source      ipv4.Address
+	Destination ipv4.Address
+	Packet      Packet
+}{
+	{
+		// A SYN segment with the MSS, SACK permitted, timestamp, NOP and
+		// window scale options.
+		"d66a0016" + "3a727ee8" + "00000000" + "a002" + "7210" + "f1d4" + "0000" +
+			"020405b4" + "0402080a" + "2456e683" + "00000000" + "01030307",
+		[4]byte{192, 168, 100, 1},
+		[4]byte{192, 168, 100, 19},
+		Packet{
+			Header: Header{
+				SourcePort:           54890,
+				DestinationPort:      22,
+				SequenceNumber:       0x3a727ee8,
+				AcknowledgmentNumber: 0,
+				DataOffset:           10,
+				Flags:                FlagSYN,
+				Window:               29200,
+				Checksum:             0xf1d4,
+				Options: []byte{
+					0x02, 0x04, 0x05, 0xb4, 0x04, 0x02, 0x08, 0x0a, 0x24, 0x56,
+					0xe6, 0x83, 0x00, 0x00, 0x00, 0x00, 0x01, 0x03, 0x03, 0x07,
+				},
+			},
+			Payload: []byte{},
+		},
+	},
+	{
+		// A data segment.
+		"0016d66a" + "00000001" + "3a727ee9" + "5018" + "00e5" + "91cd" + "0000" +
+			"68656c6c6f",
+		[4]byte{192, 168, 100, 19},
+		[4]byte{192, 168, 100, 1},
+		Packet{
+			Header: Header{
+				SourcePort:           22,
+				DestinationPort:      54890,
+				SequenceNumber:       1,
+				AcknowledgmentNumber: 0x3a727ee9,
+				DataOffset:           5,
+				Flags:                FlagPSH | FlagACK,
+				Window:               229,
+				Checksum:             0x91cd,
+			},
+			Payload: []byte("hello"),
+		},
+	},
+}
+
+func TestPacket(t *testing.T) {
+	for i, test := range packets {
+		raw, err := hex.DecodeString(test.Bytes)
+		assert.Nil(t, err)
+
+		p, err := NewPacket(bytes.NewReader(raw))
+		assert.Nil(t, err, "Could not read packet %d", i)
+		assert.True(t, reflect.DeepEqual(test.Packet, p), "Could not read packet %d: %v != %v", i, test.Packet, p)
+
+		w := bytes.NewBuffer(nil)
+		err = p.Write(w)
+		assert.Nil(t, err, "Could not write packet %d", i)
+		assert.Equal(t, test.Bytes, hex.EncodeToString(w.Bytes()), "Could not write packet %d", i)
+
+		assert.Nil(t, p.VerifyChecksum(test.Source, test.Destination), "Invalid checksum %d", i)
+		assert.Equal(t, p.Checksum, p.CalculateChecksum(test.Source, test.Destination), "Invalid checksum %d", i)
+		assert.Equal(t, ErrInvalidChecksum, p.VerifyChecksum(test.Source, ipv4.Broadcast))
+	}
+
+	// With an invalid data offset.
+	{
+		raw, err := hex.DecodeString(packets[1].Bytes)
+		assert.Nil(t, err)
+		raw[12] = 0x40
+		_, err = NewPacket(bytes.NewReader(raw))
+		assert.Equal(t, ErrInvalidDataOffset, err)
+	}
+}
+
+func TestPacketLength(t *testing.T) {
+	assert.Equal(t, uint32(1), Packet{Header: Header{Flags: FlagSYN}}.Length())
+	assert.Equal(t, uint32(6), Packet{Header: Header{Flags: FlagFIN}, Payload: []byte("hello")}.Length())
+	assert.Equal(t, uint32(0), Packet{Header: Header{Flags: FlagACK}}.Length())
+}
+
+func TestMSS(t *testing.T) {
+	mss, ok := packets[0].Packet.MSS()
+	assert.True(t, ok)
+	assert.Equal(t, uint16(1460), mss)
+
+	_, ok = packets[1].Packet.MSS()
+	assert.False(t, ok)
+
+	h := Header{Options: append([]byte{OptionNOP, OptionNOP}, MSSOption(536)...)}
+	mss, ok = h.MSS()
+	assert.True(t, ok)
+	assert.Equal(t, uint16(536), mss)
+
+	h = Header{Options: []byte{OptionMSS, 4, 0x05}}
+	_, ok = h.MSS()
+	assert.False(t, ok)
+}
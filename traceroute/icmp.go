@@ -0,0 +1,91 @@
+package traceroute
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/unigornel/go-tcpip/icmp"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+type icmpProber struct {
+	layer   icmp.Layer
+	ident   uint16
+	replies <-chan icmp.Packet
+
+	lock      sync.Mutex
+	sequence  uint16
+	closeOnce sync.Once
+}
+
+// NewICMPTracer creates a tracer that sends ICMP echo requests. The
+// destination answers with an echo reply.
+//
+// See also icmp.ErrIdentifierInUse.
+func NewICMPTracer(l icmp.Layer) (*Tracer, error) {
+	ident, replies, err := icmp.BindAnyEcho(l)
+	if err != nil {
+		return nil, err
+	}
+	return newTracer(&icmpProber{layer: l, ident: ident, replies: replies}), nil
+}
+
+func (p *icmpProber) probe(destination ipv4.Address, ttl uint8, timeout time.Duration) Hop {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	seq := p.sequence
+	p.sequence++
+
+	request := icmp.NewEchoRequest(p.ident, seq, make([]byte, DefaultProbeSize))
+	request.Address = destination
+	request.TTL = ttl
+	sent := time.Now()
+	if err := p.layer.Send(request); err != nil {
+		return Hop{Err: err}
+	}
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case m, ok := <-p.replies:
+			if !ok {
+				return Hop{Err: ErrClosed}
+			}
+
+			hop := Hop{Address: m.Address, RTT: time.Since(sent), Message: m}
+			if data, ok := m.Data.(icmp.Echo); ok {
+				if !m.Address.Equals(destination) || data.Header.SequenceNumber != seq {
+					continue
+				}
+				hop.Reached = true
+				return hop
+			}
+
+			original, _ := m.Original()
+			if !original.Destination.Equals(destination) || binary.BigEndian.Uint16(original.Payload[6:8]) != seq {
+				continue
+			}
+			switch m.Header.Type {
+			case icmp.TimeExceededType:
+			case icmp.DestinationUnreachableType:
+				hop.Err = ErrUnreachable
+			default:
+				continue
+			}
+			return hop
+		case <-deadline:
+			return Hop{Err: ErrTimeout}
+		}
+	}
+}
+
+func (p *icmpProber) close() error {
+	err := ErrClosed
+	p.closeOnce.Do(func() {
+		p.layer.UnbindEcho(p.ident)
+		err = nil
+	})
+	return err
+}
@@ -0,0 +1,106 @@
+package traceroute
+
+import (
+	"errors"
+	"time"
+
+	"github.com/unigornel/go-tcpip/icmp"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+var (
+	// ErrTimeout is reported for probes that were not answered in time.
+	ErrTimeout = errors.New("probe timed out")
+
+	// ErrUnreachable is reported for probes that were answered with an
+	// ICMP Destination Unreachable message by a router, or by the
+	// destination if it does not indicate that the probe was received.
+	ErrUnreachable = errors.New("destination unreachable")
+
+	// ErrClosed is returned when using a closed tracer.
+	ErrClosed = errors.New("tracer closed")
+)
+
+const (
+	// DefaultMaxHops is the default maximum time to live of the probes.
+	DefaultMaxHops = 30
+
+	// DefaultTimeout is the default time to wait for the answer to a
+	// probe.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultProbeSize is the default payload size of the probes.
+	DefaultProbeSize = 32
+)
+
+// Hop is the answer to a probe.
+type Hop struct {
+	TTL int
+
+	// Address is the host that answered the probe. It is unspecified if
+	// the probe timed out.
+	Address ipv4.Address
+
+	// RTT is the time between sending the probe and receiving the answer.
+	RTT time.Duration
+
+	// Reached is true if the destination answered the probe.
+	Reached bool
+
+	// Message is the ICMP message that answered the probe.
+	Message icmp.Packet
+
+	// Err is ErrTimeout or ErrUnreachable if the probe failed.
+	Err error
+}
+
+// prober sends a probe and waits for the answer.
+type prober interface {
+	probe(destination ipv4.Address, ttl uint8, timeout time.Duration) Hop
+	close() error
+}
+
+// Tracer traces the route to hosts.
+type Tracer struct {
+	prober prober
+
+	// MaxHops is the maximum time to live of the probes.
+	MaxHops int
+
+	// Timeout is the time to wait for the answer to a probe.
+	Timeout time.Duration
+}
+
+func newTracer(p prober) *Tracer {
+	return &Tracer{
+		prober:  p,
+		MaxHops: DefaultMaxHops,
+		Timeout: DefaultTimeout,
+	}
+}
+
+// Probe sends a single probe with a time to live.
+func (t *Tracer) Probe(destination ipv4.Address, ttl int) Hop {
+	hop := t.prober.probe(destination, uint8(ttl), t.Timeout)
+	hop.TTL = ttl
+	return hop
+}
+
+// Trace probes the hops to a destination, until the destination or
+// MaxHops is reached, or the destination is unreachable.
+func (t *Tracer) Trace(destination ipv4.Address) []Hop {
+	var hops []Hop
+	for ttl := 1; ttl <= t.MaxHops; ttl++ {
+		hop := t.Probe(destination, ttl)
+		hops = append(hops, hop)
+		if hop.Reached || hop.Err == ErrUnreachable || hop.Err == ErrClosed {
+			break
+		}
+	}
+	return hops
+}
+
+// Close releases the resources of the tracer.
+func (t *Tracer) Close() error {
+	return t.prober.close()
+}
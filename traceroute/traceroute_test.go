@@ -0,0 +1,100 @@
+package traceroute
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/icmp"
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/stack"
+	"github.com/unigornel/go-tcpip/virtual"
+)
+
+var destination = ipv4.Address{10, 0, 0, 2}
+
+// newPath creates a stack and a host that pretends to be behind two
+// routers. Probes with a TTL of 1 or 2 are answered with a Time Exceeded
+// message, and probes with a TTL of 10 are dropped.
+func newPath() *stack.Stack {
+	a, b := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	netmask := ipv4.Address{255, 255, 255, 0}
+	s := stack.New(stack.Config{NIC: a, Address: ipv4.Address{10, 0, 0, 1}, Netmask: netmask})
+
+	eth := ethernet.NewLayer(b)
+	arp := ipv4.NewARP(b.GetMAC(), destination, eth)
+	ip := ipv4.NewLayer(destination, ipv4.NewRouter(arp, destination, netmask, nil), eth)
+	send := func(m icmp.Packet) {
+		ip.Send(ipv4.NewPacketTo(m.Address, ipv4.ProtocolICMP, common.PacketToBytes(m)))
+	}
+	answer := func(p ipv4.Packet, reached func(ipv4.Packet) icmp.Packet) {
+		if p.TTL == 10 {
+			return
+		} else if p.TTL <= 2 {
+			send(icmp.NewTimeExceededMessage(icmp.TTLExceededCode, p))
+		} else {
+			send(reached(p))
+		}
+	}
+
+	udp := ip.Packets(ipv4.ProtocolUDP)
+	echo := ip.Packets(ipv4.ProtocolICMP)
+	go func() {
+		for {
+			select {
+			case p := <-udp:
+				answer(p, func(p ipv4.Packet) icmp.Packet {
+					return icmp.NewDestinationUnreachableMessage(icmp.PortUnreachableCode, p)
+				})
+			case p := <-echo:
+				answer(p, func(p ipv4.Packet) icmp.Packet {
+					request, _ := icmp.NewPacket(bytes.NewReader(p.Payload))
+					data := request.Data.(icmp.Echo)
+					reply := icmp.NewEchoReply(data.Header.Identifier, data.Header.SequenceNumber, data.Payload)
+					reply.Address = p.Source
+					return reply
+				})
+			}
+		}
+	}()
+	b.Start()
+	return s
+}
+
+func TestTrace(t *testing.T) {
+	s := newPath()
+	udpTracer, err := NewUDPTracer(s.UDP)
+	assert.Nil(t, err)
+	icmpTracer, err := NewICMPTracer(s.ICMP)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name   string
+		tracer *Tracer
+	}{
+		{"udp", udpTracer},
+		{"icmp", icmpTracer},
+	}
+	for _, test := range tests {
+		hops := test.tracer.Trace(destination)
+		if assert.Equal(t, 3, len(hops), test.name) {
+			for i, hop := range hops {
+				assert.Equal(t, i+1, hop.TTL, test.name)
+				assert.Nil(t, hop.Err, test.name)
+				assert.Equal(t, destination, hop.Address, test.name)
+				assert.Equal(t, i == 2, hop.Reached, test.name)
+				assert.True(t, hop.RTT > 0, test.name)
+			}
+			assert.Equal(t, icmp.Type(icmp.TimeExceededType), hops[0].Message.Header.Type, test.name)
+		}
+
+		test.tracer.Timeout = 50 * time.Millisecond
+		hop := test.tracer.Probe(destination, 10)
+		assert.Equal(t, ErrTimeout, hop.Err, test.name)
+
+		assert.Nil(t, test.tracer.Close(), test.name)
+	}
+}
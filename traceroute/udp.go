@@ -0,0 +1,89 @@
+package traceroute
+
+import (
+	"sync"
+	"time"
+
+	"github.com/unigornel/go-tcpip/icmp"
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/udp"
+)
+
+// DefaultPort is the destination port of the first UDP probe. Every probe
+// uses the next port.
+const DefaultPort = 33434
+
+type udpProber struct {
+	layer  udp.Layer
+	socket udp.Socket
+
+	lock sync.Mutex
+	port uint16
+}
+
+// NewUDPTracer creates a tracer that sends UDP probes to unused ports.
+// The destination answers with an ICMP Port Unreachable message.
+//
+// The UDP layer must deliver ICMP errors to its sockets. See also
+// udp.NewCustomLayer.
+func NewUDPTracer(l udp.Layer) (*Tracer, error) {
+	s, err := l.Bind(ipv4.Address{}, 0)
+	if err != nil {
+		return nil, err
+	}
+	return newTracer(&udpProber{layer: l, socket: s, port: DefaultPort}), nil
+}
+
+func (p *udpProber) probe(destination ipv4.Address, ttl uint8, timeout time.Duration) Hop {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	port := p.port
+	if p.port++; p.port == 0 {
+		p.port = DefaultPort
+	}
+
+	sent := time.Now()
+	err := p.layer.Send(udp.Packet{
+		Header: udp.Header{
+			SourcePort:      p.socket.Port(),
+			DestinationPort: port,
+			Length:          8 + DefaultProbeSize,
+		},
+		Payload: make([]byte, DefaultProbeSize),
+		Address: destination,
+		TTL:     ttl,
+	})
+	if err != nil {
+		return Hop{Err: err}
+	}
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case e, ok := <-p.socket.Errors():
+			if !ok {
+				return Hop{Err: ErrClosed}
+			}
+			if !e.Address.Equals(destination) || e.Port != port {
+				continue
+			}
+
+			hop := Hop{Address: e.Source, RTT: time.Since(sent), Message: e.Message}
+			switch {
+			case e.Message.Header.Type == icmp.TimeExceededType:
+			case e.Message.Header.Code == icmp.PortUnreachableCode && e.Source.Equals(destination):
+				hop.Reached = true
+			default:
+				hop.Err = ErrUnreachable
+			}
+			return hop
+		case <-deadline:
+			return Hop{Err: ErrTimeout}
+		}
+	}
+}
+
+func (p *udpProber) close() error {
+	return p.socket.Close()
+}
@@ -0,0 +1,181 @@
+package udp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+var (
+	// ErrInvalidAddress is returned when writing to an address that is not
+	// an IPv4 UDP address.
+	ErrInvalidAddress = errors.New("invalid UDP address")
+
+	// ErrNotConnected is returned when writing to a packet connection
+	// without a destination address.
+	ErrNotConnected = errors.New("destination address required")
+
+	// ErrClosed is returned when using a closed socket or connection.
+	ErrClosed = net.ErrClosed
+)
+
+type conn struct {
+	socket Socket
+	remote *net.UDPAddr
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	readDeadline  *common.Deadline
+	writeDeadline *common.Deadline
+}
+
+// NewPacketConn creates a packet connection on a socket. Closing the
+// connection closes the socket.
+func NewPacketConn(s Socket) net.PacketConn {
+	return newConn(s, nil)
+}
+
+// NewConn creates a connection on a socket that only exchanges datagrams
+// with a remote address and port. Closing the connection closes the
+// socket.
+func NewConn(s Socket, address ipv4.Address, port uint16) net.Conn {
+	return newConn(s, &net.UDPAddr{IP: net.IP(address.Bytes()), Port: int(port)})
+}
+
+func newConn(s Socket, remote *net.UDPAddr) *conn {
+	return &conn{
+		socket:        s,
+		remote:        remote,
+		closed:        make(chan struct{}),
+		readDeadline:  common.NewDeadline(),
+		writeDeadline: common.NewDeadline(),
+	}
+}
+
+// ReadFrom reads a datagram. Connected connections also return the ICMP
+// errors about datagrams sent to the remote address, such as
+// ErrConnectionRefused.
+func (c *conn) ReadFrom(b []byte) (int, net.Addr, error) {
+	var errs <-chan Error
+	if c.remote != nil {
+		errs = c.socket.Errors()
+	}
+	for {
+		if common.IsClosed(c.closed) {
+			return 0, nil, ErrClosed
+		} else if common.IsClosed(c.readDeadline.Wait()) {
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+
+		select {
+		case p, ok := <-c.socket.Packets():
+			if !ok {
+				return 0, nil, ErrClosed
+			}
+			addr := &net.UDPAddr{IP: net.IP(p.Address.Bytes()), Port: int(p.SourcePort)}
+			if c.remote != nil && (!c.remote.IP.Equal(addr.IP) || c.remote.Port != addr.Port) {
+				continue
+			}
+			return copy(b, p.Payload), addr, nil
+		case e, ok := <-errs:
+			if !ok {
+				return 0, nil, ErrClosed
+			}
+			if !c.remote.IP.Equal(net.IP(e.Address.Bytes())) || c.remote.Port != int(e.Port) {
+				continue
+			}
+			return 0, nil, e.Err
+		case <-c.closed:
+			return 0, nil, ErrClosed
+		case <-c.readDeadline.Wait():
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	if c.remote == nil {
+		return 0, ErrNotConnected
+	}
+	return c.writeTo(b, c.remote)
+}
+
+func (c *conn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.remote != nil {
+		return 0, net.ErrWriteToConnected
+	}
+	return c.writeTo(b, addr)
+}
+
+func (c *conn) writeTo(b []byte, addr net.Addr) (int, error) {
+	if common.IsClosed(c.closed) {
+		return 0, ErrClosed
+	} else if common.IsClosed(c.writeDeadline.Wait()) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	a, ok := addr.(*net.UDPAddr)
+	if !ok || a.Port <= 0 || a.Port > 0xFFFF {
+		return 0, ErrInvalidAddress
+	}
+	destination, ok := ipv4.NewAddress(a.IP.String())
+	if !ok {
+		return 0, ErrInvalidAddress
+	}
+
+	payload := make([]byte, len(b))
+	copy(payload, b)
+	if err := c.socket.Send(destination, uint16(a.Port), payload); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *conn) Close() error {
+	err := ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.socket.Close()
+	})
+	return err
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IP(c.socket.Address().Bytes()), Port: int(c.socket.Port())}
+}
+
+// RemoteAddr returns the remote address, or nil if the connection is not
+// connected.
+func (c *conn) RemoteAddr() net.Addr {
+	if c.remote == nil {
+		return nil
+	}
+	return c.remote
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	c.writeDeadline.Set(t)
+	return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Set(t)
+	return nil
+}
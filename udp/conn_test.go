@@ -0,0 +1,101 @@
+package udp
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/virtual"
+)
+
+func newLayer(nic ethernet.NIC, address ipv4.Address) Layer {
+	netmask, _ := ipv4.NewAddress("255.255.255.0")
+	eth := ethernet.NewLayer(nic)
+	arp := ipv4.NewARP(nic.GetMAC(), address, eth)
+	router := ipv4.NewRouter(arp, address, netmask, nil)
+	l := NewLayer(ipv4.NewLayer(address, router, eth))
+	nic.Start()
+	return l
+}
+
+func TestPacketConn(t *testing.T) {
+	nicA, nicB := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	socketA, err := newLayer(nicA, ipv4.Address{10, 0, 0, 1}).Bind(ipv4.Address{}, 1234)
+	assert.Nil(t, err)
+	socketB, err := newLayer(nicB, ipv4.Address{10, 0, 0, 2}).Bind(ipv4.Address{}, 7)
+	assert.Nil(t, err)
+	a := NewPacketConn(socketA)
+	b := NewPacketConn(socketB)
+	defer a.Close()
+	assert.Equal(t, "0.0.0.0:7", b.LocalAddr().String())
+
+	_, err = a.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 7})
+	assert.Nil(t, err)
+	buf := make([]byte, 100)
+	b.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, addr, err := b.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	assert.Equal(t, "10.0.0.1:1234", addr.String())
+
+	_, err = b.WriteTo([]byte("world"), addr)
+	assert.Nil(t, err)
+	a.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, addr, err = a.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "world", string(buf[:n]))
+	assert.Equal(t, "10.0.0.2:7", addr.String())
+
+	_, err = a.WriteTo([]byte("hello"), &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 7})
+	assert.Equal(t, ErrInvalidAddress, err)
+
+	b.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, _, err = b.ReadFrom(buf)
+	assert.Equal(t, os.ErrDeadlineExceeded, err)
+
+	assert.Nil(t, b.Close())
+	assert.Equal(t, ErrClosed, b.Close())
+	_, _, err = b.ReadFrom(buf)
+	assert.Equal(t, ErrClosed, err)
+	_, err = b.WriteTo([]byte("hello"), addr)
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestConn(t *testing.T) {
+	nicA, nicB := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	layerA := newLayer(nicA, ipv4.Address{10, 0, 0, 1})
+	layerB := newLayer(nicB, ipv4.Address{10, 0, 0, 2})
+	socketA, _ := layerA.Bind(ipv4.Address{10, 0, 0, 1}, 0)
+	socketB, _ := layerB.Bind(ipv4.Address{}, 7)
+	other, _ := layerB.Bind(ipv4.Address{}, 8)
+	a := NewConn(socketA, ipv4.Address{10, 0, 0, 2}, 7)
+	defer a.Close()
+	defer socketB.Close()
+	defer other.Close()
+
+	// Datagrams from other ports are ignored.
+	assert.Nil(t, other.Send(ipv4.Address{10, 0, 0, 1}, socketA.Port(), []byte("other")))
+	assert.Nil(t, socketB.Send(ipv4.Address{10, 0, 0, 1}, socketA.Port(), []byte("hello")))
+	buf := make([]byte, 100)
+	a.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := a.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+
+	_, err = a.Write([]byte("world"))
+	assert.Nil(t, err)
+	select {
+	case p := <-socketB.Packets():
+		assert.Equal(t, "world", string(p.Payload))
+		assert.Equal(t, socketA.Port(), p.SourcePort)
+	case <-time.After(5 * time.Second):
+		t.Fatal("No datagram received")
+	}
+
+	_, err = a.(net.PacketConn).WriteTo([]byte("hello"), a.RemoteAddr())
+	assert.Equal(t, net.ErrWriteToConnected, err)
+}
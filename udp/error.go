@@ -0,0 +1,68 @@
+package udp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/unigornel/go-tcpip/icmp"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+var (
+	// ErrConnectionRefused is reported when the destination port or
+	// protocol is unreachable.
+	ErrConnectionRefused = errors.New("connection refused")
+
+	// ErrHostUnreachable is reported when the destination host cannot be
+	// reached.
+	ErrHostUnreachable = errors.New("host unreachable")
+
+	// ErrNetworkUnreachable is reported when there is no route to the
+	// network of the destination.
+	ErrNetworkUnreachable = errors.New("network unreachable")
+)
+
+// Error is an ICMP error about a datagram sent from a socket.
+type Error struct {
+	// Err is one of ErrConnectionRefused, ErrHostUnreachable,
+	// ErrNetworkUnreachable or ipv4.ErrFragmentationNeeded. Time exceeded
+	// messages are reported as ErrHostUnreachable.
+	Err error
+
+	// Source is the address of the host that reported the error.
+	Source ipv4.Address
+
+	// Address and Port are the destination of the datagram.
+	Address ipv4.Address
+	Port    uint16
+
+	// Message is the ICMP error message.
+	Message icmp.Packet
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%v:%v: %v (reported by %v)", e.Address, e.Port, e.Err, e.Source)
+}
+
+// errorFromICMP returns the error reported by an ICMP error message, or nil
+// if the message is not reported to sockets.
+func errorFromICMP(p icmp.Packet) error {
+	switch p.Header.Type {
+	case icmp.DestinationUnreachableType:
+	case icmp.TimeExceededType:
+		return ErrHostUnreachable
+	default:
+		return nil
+	}
+
+	switch p.Header.Code {
+	case icmp.NetworkUnreachableCode:
+		return ErrNetworkUnreachable
+	case icmp.ProtocolUnreachableCode, icmp.PortUnreachableCode:
+		return ErrConnectionRefused
+	case icmp.FragmentationNeededCode:
+		return ipv4.ErrFragmentationNeeded
+	default:
+		return ErrHostUnreachable
+	}
+}
@@ -2,63 +2,293 @@ package udp
 
 import (
 	"bytes"
+	"errors"
+	"math/rand"
+	"sync"
 
 	"github.com/unigornel/go-tcpip/common"
+	"github.com/unigornel/go-tcpip/icmp"
 	"github.com/unigornel/go-tcpip/ipv4"
 )
 
 // Layer is an UDP layer.
 type Layer interface {
+	// Packets returns the datagrams received on a port.
+	//
+	// The port stays in use for the lifetime of the layer. Use Bind for
+	// ports that must be released.
 	Packets(port uint16) <-chan Packet
 	Send(packet Packet) error
+
+	// Bind binds a socket to a local address and port.
+	//
+	// An unspecified address receives the datagrams for all local
+	// addresses, and a multicast address the datagrams to the group. A
+	// zero port selects a free ephemeral port.
+	//
+	// See also ErrPortInUse, ErrNoFreePort and ErrAddressNotAvailable.
+	Bind(address ipv4.Address, port uint16) (Socket, error)
+}
+
+var (
+	// ErrPortInUse is returned when binding to a port that is in use.
+	ErrPortInUse = errors.New("port already in use")
+
+	// ErrNoFreePort is returned when all ephemeral ports are in use.
+	ErrNoFreePort = errors.New("no free ephemeral port")
+
+	// ErrAddressNotAvailable is returned when binding to an address that
+	// is not a local address.
+	ErrAddressNotAvailable = errors.New("address not available")
+)
+
+const (
+	// DefaultMinEphemeralPort is the first port of the default ephemeral
+	// port range.
+	DefaultMinEphemeralPort = 49152
+
+	// DefaultMaxEphemeralPort is the last port of the default ephemeral
+	// port range.
+	DefaultMaxEphemeralPort = 65535
+)
+
+type binding struct {
+	address ipv4.Address
+	port    uint16
 }
 
 type layer struct {
-	ip       ipv4.Layer
+	ip      ipv4.Layer
+	icmp    icmp.Layer
+	minPort uint16
+	maxPort uint16
+
+	lock     sync.Mutex
 	channels map[uint16]chan Packet
+	sockets  map[binding]*socket
+	ports    map[uint16]int
+	nextPort uint16
 }
 
 // NewLayer creates a new instance of the default UDP layer.
+//
+// The layer does not send or receive ICMP error messages. See also
+// NewCustomLayer.
 func NewLayer(ip ipv4.Layer) Layer {
+	return NewCustomLayer(ip, nil, DefaultMinEphemeralPort, DefaultMaxEphemeralPort)
+}
+
+// NewCustomLayer creates a UDP layer that allocates ephemeral ports from
+// minPort up to and including maxPort.
+//
+// If an ICMP layer is given, port unreachable messages are sent for
+// datagrams to unused ports, and the destination unreachable messages
+// about datagrams sent from sockets are delivered to the sockets.
+func NewCustomLayer(ip ipv4.Layer, icmp icmp.Layer, minPort, maxPort uint16) Layer {
+	if minPort == 0 {
+		minPort = 1
+	}
+	if maxPort < minPort {
+		maxPort = minPort
+	}
 	l := &layer{
 		ip:       ip,
+		icmp:     icmp,
+		minPort:  minPort,
+		maxPort:  maxPort,
 		channels: make(map[uint16]chan Packet),
+		sockets:  make(map[binding]*socket),
+		ports:    make(map[uint16]int),
+	}
+	l.nextPort = minPort + uint16(rand.Intn(int(maxPort-minPort)+1))
+	go l.run(ip.Packets(ipv4.ProtocolUDP))
+	if icmp != nil {
+		go l.receiveErrors(icmp.Errors(ipv4.ProtocolUDP))
 	}
-	go l.run()
 	return l
 }
 
 func (layer *layer) Packets(port uint16) <-chan Packet {
+	layer.lock.Lock()
+	defer layer.lock.Unlock()
+
 	c, ok := layer.channels[port]
 	if !ok {
 		c = make(chan Packet)
 		layer.channels[port] = c
+		layer.ports[port]++
 	}
 	return c
 }
 
 func (layer *layer) Send(packet Packet) error {
-	packet.Checksum = packet.CalculateChecksum()
+	source := packet.Local
+	if source.Equals(ipv4.Address{}) {
+		var err error
+		if source, err = layer.ip.Source(packet.Address); err != nil {
+			return err
+		}
+	}
+	packet.Checksum = packet.CalculateChecksum(source, packet.Address)
 	payload := common.PacketToBytes(packet)
 	p := ipv4.NewPacketTo(packet.Address, ipv4.ProtocolUDP, payload)
+	p.Source = source
+	if packet.TTL != 0 {
+		p.TTL = packet.TTL
+	}
 	return layer.ip.Send(p)
 }
 
-func (layer *layer) run() {
-	for packet := range layer.ip.Packets(ipv4.ProtocolUDP) {
+func (layer *layer) Bind(address ipv4.Address, port uint16) (Socket, error) {
+	unspecified := address.Equals(ipv4.Address{})
+	if !unspecified && !address.IsMulticast() && !layer.ip.HasAddress(address) {
+		return nil, ErrAddressNotAvailable
+	}
+
+	layer.lock.Lock()
+	defer layer.lock.Unlock()
+
+	if port == 0 {
+		var ok bool
+		if port, ok = layer.allocate(); !ok {
+			return nil, ErrNoFreePort
+		}
+	} else if _, ok := layer.channels[port]; ok {
+		return nil, ErrPortInUse
+	} else if unspecified && layer.ports[port] > 0 {
+		return nil, ErrPortInUse
+	} else if _, ok := layer.sockets[binding{ipv4.Address{}, port}]; ok {
+		return nil, ErrPortInUse
+	} else if _, ok := layer.sockets[binding{address, port}]; ok {
+		return nil, ErrPortInUse
+	}
+
+	s := newSocket(layer, binding{address, port})
+	layer.sockets[s.binding] = s
+	layer.ports[port]++
+	return s, nil
+}
+
+// allocate finds an ephemeral port that is not in use. The layer must be
+// locked.
+func (layer *layer) allocate() (uint16, bool) {
+	for i := 0; i <= int(layer.maxPort-layer.minPort); i++ {
+		port := layer.nextPort
+		if layer.nextPort == layer.maxPort {
+			layer.nextPort = layer.minPort
+		} else {
+			layer.nextPort++
+		}
+
+		if layer.ports[port] == 0 {
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+// unbind releases the port of a socket and closes its channels.
+func (layer *layer) unbind(s *socket) {
+	layer.lock.Lock()
+	defer layer.lock.Unlock()
+
+	if layer.sockets[s.binding] == s {
+		delete(layer.sockets, s.binding)
+		if layer.ports[s.port]--; layer.ports[s.port] == 0 {
+			delete(layer.ports, s.port)
+		}
+		close(s.packets)
+		close(s.errors)
+	}
+}
+
+// lookup finds the socket bound to a local address and port. The layer
+// must be locked.
+func (layer *layer) lookup(address ipv4.Address, port uint16) *socket {
+	if s := layer.sockets[binding{address, port}]; s != nil {
+		return s
+	}
+	return layer.sockets[binding{ipv4.Address{}, port}]
+}
+
+// deliver queues a datagram on the socket bound to its destination. The
+// channel of a port is returned if it is used with Packets instead.
+//
+// False is returned if the port is not in use.
+func (layer *layer) deliver(destination ipv4.Address, p Packet) (chan Packet, bool) {
+	layer.lock.Lock()
+	defer layer.lock.Unlock()
+
+	if c := layer.channels[p.DestinationPort]; c != nil {
+		return c, true
+	}
+
+	s := layer.lookup(destination, p.DestinationPort)
+	if s == nil {
+		return nil, false
+	}
+	select {
+	case s.packets <- p:
+	default:
+	}
+	return nil, true
+}
+
+func (layer *layer) run(packets <-chan ipv4.Packet) {
+	for packet := range packets {
 		p, err := NewPacket(bytes.NewBuffer(packet.Payload))
 		if err != nil {
 			continue
 		}
+		if err := p.VerifyChecksum(packet.Source, packet.Destination); err != nil {
+			continue
+		}
 
 		p.Address = packet.Source
-		c := layer.channels[p.DestinationPort]
+		p.Local = packet.Destination
+		p.TTL = packet.TTL
+		c, ok := layer.deliver(packet.Destination, p)
 		if c != nil {
 			c <- p
+		} else if !ok && layer.icmp != nil && layer.ip.HasAddress(packet.Destination) && ipv4.ICMPErrorAllowed(packet) {
+			layer.icmp.SendError(icmp.NewDestinationUnreachableMessage(icmp.PortUnreachableCode, packet))
 		}
 	}
 
+	layer.lock.Lock()
+	defer layer.lock.Unlock()
 	for _, c := range layer.channels {
 		close(c)
 	}
 }
+
+// receiveErrors delivers ICMP error messages to the sockets that sent the
+// datagrams.
+func (layer *layer) receiveErrors(messages <-chan icmp.Packet) {
+	for m := range messages {
+		original, _ := m.Original()
+		h, err := NewHeader(bytes.NewReader(original.Payload))
+		if err != nil {
+			continue
+		}
+
+		e := Error{
+			Err:     errorFromICMP(m),
+			Message: m,
+			Source:  m.Address,
+			Address: original.Destination,
+			Port:    h.DestinationPort,
+		}
+		if e.Err == nil {
+			continue
+		}
+		layer.lock.Lock()
+		if s := layer.lookup(original.Source, h.SourcePort); s != nil {
+			select {
+			case s.errors <- e:
+			default:
+			}
+		}
+		layer.lock.Unlock()
+	}
+}
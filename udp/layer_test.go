@@ -0,0 +1,73 @@
+package udp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/virtual"
+)
+
+func TestBind(t *testing.T) {
+	local := ipv4.Address{10, 0, 0, 1}
+	unspecified := ipv4.Address{}
+	nic, _ := virtual.NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	eth := ethernet.NewLayer(nic)
+	arp := ipv4.NewARP(nic.GetMAC(), local, eth)
+	router := ipv4.NewRouter(arp, local, ipv4.Address{255, 255, 255, 0}, nil)
+	l := NewCustomLayer(ipv4.NewLayer(local, router, eth), nil, 5000, 5001)
+
+	s, err := l.Bind(local, 7)
+	assert.Nil(t, err)
+	assert.Equal(t, local, s.Address())
+	assert.Equal(t, uint16(7), s.Port())
+
+	tests := []struct {
+		address ipv4.Address
+		port    uint16
+		err     error
+	}{
+		{local, 7, ErrPortInUse},
+		{unspecified, 7, ErrPortInUse},
+		{ipv4.Address{10, 0, 0, 2}, 8, ErrAddressNotAvailable},
+		{local, 8, nil},
+	}
+	for _, test := range tests {
+		_, err := l.Bind(test.address, test.port)
+		assert.Equal(t, test.err, err, "%v:%v", test.address, test.port)
+	}
+
+	// The wildcard address conflicts with specific addresses.
+	_, err = l.Bind(unspecified, 9)
+	assert.Nil(t, err)
+	_, err = l.Bind(local, 9)
+	assert.Equal(t, ErrPortInUse, err)
+
+	// Packets reserves a port.
+	l.Packets(10)
+	_, err = l.Bind(local, 10)
+	assert.Equal(t, ErrPortInUse, err)
+
+	// Ephemeral ports
+	e1, err := l.Bind(unspecified, 0)
+	assert.Nil(t, err)
+	e2, err := l.Bind(unspecified, 0)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []uint16{5000, 5001}, []uint16{e1.Port(), e2.Port()})
+	_, err = l.Bind(unspecified, 0)
+	assert.Equal(t, ErrNoFreePort, err)
+
+	// Closing releases the port.
+	assert.Nil(t, e1.Close())
+	assert.Equal(t, ErrClosed, e1.Close())
+	_, ok := <-e1.Packets()
+	assert.False(t, ok)
+	e3, err := l.Bind(unspecified, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, e1.Port(), e3.Port())
+
+	assert.Nil(t, s.Close())
+	_, err = l.Bind(unspecified, 7)
+	assert.Nil(t, err)
+}
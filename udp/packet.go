@@ -1,6 +1,7 @@
 package udp
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"io"
@@ -46,9 +47,21 @@ type Packet struct {
 
 	// Address is either the source or destination address
 	Address ipv4.Address
+
+	// Local is the local address: the destination of received packets,
+	// and the source of sent packets. When sending, the unspecified
+	// address selects the source address of the route.
+	Local ipv4.Address
+
+	// TTL is the time to live of the IPv4 packet. When sending, zero
+	// selects the default.
+	TTL uint8
 }
 
 // NewPacket reads a packet from a reader.
+//
+// The checksum is not verified, as this requires the IPv4 addresses. See
+// also VerifyChecksum.
 func NewPacket(r io.Reader) (p Packet, err error) {
 	p.Header, err = NewHeader(r)
 	if err != nil {
@@ -61,15 +74,7 @@ func NewPacket(r io.Reader) (p Packet, err error) {
 	}
 
 	p.Payload = make([]byte, p.Length-8)
-	if _, err = r.Read(p.Payload); err != nil {
-		return
-	}
-
-	if p.Checksum != 0 && p.CalculateChecksum() != p.Checksum {
-		err = ErrInvalidChecksum
-		return
-	}
-
+	_, err = r.Read(p.Payload)
 	return
 }
 
@@ -82,8 +87,32 @@ func (p Packet) Write(w io.Writer) error {
 	return err
 }
 
-// CalculateChecksum calculates the correct checksum of the packet.
-func (p Packet) CalculateChecksum() uint16 {
+// CalculateChecksum calculates the checksum of a packet sent from source
+// to destination.
+func (p Packet) CalculateChecksum(source, destination ipv4.Address) uint16 {
 	p.Checksum = 0
-	return common.PacketChecksum(p)
+	return p.checksum(source, destination)
+}
+
+// VerifyChecksum checks the checksum of a packet sent from source to
+// destination. A zero checksum means that the sender did not calculate
+// one.
+func (p Packet) VerifyChecksum(source, destination ipv4.Address) error {
+	if p.Checksum != 0 && p.checksum(source, destination) != 0xFFFF {
+		return ErrInvalidChecksum
+	}
+	return nil
+}
+
+func (p Packet) checksum(source, destination ipv4.Address) uint16 {
+	datagram := common.PacketToBytes(p)
+	b := bytes.NewBuffer(nil)
+	ipv4.PseudoHeader{
+		Source:      source,
+		Destination: destination,
+		Protocol:    ipv4.ProtocolUDP,
+		Length:      uint16(len(datagram)),
+	}.Write(b)
+	b.Write(datagram)
+	return common.Checksum(b.Bytes())
 }
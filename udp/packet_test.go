@@ -0,0 +1,27 @@
+package udp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+func TestChecksum(t *testing.T) {
+	source := ipv4.Address{10, 0, 0, 1}
+	destination := ipv4.Address{10, 0, 0, 2}
+	b, _ := hex.DecodeString("04d20007" + "000d" + "a326" + "68656c6c6f")
+
+	p, err := NewPacket(bytes.NewReader(b))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), p.Payload)
+	assert.Equal(t, uint16(0xa326), p.CalculateChecksum(source, destination))
+	assert.Nil(t, p.VerifyChecksum(source, destination))
+	assert.Equal(t, ErrInvalidChecksum, p.VerifyChecksum(source, ipv4.Broadcast))
+
+	// A zero checksum is not verified.
+	p.Checksum = 0
+	assert.Nil(t, p.VerifyChecksum(source, ipv4.Broadcast))
+}
@@ -0,0 +1,97 @@
+package udp
+
+import (
+	"sync"
+
+	"github.com/unigornel/go-tcpip/ipv4"
+)
+
+// DefaultQueueLength is the number of datagrams a socket queues until
+// they are read.
+const DefaultQueueLength = 64
+
+// Socket is a UDP socket bound to a local address and port.
+type Socket interface {
+	// Packets returns the received datagrams. Datagrams are dropped when
+	// the channel is full. The channel is closed when the socket is
+	// closed.
+	Packets() <-chan Packet
+
+	// Errors returns the ICMP errors about datagrams sent from the
+	// socket. Errors are dropped when the channel is full. The channel is
+	// closed when the socket is closed.
+	Errors() <-chan Error
+
+	// Send sends a datagram from the port of the socket.
+	Send(address ipv4.Address, port uint16, payload []byte) error
+
+	// Address returns the local address, which is unspecified if the
+	// socket receives the datagrams for all local addresses.
+	Address() ipv4.Address
+
+	// Port returns the local port.
+	Port() uint16
+
+	// Close releases the port.
+	Close() error
+}
+
+type socket struct {
+	binding
+	layer   *layer
+	packets chan Packet
+	errors  chan Error
+
+	closeOnce sync.Once
+}
+
+func newSocket(layer *layer, b binding) *socket {
+	return &socket{
+		binding: b,
+		layer:   layer,
+		packets: make(chan Packet, DefaultQueueLength),
+		errors:  make(chan Error, DefaultQueueLength),
+	}
+}
+
+func (s *socket) Packets() <-chan Packet {
+	return s.packets
+}
+
+func (s *socket) Errors() <-chan Error {
+	return s.errors
+}
+
+func (s *socket) Send(address ipv4.Address, port uint16, payload []byte) error {
+	local := s.address
+	if local.IsMulticast() {
+		local = ipv4.Address{}
+	}
+	return s.layer.Send(Packet{
+		Header: Header{
+			SourcePort:      s.port,
+			DestinationPort: port,
+			Length:          uint16(8 + len(payload)),
+		},
+		Payload: payload,
+		Address: address,
+		Local:   local,
+	})
+}
+
+func (s *socket) Address() ipv4.Address {
+	return s.address
+}
+
+func (s *socket) Port() uint16 {
+	return s.port
+}
+
+func (s *socket) Close() error {
+	err := ErrClosed
+	s.closeOnce.Do(func() {
+		s.layer.unbind(s)
+		err = nil
+	})
+	return err
+}
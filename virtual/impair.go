@@ -0,0 +1,234 @@
+package virtual
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/unigornel/go-tcpip/ethernet"
+)
+
+// DefaultReorderDelay is the extra delay of reordered packets if
+// Impairment.ReorderDelay is not set.
+const DefaultReorderDelay = 10 * time.Millisecond
+
+// Impairment describes how packets travelling in one direction over a link
+// are degraded.
+//
+// All decisions are made by a random number generator seeded with Seed, so
+// the same sequence of packets is always impaired in the same way.
+type Impairment struct {
+	// Seed seeds the random number generator.
+	Seed int64
+
+	// Loss is the probability that a packet is dropped.
+	Loss float64
+
+	// Duplication is the probability that a packet is delivered twice.
+	Duplication float64
+
+	// Reordering is the probability that a packet is held back for an
+	// extra ReorderDelay, so that the packets following it overtake it.
+	Reordering   float64
+	ReorderDelay time.Duration
+
+	// Corruption is the probability that a single bit of the payload of a
+	// packet is flipped.
+	Corruption float64
+
+	// Delay is the latency of the link. Each packet is delayed by an extra
+	// random duration between -Jitter and Jitter.
+	Delay  time.Duration
+	Jitter time.Duration
+}
+
+type impairedNIC struct {
+	nic  ethernet.NIC
+	send Impairment
+	recv Impairment
+
+	tx   chan ethernet.Packet
+	rx   chan ethernet.Packet
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewImpairedNIC wraps a NIC so that sent packets are impaired as described
+// by send and received packets as described by receive.
+//
+// Starting and closing the returned NIC also starts and closes the wrapped
+// NIC.
+func NewImpairedNIC(nic ethernet.NIC, send, receive Impairment) ethernet.NIC {
+	return &impairedNIC{
+		nic:  nic,
+		send: send,
+		recv: receive,
+		tx:   make(chan ethernet.Packet),
+		rx:   make(chan ethernet.Packet),
+		done: make(chan struct{}),
+	}
+}
+
+func (nic *impairedNIC) Start() {
+	nic.nic.Start()
+
+	nic.wg.Add(1)
+	go func() {
+		defer nic.wg.Done()
+		newLink(nic.send, nic.nic.Send(), nic.done).run(nic.tx)
+	}()
+	go func() {
+		defer close(nic.rx)
+		newLink(nic.recv, nic.rx, nic.done).run(nic.nic.Receive())
+	}()
+}
+
+func (nic *impairedNIC) Close() {
+	close(nic.tx)
+	close(nic.done)
+	nic.wg.Wait()
+	nic.nic.Close()
+}
+
+func (nic *impairedNIC) Send() chan<- ethernet.Packet {
+	return nic.tx
+}
+
+func (nic *impairedNIC) Receive() <-chan ethernet.Packet {
+	return nic.rx
+}
+
+func (nic *impairedNIC) GetMAC() ethernet.MAC {
+	return nic.nic.GetMAC()
+}
+
+// link impairs the packets travelling in one direction.
+type link struct {
+	Impairment
+	rand *rand.Rand
+	out  chan<- ethernet.Packet
+	done <-chan struct{}
+
+	queue delayQueue
+	seq   uint64
+}
+
+func newLink(imp Impairment, out chan<- ethernet.Packet, done <-chan struct{}) *link {
+	if imp.ReorderDelay == 0 {
+		imp.ReorderDelay = DefaultReorderDelay
+	}
+	return &link{
+		Impairment: imp,
+		rand:       rand.New(rand.NewSource(imp.Seed)),
+		out:        out,
+		done:       done,
+	}
+}
+
+// run impairs all packets from in until in is closed or the link is done.
+// Packets that are still delayed at that time are dropped.
+func (l *link) run(in <-chan ethernet.Packet) {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		var due <-chan time.Time
+		if len(l.queue) > 0 {
+			timer.Reset(time.Until(l.queue[0].at))
+			due = timer.C
+		}
+
+		select {
+		case p, ok := <-in:
+			if !ok {
+				return
+			}
+			l.impair(p)
+		case <-due:
+			for len(l.queue) > 0 && !l.queue[0].at.After(time.Now()) {
+				d := heap.Pop(&l.queue).(delayed)
+				select {
+				case l.out <- d.packet:
+				case <-l.done:
+					return
+				}
+			}
+		case <-l.done:
+			return
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+func (l *link) impair(p ethernet.Packet) {
+	if l.chance(l.Loss) {
+		return
+	}
+
+	copies := 1
+	if l.chance(l.Duplication) {
+		copies = 2
+	}
+
+	now := time.Now()
+	for i := 0; i < copies; i++ {
+		q := p
+		if l.chance(l.Corruption) && len(q.Payload) > 0 {
+			q.Payload = append([]byte(nil), q.Payload...)
+			bit := l.rand.Intn(8 * len(q.Payload))
+			q.Payload[bit/8] ^= 1 << uint(bit%8)
+		}
+
+		delay := l.Delay
+		if l.Jitter > 0 {
+			delay += time.Duration(l.rand.Int63n(int64(2*l.Jitter)+1)) - l.Jitter
+		}
+		if l.chance(l.Reordering) {
+			delay += l.ReorderDelay
+		}
+
+		l.seq++
+		heap.Push(&l.queue, delayed{packet: q, at: now.Add(delay), seq: l.seq})
+	}
+}
+
+func (l *link) chance(p float64) bool {
+	return p > 0 && l.rand.Float64() < p
+}
+
+type delayed struct {
+	packet ethernet.Packet
+	at     time.Time
+	seq    uint64
+}
+
+// delayQueue is a heap of delayed packets ordered by their due time.
+type delayQueue []delayed
+
+func (q delayQueue) Len() int { return len(q) }
+
+func (q delayQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+
+func (q delayQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *delayQueue) Push(x interface{}) { *q = append(*q, x.(delayed)) }
+
+func (q *delayQueue) Pop() interface{} {
+	old := *q
+	d := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return d
+}
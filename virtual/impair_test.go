@@ -0,0 +1,107 @@
+package virtual
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/ethernet"
+)
+
+// transmit sends n numbered packets from a to b over an impaired link and
+// returns the payloads that arrive.
+func transmit(imp Impairment, n int) [][]byte {
+	a, b := NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	a = NewImpairedNIC(a, imp, Impairment{})
+	a.Start()
+	b.Start()
+	defer a.Close()
+	defer b.Close()
+
+	for i := 0; i < n; i++ {
+		a.Send() <- ethernet.Packet{
+			Destination: b.GetMAC(),
+			Source:      a.GetMAC(),
+			Payload:     []byte{byte(i), 0, 0, 0},
+		}
+	}
+
+	var payloads [][]byte
+	for {
+		select {
+		case p := <-b.Receive():
+			payloads = append(payloads, p.Payload)
+		case <-time.After(imp.Delay + imp.Jitter + 100*time.Millisecond):
+			return payloads
+		}
+	}
+}
+
+func sequence(payloads [][]byte) []int {
+	s := make([]int, len(payloads))
+	for i, p := range payloads {
+		s[i] = int(p[0])
+	}
+	return s
+}
+
+func TestImpairmentNone(t *testing.T) {
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, sequence(transmit(Impairment{}, 5)))
+}
+
+func TestImpairmentLoss(t *testing.T) {
+	assert.Empty(t, transmit(Impairment{Loss: 1}, 5))
+
+	imp := Impairment{Seed: 42, Loss: 0.5}
+	first := sequence(transmit(imp, 20))
+	second := sequence(transmit(imp, 20))
+	assert.Equal(t, first, second, "Loss is not deterministic")
+	assert.NotEmpty(t, first)
+	assert.True(t, len(first) < 20)
+}
+
+func TestImpairmentDuplication(t *testing.T) {
+	s := sequence(transmit(Impairment{Duplication: 1}, 3))
+	assert.Equal(t, []int{0, 0, 1, 1, 2, 2}, s)
+}
+
+func TestImpairmentCorruption(t *testing.T) {
+	payloads := transmit(Impairment{Seed: 1, Corruption: 1}, 10)
+	assert.Len(t, payloads, 10)
+	for i, p := range payloads {
+		var bits int
+		x := uint32(p[0]^byte(i))<<24 | uint32(p[1])<<16 | uint32(p[2])<<8 | uint32(p[3])
+		for ; x != 0; x &= x - 1 {
+			bits++
+		}
+		assert.Equal(t, 1, bits, "Packet %d does not have exactly one flipped bit", i)
+	}
+}
+
+func TestImpairmentReordering(t *testing.T) {
+	imp := Impairment{Seed: 7, Reordering: 0.3, ReorderDelay: 50 * time.Millisecond}
+	s := sequence(transmit(imp, 10))
+	assert.Equal(t, s, sequence(transmit(imp, 10)), "Reordering is not deterministic")
+	assert.False(t, sort.IntsAreSorted(s), "Packets were not reordered")
+	sort.Ints(s)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, s)
+}
+
+func TestImpairmentDelay(t *testing.T) {
+	a, b := NewWire(ethernet.MAC{0x02, 0, 0, 0, 0, 1}, ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	b = NewImpairedNIC(b, Impairment{}, Impairment{Delay: 50 * time.Millisecond, Jitter: 10 * time.Millisecond})
+	a.Start()
+	b.Start()
+	defer a.Close()
+	defer b.Close()
+
+	start := time.Now()
+	a.Send() <- ethernet.Packet{Destination: b.GetMAC(), Source: a.GetMAC()}
+	select {
+	case <-b.Receive():
+		assert.True(t, time.Since(start) >= 40*time.Millisecond, "Packet was not delayed")
+	case <-time.After(time.Second):
+		t.Fatal("Delayed packet was not received")
+	}
+}
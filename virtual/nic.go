@@ -0,0 +1,81 @@
+package virtual
+
+import (
+	"sync"
+
+	"github.com/unigornel/go-tcpip/ethernet"
+)
+
+// DefaultQueueLength is the number of received packets a virtual NIC
+// buffers before it starts dropping packets.
+const DefaultQueueLength = 64
+
+type nic struct {
+	mac ethernet.MAC
+	tx  chan ethernet.Packet
+	rx  chan ethernet.Packet
+	sw  *fabric
+
+	lock   sync.Mutex
+	closed bool
+}
+
+func newNIC(mac ethernet.MAC, sw *fabric) *nic {
+	return &nic{
+		mac: mac,
+		tx:  make(chan ethernet.Packet),
+		rx:  make(chan ethernet.Packet, DefaultQueueLength),
+		sw:  sw,
+	}
+}
+
+func (nic *nic) Start() {
+	go nic.sendAll()
+}
+
+func (nic *nic) Close() {
+	nic.sw.detach(nic)
+
+	nic.lock.Lock()
+	defer nic.lock.Unlock()
+	if !nic.closed {
+		nic.closed = true
+		close(nic.tx)
+		close(nic.rx)
+	}
+}
+
+func (nic *nic) Send() chan<- ethernet.Packet {
+	return nic.tx
+}
+
+func (nic *nic) Receive() <-chan ethernet.Packet {
+	return nic.rx
+}
+
+func (nic *nic) GetMAC() ethernet.MAC {
+	return nic.mac
+}
+
+func (nic *nic) sendAll() {
+	for p := range nic.tx {
+		nic.sw.forward(nic, p)
+	}
+}
+
+// deliver queues a packet for reception.
+//
+// The packet is dropped if the NIC is closed or if its queue is full.
+func (nic *nic) deliver(p ethernet.Packet) {
+	p.Payload = append([]byte(nil), p.Payload...)
+
+	nic.lock.Lock()
+	defer nic.lock.Unlock()
+	if nic.closed {
+		return
+	}
+	select {
+	case nic.rx <- p:
+	default:
+	}
+}
@@ -0,0 +1,107 @@
+package virtual
+
+import (
+	"sync"
+
+	"github.com/unigornel/go-tcpip/ethernet"
+)
+
+// Switch is an in-memory Ethernet segment connecting virtual NICs.
+type Switch interface {
+	// NewNIC creates a new virtual NIC that is attached to the switch.
+	//
+	// The NIC must be started before it forwards the packets it is sent.
+	// Closing the NIC detaches it from the switch.
+	NewNIC(mac ethernet.MAC) ethernet.NIC
+}
+
+type fabric struct {
+	learning bool
+
+	lock  sync.RWMutex
+	nics  map[*nic]struct{}
+	table map[ethernet.MAC]*nic
+}
+
+// NewHub creates a switch that floods every packet to all other NICs.
+func NewHub() Switch {
+	return newFabric(false)
+}
+
+// NewSwitch creates a learning switch.
+//
+// The switch learns on which NIC a MAC address lives from the source
+// address of the packets it forwards. Unicast packets to a known address
+// are only delivered to that NIC. Other packets are flooded.
+func NewSwitch() Switch {
+	return newFabric(true)
+}
+
+// NewWire creates two virtual NICs that are directly connected.
+func NewWire(a, b ethernet.MAC) (ethernet.NIC, ethernet.NIC) {
+	hub := newFabric(false)
+	return hub.NewNIC(a), hub.NewNIC(b)
+}
+
+func newFabric(learning bool) *fabric {
+	return &fabric{
+		learning: learning,
+		nics:     make(map[*nic]struct{}),
+		table:    make(map[ethernet.MAC]*nic),
+	}
+}
+
+func (sw *fabric) NewNIC(mac ethernet.MAC) ethernet.NIC {
+	n := newNIC(mac, sw)
+
+	sw.lock.Lock()
+	sw.nics[n] = struct{}{}
+	sw.lock.Unlock()
+
+	return n
+}
+
+func (sw *fabric) detach(n *nic) {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+
+	delete(sw.nics, n)
+	for mac, m := range sw.table {
+		if m == n {
+			delete(sw.table, mac)
+		}
+	}
+}
+
+func (sw *fabric) forward(from *nic, p ethernet.Packet) {
+	if sw.learning && !isGroup(p.Source) {
+		sw.lock.Lock()
+		if _, ok := sw.nics[from]; ok {
+			sw.table[p.Source] = from
+		}
+		sw.lock.Unlock()
+	}
+
+	sw.lock.RLock()
+	defer sw.lock.RUnlock()
+
+	if sw.learning && !isGroup(p.Destination) {
+		if to, ok := sw.table[p.Destination]; ok {
+			if to != from {
+				to.deliver(p)
+			}
+			return
+		}
+	}
+
+	for to := range sw.nics {
+		if to != from {
+			to.deliver(p)
+		}
+	}
+}
+
+// isGroup checks whether a MAC address is a broadcast or multicast address.
+func isGroup(mac ethernet.MAC) bool {
+	return mac[0]&0x01 != 0
+}
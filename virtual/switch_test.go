@@ -0,0 +1,148 @@
+package virtual
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unigornel/go-tcpip/ethernet"
+	"github.com/unigornel/go-tcpip/icmp"
+	"github.com/unigornel/go-tcpip/ipv4"
+	"github.com/unigornel/go-tcpip/udp"
+)
+
+type stack struct {
+	nic     ethernet.NIC
+	address ipv4.Address
+	arp     ipv4.ARP
+	ip      ipv4.Layer
+	icmp    icmp.Layer
+	udp     udp.Layer
+}
+
+func newStack(sw Switch, mac ethernet.MAC, address string) *stack {
+	s := &stack{nic: sw.NewNIC(mac)}
+	s.address, _ = ipv4.NewAddress(address)
+	netmask, _ := ipv4.NewAddress("255.255.255.0")
+
+	eth := ethernet.NewLayer(s.nic)
+	s.arp = ipv4.NewARP(mac, s.address, eth)
+	router := ipv4.NewRouter(s.arp, s.address, netmask, nil)
+	s.ip = ipv4.NewLayer(s.address, router, eth)
+	s.icmp = icmp.NewLayer(s.ip)
+	s.udp = udp.NewLayer(s.ip)
+
+	s.nic.Start()
+	return s
+}
+
+func receive(t *testing.T, c <-chan ethernet.Packet) (ethernet.Packet, bool) {
+	select {
+	case p := <-c:
+		return p, true
+	case <-time.After(100 * time.Millisecond):
+		return ethernet.Packet{}, false
+	}
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	a := hub.NewNIC(ethernet.MAC{0x02, 0, 0, 0, 0, 1})
+	b := hub.NewNIC(ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	c := hub.NewNIC(ethernet.MAC{0x02, 0, 0, 0, 0, 3})
+	for _, nic := range []ethernet.NIC{a, b, c} {
+		nic.Start()
+		defer nic.Close()
+	}
+
+	p := ethernet.Packet{
+		Destination: b.GetMAC(),
+		Source:      a.GetMAC(),
+		EtherType:   ethernet.EtherTypeIPv4,
+		Payload:     []byte{1, 2, 3},
+	}
+	a.Send() <- p
+
+	for _, nic := range []ethernet.NIC{b, c} {
+		q, ok := receive(t, nic.Receive())
+		assert.True(t, ok, "Hub did not flood packet to %v", nic.GetMAC())
+		assert.Equal(t, p, q)
+	}
+	_, ok := receive(t, a.Receive())
+	assert.False(t, ok, "Hub sent packet back to its source")
+}
+
+func TestSwitch(t *testing.T) {
+	sw := NewSwitch()
+	a := sw.NewNIC(ethernet.MAC{0x02, 0, 0, 0, 0, 1})
+	b := sw.NewNIC(ethernet.MAC{0x02, 0, 0, 0, 0, 2})
+	c := sw.NewNIC(ethernet.MAC{0x02, 0, 0, 0, 0, 3})
+	for _, nic := range []ethernet.NIC{a, b, c} {
+		nic.Start()
+		defer nic.Close()
+	}
+
+	// The switch does not know b yet, so the packet is flooded.
+	a.Send() <- ethernet.Packet{Destination: b.GetMAC(), Source: a.GetMAC()}
+	_, ok := receive(t, b.Receive())
+	assert.True(t, ok)
+	_, ok = receive(t, c.Receive())
+	assert.True(t, ok)
+
+	// The switch has learned a, so the reply is only sent to a.
+	b.Send() <- ethernet.Packet{Destination: a.GetMAC(), Source: b.GetMAC()}
+	_, ok = receive(t, a.Receive())
+	assert.True(t, ok)
+	_, ok = receive(t, c.Receive())
+	assert.False(t, ok, "Switch flooded a packet to a learned address")
+
+	// Broadcasts are always flooded.
+	c.Send() <- ethernet.Packet{Destination: ethernet.Broadcast, Source: c.GetMAC()}
+	_, ok = receive(t, a.Receive())
+	assert.True(t, ok)
+	_, ok = receive(t, b.Receive())
+	assert.True(t, ok)
+}
+
+func TestStacks(t *testing.T) {
+	sw := NewSwitch()
+	a := newStack(sw, ethernet.MAC{0x02, 0, 0, 0, 0, 1}, "10.0.0.1")
+	b := newStack(sw, ethernet.MAC{0x02, 0, 0, 0, 0, 2}, "10.0.0.2")
+	defer a.nic.Close()
+	defer b.nic.Close()
+
+	// ARP
+	mac, err := a.arp.Resolve(b.address)
+	assert.Nil(t, err)
+	assert.Equal(t, b.nic.GetMAC(), mac)
+
+	// ICMP
+	replies := a.icmp.Packets(icmp.EchoReplyType)
+	request := icmp.NewEchoRequest(1, 2, []byte("ping"))
+	request.Address = b.address
+	assert.Nil(t, a.icmp.Send(request))
+	select {
+	case reply := <-replies:
+		assert.Equal(t, b.address, reply.Address)
+		assert.Equal(t, request.Data, reply.Data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("No echo reply received")
+	}
+
+	// UDP
+	packets := b.udp.Packets(7)
+	datagram := udp.Packet{
+		Header:  udp.Header{SourcePort: 1234, DestinationPort: 7, Length: 8 + 5},
+		Payload: []byte("hello"),
+		Address: b.address,
+	}
+	assert.Nil(t, a.udp.Send(datagram))
+	select {
+	case p := <-packets:
+		assert.Equal(t, a.address, p.Address)
+		assert.Equal(t, uint16(1234), p.SourcePort)
+		assert.Equal(t, []byte("hello"), p.Payload)
+	case <-time.After(5 * time.Second):
+		t.Fatal("No UDP packet received")
+	}
+}